@@ -0,0 +1,64 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+)
+
+// hopByHopHeaders are the connection-level headers always kept by the request allowlist,
+// the server and proxies in front of the upstream handle them. stripHopByHop removes them.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// compileAllowlist returns the set of allowed canonical header names, or nil when the list is empty.
+func compileAllowlist(names []string) (map[string]bool, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	allowed := make(map[string]bool, len(names)+len(hopByHopHeaders))
+	for _, name := range hopByHopHeaders {
+		allowed[name] = true
+	}
+	for _, name := range names {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("%w %q", ErrInvalidHeaderName, name)
+		}
+		allowed[textproto.CanonicalMIMEHeaderKey(name)] = true
+	}
+
+	return allowed, nil
+}
+
+// filterRequestHeaders removes the client headers missing from the allowlist.
+func (p *Plugin) filterRequestHeaders(header http.Header) {
+	for key := range header {
+		if !p.requestAllowlist[textproto.CanonicalMIMEHeaderKey(key)] {
+			delete(header, key)
+		}
+	}
+}
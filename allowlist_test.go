@@ -0,0 +1,92 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestRequestHeaderAllowlist(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaderAllowlist = []string{"accept", "Authorization"}
+	cfg.RequestHeaders["X-Forwarded-Proto"] = "https"
+	cfg.RequestHeaders["X-Copied"] = `{{ header "X-Smuggled" }}`
+
+	var got http.Header
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got = req.Header.Clone()
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("Connection", "keep-alive")
+	req.Header.Set("X-Smuggled", "value")
+	req.Header.Set("X-Forwarded-Proto", "http")
+	// Non-canonical keys are matched by their canonical form
+	req.Header["accept-language"] = []string{"en"}
+
+	newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+
+	expected := map[string]string{
+		"Accept":        "text/html",
+		"Authorization": "Bearer token",
+		"Connection":    "keep-alive",
+		// Dropped client values are replaced by the configured ones
+		"X-Forwarded-Proto": "https",
+	}
+	for name, value := range expected {
+		if got.Get(name) != value {
+			t.Errorf("Expected %s %q, got %q", name, value, got.Get(name))
+		}
+	}
+
+	for _, name := range []string{"X-Smuggled", "X-Copied", "accept-language"} {
+		if _, ok := got[name]; ok {
+			t.Errorf("Expected %s to be removed, got %q", name, got[name])
+		}
+	}
+}
+
+func TestRequestHeaderAllowlist_Empty(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assertHeader(t, req, "X-Client", "value")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("X-Client", "value")
+
+	newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestRequestHeaderAllowlist_InvalidName(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaderAllowlist = []string{"X Bad"}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	_, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin")
+	if !errors.Is(err, add_missing_headers.ErrInvalidHeaderName) {
+		t.Errorf("Expected %v, got %v", add_missing_headers.ErrInvalidHeaderName, err)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import "net/http"
+
+// ApplyMissingHeaders adds the headers missing from target, like the middleware does for
+// requestHeaders and responseHeaders. With strict, a header is missing when it is absent;
+// otherwise it is also missing when its first value is empty, as with strictHeaderCheck.
+// Values are templates when they contain "{{", rendered without a request. Values with line
+// breaks are skipped. Invalid or colliding header names are reported as an error, and nothing
+// is added then.
+func ApplyMissingHeaders(target http.Header, headers map[string]string, strict bool) error {
+	entries, err := compileHeaders(headers)
+	if err != nil {
+		return err
+	}
+
+	p := &Plugin{
+		name:              "ApplyMissingHeaders",
+		strictHeaderCheck: strict,
+		unsafeValueAction: unsafeValueSkip,
+	}
+
+	data := &templateData{}
+	if setsReadHeaders([]headerSet{{"headers", entries}}) {
+		data.header = target.Clone()
+	}
+
+	p.addMissingHeaders(target, entries, data)
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestApplyMissingHeaders(t *testing.T) {
+	var logs bytes.Buffer
+	defer add_missing_headers.SetLogOutput(&logs)()
+
+	headers := map[string]string{
+		"x-missing":  "added",
+		"X-Empty":    "filled",
+		"X-Existing": "ignored",
+		"X-Copy":     `{{ header "X-Existing" }}-copy`,
+		"X-Unsafe":   "a\r\nb",
+	}
+
+	testCases := []struct {
+		name     string
+		strict   bool
+		expected http.Header
+	}{
+		{"Strict", true, http.Header{
+			"X-Missing":  {"added"},
+			"X-Empty":    {""},
+			"X-Existing": {"kept"},
+			"X-Copy":     {"kept-copy"},
+		}},
+		{"Loose", false, http.Header{
+			"X-Missing":  {"added"},
+			"X-Empty":    {"filled"},
+			"X-Existing": {"kept"},
+			"X-Copy":     {"kept-copy"},
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			target := http.Header{
+				"X-Empty":    {""},
+				"X-Existing": {"kept"},
+			}
+
+			if err := add_missing_headers.ApplyMissingHeaders(target, headers, tc.strict); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(target, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, target)
+			}
+		})
+	}
+}
+
+func TestApplyMissingHeaders_Invalid(t *testing.T) {
+	target := http.Header{}
+	headers := map[string]string{"x-test": "one", "X-Test": "two"}
+
+	err := add_missing_headers.ApplyMissingHeaders(target, headers, true)
+	if !errors.Is(err, add_missing_headers.ErrHeaderNameCollision) {
+		t.Errorf("Expected %v, got %v", add_missing_headers.ErrHeaderNameCollision, err)
+	}
+	if len(target) != 0 {
+		t.Errorf("Expected no header to be added, got %v", target)
+	}
+}
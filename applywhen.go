@@ -0,0 +1,85 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ApplyWhen restricts the middleware to requests matching all of the configured conditions.
+// Empty conditions always match.
+type ApplyWhen struct {
+	Methods    []string `json:"methods,omitempty" yaml:"methods,omitempty"`
+	PathPrefix string   `json:"pathPrefix,omitempty" yaml:"pathPrefix,omitempty"`
+	Header     string   `json:"header,omitempty" yaml:"header,omitempty"`
+}
+
+// applyCondition is a compiled ApplyWhen.
+type applyCondition struct {
+	methods    map[string]bool
+	pathPrefix string
+	header     string
+}
+
+// compileApplyWhen compiles the apply conditions, returning nil when none are configured.
+func compileApplyWhen(when ApplyWhen) (*applyCondition, error) {
+	if len(when.Methods) == 0 && when.PathPrefix == "" && when.Header == "" {
+		return nil, nil
+	}
+
+	if when.PathPrefix != "" && !strings.HasPrefix(when.PathPrefix, "/") {
+		return nil, fmt.Errorf("pathPrefix %q must start with '/'", when.PathPrefix)
+	}
+
+	if when.Header != "" && !validHeaderName(when.Header) {
+		return nil, fmt.Errorf("header: %w %q", ErrInvalidHeaderName, when.Header)
+	}
+
+	condition := &applyCondition{
+		pathPrefix: when.PathPrefix,
+		header:     http.CanonicalHeaderKey(when.Header),
+	}
+
+	if len(when.Methods) > 0 {
+		condition.methods = make(map[string]bool, len(when.Methods))
+		for _, method := range when.Methods {
+			if method == "" {
+				return nil, fmt.Errorf("empty method")
+			}
+			condition.methods[strings.ToUpper(method)] = true
+		}
+	}
+
+	return condition, nil
+}
+
+// matches reports whether the request satisfies every configured condition.
+func (c *applyCondition) matches(req *http.Request) bool {
+	if c.methods != nil && !c.methods[req.Method] {
+		return false
+	}
+
+	if c.pathPrefix != "" && !strings.HasPrefix(req.URL.Path, c.pathPrefix) {
+		return false
+	}
+
+	if c.header != "" && req.Header.Values(c.header) == nil {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,115 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestApplyWhen(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Test"] = "request"
+	cfg.ResponseHeaders["X-Test"] = "response"
+	cfg.ApplyWhen = add_missing_headers.ApplyWhen{
+		Methods:    []string{"get", "POST"},
+		PathPrefix: "/api/",
+		Header:     "authorization",
+	}
+
+	testCases := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		expected      bool
+	}{
+		{"All conditions match", http.MethodGet, "/api/users", "Bearer token", true},
+		{"Other listed method", http.MethodPost, "/api/users", "Bearer token", true},
+		{"Method mismatch", http.MethodDelete, "/api/users", "Bearer token", false},
+		{"Path mismatch", http.MethodGet, "/static/app.js", "Bearer token", false},
+		{"Header missing", http.MethodGet, "/api/users", "", false},
+		{"Nothing matches", http.MethodPut, "/", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expectedRequest, expectedResponse := "", ""
+			if tc.expected {
+				expectedRequest, expectedResponse = "request", "response"
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				assertHeader(t, req, "X-Test", expectedRequest)
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, "http://localhost"+tc.path, nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "X-Test", expectedResponse)
+		})
+	}
+}
+
+func TestApplyWhen_PartialConfig(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["X-Test"] = "response"
+	cfg.ApplyWhen = add_missing_headers.ApplyWhen{PathPrefix: "/api/"}
+
+	testCases := []struct {
+		name     string
+		method   string
+		path     string
+		expected string
+	}{
+		{"Any method under prefix", http.MethodDelete, "/api/users", "response"},
+		{"Outside prefix", http.MethodGet, "/", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, "http://localhost"+tc.path, nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "X-Test", tc.expected)
+		})
+	}
+}
+
+func TestApplyWhen_InvalidPathPrefix(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ApplyWhen = add_missing_headers.ApplyWhen{PathPrefix: "api"}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+		t.Error("expected an error for a path prefix without a leading slash")
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// injectedHeaders returns the sorted names of the headers set in after that were missing
+// or empty in before. Headers whose existing value was changed are not listed.
+func injectedHeaders(before, after http.Header) []string {
+	var injected []string
+	for key, values := range after {
+		if len(values) > 0 && values[0] != "" && before.Get(key) == "" {
+			injected = append(injected, key)
+		}
+	}
+	sort.Strings(injected)
+	return injected
+}
+
+// addAuditHeader lists the request headers injected by the request phase in the response.
+func (p *Plugin) addAuditHeader(header http.Header, injected []string) {
+	if len(injected) > 0 {
+		header.Set(p.auditHeader, strings.Join(injected, ","))
+	}
+}
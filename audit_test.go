@@ -0,0 +1,67 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestAuditInjectedHeader(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Added"] = "added"
+	cfg.RequestHeaders["X-Existing"] = "ignored"
+	cfg.RequestHeaders["X-Empty"] = "filled"
+	cfg.ContextHeaders = map[string]string{"X-Tenant": "tenant"}
+	cfg.RequestHeaderTransforms = map[string]string{"X-Client": "lower"}
+	cfg.StrictHeaderCheck = false
+	cfg.AuditInjectedHeader = "x-injected-headers"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req.Header.Set("X-Set-By-Upstream", "1")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("X-Existing", "kept")
+	req.Header.Set("X-Empty", "")
+	req.Header.Set("X-Client", "UPPER")
+
+	recorder := httptest.NewRecorder()
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	// Transformed values, existing headers and absent context values are not injections
+	assertResponseHeader(t, recorder, "X-Injected-Headers", "X-Added,X-Empty")
+}
+
+func TestAuditInjectedHeader_NothingInjected(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Existing"] = "ignored"
+	cfg.AuditInjectedHeader = "X-Injected-Headers"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("X-Existing", "kept")
+
+	recorder := httptest.NewRecorder()
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	if values := recorder.Header().Values("X-Injected-Headers"); values != nil {
+		t.Errorf("Expected no audit header, got %q", values)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// base64Prefix marks a configured header value as standard base64-encoded.
+const base64Prefix = "base64:"
+
+// decodeValue decodes a value carrying the base64 prefix, reporting whether it was encoded.
+// Other values are returned unchanged.
+func decodeValue(value string) (string, bool, error) {
+	encoded, ok := strings.CutPrefix(value, base64Prefix)
+	if !ok {
+		return value, false, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", true, fmt.Errorf("invalid base64 value: %w", err)
+	}
+	return string(decoded), true, nil
+}
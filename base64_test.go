@@ -0,0 +1,65 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestBase64Values(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders = map[string]string{
+		"X-Quoted":   "base64:" + base64.StdEncoding.EncodeToString([]byte(`say "hi": now`)),
+		"X-Template": "base64:" + base64.StdEncoding.EncodeToString([]byte("{{ .Host }}")),
+		"X-Plain":    "base64",
+	}
+	cfg.ResponseHeaders = map[string]string{
+		"X-Quoted": "base64: " + base64.StdEncoding.EncodeToString([]byte("a:b")),
+	}
+
+	var req *http.Request
+	next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		req = r
+	})
+
+	recorder := httptest.NewRecorder()
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+
+	assertHeader(t, req, "X-Quoted", `say "hi": now`)
+	assertHeader(t, req, "X-Template", "{{ .Host }}")
+	assertHeader(t, req, "X-Plain", "base64")
+	assertResponseHeader(t, recorder, "X-Quoted", "a:b")
+}
+
+func TestBase64Values_Invalid(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders = map[string]string{"X-Secret": "base64:not base64!"}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	_, err := add_missing_headers.New(context.Background(), next, cfg, "test")
+	if err == nil {
+		t.Fatal("Expected an error for an invalid base64 value")
+	}
+	if !strings.Contains(err.Error(), "X-Secret") {
+		t.Errorf("Expected the error to name the header, got %q", err)
+	}
+}
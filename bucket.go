@@ -0,0 +1,125 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+)
+
+// HashBuckets assigns every request to one of the weighted buckets by hashing
+// its method, path and, when configured, the value of Header.
+type HashBuckets struct {
+	Header  string       `json:"header,omitempty" yaml:"header,omitempty"`
+	Buckets []HashBucket `json:"buckets,omitempty" yaml:"buckets,omitempty"`
+}
+
+// HashBucket is a share of the requests, proportional to Weight, receiving its own headers.
+type HashBucket struct {
+	Weight          int               `json:"weight,omitempty" yaml:"weight,omitempty"`
+	RequestHeaders  map[string]string `json:"requestHeaders,omitempty" yaml:"requestHeaders,omitempty"`
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty" yaml:"responseHeaders,omitempty"`
+}
+
+// hashBucket is a compiled HashBucket, upper is the exclusive end of its hash range.
+type hashBucket struct {
+	upper           uint32
+	requestHeaders  []headerEntry
+	responseHeaders []headerEntry
+}
+
+// hashBuckets is a compiled HashBuckets.
+type hashBuckets struct {
+	header  string
+	total   uint32
+	buckets []hashBucket
+}
+
+// compileHashBuckets compiles the buckets, returning nil when none are configured.
+func compileHashBuckets(config HashBuckets) (*hashBuckets, error) {
+	if len(config.Buckets) == 0 {
+		return nil, nil
+	}
+
+	if config.Header != "" && !validHeaderName(config.Header) {
+		return nil, fmt.Errorf("header: %w %q", ErrInvalidHeaderName, config.Header)
+	}
+
+	compiled := &hashBuckets{
+		header:  http.CanonicalHeaderKey(config.Header),
+		buckets: make([]hashBucket, 0, len(config.Buckets)),
+	}
+
+	// Summed as uint64 so that an overflow of the uint32 hash range is caught
+	// instead of silently wrapping
+	var total uint64
+	for i, b := range config.Buckets {
+		if b.Weight <= 0 {
+			return nil, fmt.Errorf("bucket %d: %w: must be positive, got %d", i, ErrInvalidWeight, b.Weight)
+		}
+		if uint64(b.Weight) > math.MaxUint32 {
+			return nil, fmt.Errorf("bucket %d: %w: %d is above %d", i, ErrInvalidWeight, b.Weight, uint64(math.MaxUint32))
+		}
+		total += uint64(b.Weight)
+		if total > math.MaxUint32 {
+			return nil, fmt.Errorf("bucket %d: %w: total weight is above %d", i, ErrInvalidWeight, uint64(math.MaxUint32))
+		}
+
+		requestHeaders, err := compileHeaders(b.RequestHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("bucket %d: requestHeaders: %w", i, err)
+		}
+
+		responseHeaders, err := compileHeaders(b.ResponseHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("bucket %d: responseHeaders: %w", i, err)
+		}
+
+		compiled.buckets = append(compiled.buckets, hashBucket{
+			upper:           uint32(total),
+			requestHeaders:  requestHeaders,
+			responseHeaders: responseHeaders,
+		})
+	}
+
+	compiled.total = uint32(total)
+	if compiled.total == 0 {
+		return nil, fmt.Errorf("%w: total weight is 0", ErrInvalidWeight)
+	}
+
+	return compiled, nil
+}
+
+// pick returns the bucket of the request, always the same for the same inputs.
+func (h *hashBuckets) pick(req *http.Request) *hashBucket {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(req.Method))
+	_, _ = hash.Write([]byte{0})
+	_, _ = hash.Write([]byte(req.URL.Path))
+	if h.header != "" {
+		_, _ = hash.Write([]byte{0})
+		_, _ = hash.Write([]byte(req.Header.Get(h.header)))
+	}
+
+	point := hash.Sum32() % h.total
+	for i := range h.buckets {
+		if point < h.buckets[i].upper {
+			return &h.buckets[i]
+		}
+	}
+	return nil
+}
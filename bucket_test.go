@@ -0,0 +1,107 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func newBucketConfig() *add_missing_headers.Config {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.HashBuckets = add_missing_headers.HashBuckets{
+		Header: "X-User-Id",
+		Buckets: []add_missing_headers.HashBucket{
+			{
+				Weight:          1,
+				RequestHeaders:  map[string]string{"X-Experiment": "a"},
+				ResponseHeaders: map[string]string{"X-Bucket": "a"},
+			},
+			{
+				Weight:          3,
+				RequestHeaders:  map[string]string{"X-Experiment": "b"},
+				ResponseHeaders: map[string]string{"X-Bucket": "b"},
+			},
+		},
+	}
+	return cfg
+}
+
+func TestHashBuckets_Deterministic(t *testing.T) {
+	var experiment string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		experiment = req.Header.Get("X-Experiment")
+	})
+	handler := newTestHandler(t, newBucketConfig(), next)
+
+	for i := 0; i < 50; i++ {
+		path := fmt.Sprintf("/items/%d", i)
+
+		var first string
+		for j := 0; j < 3; j++ {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil)
+			req.Header.Set("X-User-Id", "42")
+			handler.ServeHTTP(recorder, req)
+
+			bucket := recorder.Header().Get("X-Bucket")
+			if bucket == "" || bucket != experiment {
+				t.Fatalf("%s: request bucket %q and response bucket %q differ", path, experiment, bucket)
+			}
+			if j == 0 {
+				first = bucket
+			} else if bucket != first {
+				t.Fatalf("%s: bucket changed from %q to %q", path, first, bucket)
+			}
+		}
+	}
+}
+
+func TestHashBuckets_Distribution(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler := newTestHandler(t, newBucketConfig(), next)
+
+	const requests = 4000
+	counts := make(map[string]int)
+	for i := 0; i < requests; i++ {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/items", nil)
+		req.Header.Set("X-User-Id", fmt.Sprintf("user-%d", i))
+		handler.ServeHTTP(recorder, req)
+
+		counts[recorder.Header().Get("X-Bucket")]++
+	}
+
+	// Bucket "a" has a quarter of the total weight
+	share := float64(counts["a"]) / requests
+	if share < 0.20 || share > 0.30 || counts["a"]+counts["b"] != requests {
+		t.Errorf("Unexpected distribution: %v", counts)
+	}
+}
+
+func TestHashBuckets_InvalidWeight(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.HashBuckets.Buckets = []add_missing_headers.HashBucket{{Weight: 0}}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+		t.Error("expected an error for a non-positive weight")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	// bypassModeAny bypasses the middleware when any bypass header matches.
+	bypassModeAny = "any"
+	// bypassModeAll bypasses the middleware when every bypass header matches.
+	bypassModeAll = "all"
+
+	// bypassScopeAll skips both phases for bypassed requests.
+	bypassScopeAll = "all"
+	// bypassScopeRequest only skips the request phase for bypassed requests.
+	bypassScopeRequest = "request"
+	// bypassScopeResponse only skips the response phase for bypassed requests.
+	bypassScopeResponse = "response"
+)
+
+// contextKey is the type of the context keys defined by this package.
+type contextKey struct {
+	name string
+}
+
+// BypassReasonKey is the request context key holding a BypassReason when
+// recordBypassReason is enabled and a bypass header matched.
+var BypassReasonKey = &contextKey{"bypass-reason"}
+
+// BypassReason describes the bypass header that caused a request to skip the middleware.
+type BypassReason struct {
+	// Header is the canonical name of the matched bypass header.
+	Header string
+	// Value is the request value of the matched header, empty for a missing header.
+	Value string
+}
+
+// matchBypass returns the first bypass condition matching the request: a bypass header
+// matching, then a bypassIfMissing header absent. In "all" mode, every condition must
+// match and the first one is returned.
+func (p *Plugin) matchBypass(req *http.Request) (BypassReason, bool) {
+	if p.bypassMode == bypassModeAll {
+		if len(p.bypassHeaders) == 0 && len(p.bypassIfMissing) == 0 {
+			return BypassReason{}, false
+		}
+		for i := range p.bypassHeaders {
+			if !p.bypassHeaders[i].matches(req.Header) {
+				return BypassReason{}, false
+			}
+		}
+		for _, name := range p.bypassIfMissing {
+			if req.Header.Values(name) != nil {
+				return BypassReason{}, false
+			}
+		}
+		if len(p.bypassHeaders) > 0 {
+			return p.bypassHeaders[0].reason(req), true
+		}
+		return BypassReason{Header: p.bypassIfMissing[0]}, true
+	}
+
+	for i := range p.bypassHeaders {
+		if p.bypassHeaders[i].matches(req.Header) {
+			return p.bypassHeaders[i].reason(req), true
+		}
+	}
+	for _, name := range p.bypassIfMissing {
+		if req.Header.Values(name) == nil {
+			return BypassReason{Header: name}, true
+		}
+	}
+	return BypassReason{}, false
+}
+
+// reason returns the bypass reason for the matched bypass header, with the value that matched.
+func (m *headerMatcher) reason(req *http.Request) BypassReason {
+	value, _ := m.match(req.Header)
+	return BypassReason{Header: m.name, Value: value}
+}
+
+// bypassedByIP reports whether the client IP belongs to one of the bypass networks.
+func (p *Plugin) bypassedByIP(req *http.Request) bool {
+	return len(p.bypassCIDRs) > 0 && containsIP(p.bypassCIDRs, p.clientIP(req))
+}
+
+// withBypassReason returns the request with the matched bypass condition recorded in its context.
+func withBypassReason(req *http.Request, reason BypassReason) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), BypassReasonKey, reason))
+}
@@ -0,0 +1,114 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// CIDRLabel associates a network range with a label.
+type CIDRLabel struct {
+	CIDR  string `json:"cidr,omitempty" yaml:"cidr,omitempty"`
+	Label string `json:"label,omitempty" yaml:"label,omitempty"`
+}
+
+// labeledNetwork is a parsed CIDRLabel.
+type labeledNetwork struct {
+	network *net.IPNet
+	label   string
+}
+
+// parseCIDRLabels parses labeled CIDRs, keeping the configured order.
+func parseCIDRLabels(labels []CIDRLabel) ([]labeledNetwork, error) {
+	networks := make([]labeledNetwork, 0, len(labels))
+	for _, l := range labels {
+		_, network, err := net.ParseCIDR(l.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", l.CIDR, err)
+		}
+		if l.Label == "" {
+			return nil, fmt.Errorf("missing label for CIDR %q", l.CIDR)
+		}
+		networks = append(networks, labeledNetwork{network: network, label: l.Label})
+	}
+	return networks, nil
+}
+
+// matchLabel returns the label of the first network containing ip.
+func matchLabel(networks []labeledNetwork, ip net.IP) (string, bool) {
+	if ip == nil {
+		return "", false
+	}
+	for _, n := range networks {
+		if n.network.Contains(ip) {
+			return n.label, true
+		}
+	}
+	return "", false
+}
+
+// parseCIDRs parses a list of CIDRs.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// containsIP reports whether any of the networks contains ip.
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the client IP, taken from the first valid X-Forwarded-For entry when
+// trustForwardedFor is set and from the remote address otherwise.
+func (p *Plugin) clientIP(req *http.Request) net.IP {
+	if p.trustForwardedFor {
+		for _, value := range req.Header.Values("X-Forwarded-For") {
+			for _, entry := range strings.Split(value, ",") {
+				if ip := net.ParseIP(strings.TrimSpace(entry)); ip != nil {
+					return ip
+				}
+			}
+		}
+	}
+	return remoteIP(req)
+}
+
+// remoteIP extracts the client IP from the request's remote address.
+func remoteIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		// RemoteAddr may not carry a port
+		host = req.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,145 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestCIDRLabels(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.CIDRLabelHeader = "X-Net"
+	cfg.CIDRLabels = []add_missing_headers.CIDRLabel{
+		{CIDR: "192.168.1.0/24", Label: "office"},
+		{CIDR: "192.168.0.0/16", Label: "campus"},
+		{CIDR: "2001:db8::/32", Label: "lab"},
+	}
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		spoofed    string
+		expected   string
+	}{
+		{"First matching label wins", "192.168.1.10:1234", "", "office"},
+		{"Broader range", "192.168.2.10:1234", "", "campus"},
+		{"IPv6 range", "[2001:db8::1]:1234", "", "lab"},
+		{"Outside all ranges", "203.0.113.7:1234", "", ""},
+		{"Spoofed label is removed", "203.0.113.7:1234", "office", ""},
+		{"Spoofed label is overwritten", "192.168.2.10:1234", "office", "campus"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				assertHeader(t, req, "X-Net", tc.expected)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.spoofed != "" {
+				req.Header.Set("X-Net", tc.spoofed)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+		})
+	}
+}
+
+func TestCIDRLabels_InvalidConfig(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header string
+		labels []add_missing_headers.CIDRLabel
+	}{
+		{"Invalid CIDR", "X-Net", []add_missing_headers.CIDRLabel{{CIDR: "10.0.0.0/33", Label: "office"}}},
+		{"Missing label", "X-Net", []add_missing_headers.CIDRLabel{{CIDR: "10.0.0.0/8"}}},
+		{"Missing header", "", []add_missing_headers.CIDRLabel{{CIDR: "10.0.0.0/8", Label: "office"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.CIDRLabelHeader = tc.header
+			cfg.CIDRLabels = tc.labels
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+				t.Error("Expected an error from New")
+			}
+		})
+	}
+}
+
+func TestBypassCIDRs(t *testing.T) {
+	testCases := []struct {
+		name              string
+		remoteAddr        string
+		forwardedFor      string
+		trustForwardedFor bool
+		bypassed          bool
+	}{
+		{"IPv4 inside range", "10.1.2.3:1234", "", false, true},
+		{"IPv4 outside range", "192.0.2.1:1234", "", false, false},
+		{"IPv6 inside range", "[fd00::1]:1234", "", false, true},
+		{"IPv6 outside range", "[2001:db8::1]:1234", "", false, false},
+		{"Forwarded for ignored by default", "192.0.2.1:1234", "10.1.2.3", false, false},
+		{"Trusted forwarded for", "192.0.2.1:1234", "10.1.2.3, 192.0.2.1", true, true},
+		{"Trusted forwarded for outside range", "10.1.2.3:1234", "192.0.2.7", true, false},
+		{"Invalid forwarded for falls back", "10.1.2.3:1234", "unknown", true, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeaders["X-Test"] = "test"
+			cfg.BypassCIDRs = []string{"10.0.0.0/8", "fd00::/8"}
+			cfg.TrustForwardedFor = tc.trustForwardedFor
+
+			expected := "test"
+			if tc.bypassed {
+				expected = ""
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				assertHeader(t, req, "X-Test", expected)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+		})
+	}
+}
+
+func TestBypassCIDRs_Invalid(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.BypassCIDRs = []string{"10.0.0.0/33"}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// ContextKey is the type of request context keys read by contextHeaders.
+// Middlewares feeding values should store them under ContextKey("name");
+// plain string keys are accepted as well.
+type ContextKey string
+
+// contextHeader is a request header filled from a request context value.
+type contextHeader struct {
+	key  string
+	name string
+}
+
+// compileContextHeaders compiles context headers, sorted by header name.
+func compileContextHeaders(config map[string]string) ([]contextHeader, error) {
+	canonical, err := canonicalizeHeaders(config)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make([]contextHeader, 0, len(canonical))
+	for key, name := range canonical {
+		if name == "" {
+			return nil, fmt.Errorf("header %q: empty context key", key)
+		}
+		headers = append(headers, contextHeader{key: key, name: name})
+	}
+
+	sort.Slice(headers, func(i, j int) bool { return headers[i].key < headers[j].key })
+
+	return headers, nil
+}
+
+// addContextHeaders adds missing request headers from string values of the request context.
+func (p *Plugin) addContextHeaders(req *http.Request) {
+	ctx := req.Context()
+	for _, h := range p.contextHeaders {
+		s, ok := contextString(ctx, h.name)
+		if !ok || !p.shouldAddHeader(req.Header, h.key) {
+			continue
+		}
+		if s, ok = p.checkValue(h.key, s); ok {
+			req.Header.Set(h.key, s)
+		}
+	}
+}
+
+// contextString returns the non-empty string stored under ContextKey(name), or else under name.
+func contextString(ctx context.Context, name string) (string, bool) {
+	value := ctx.Value(ContextKey(name))
+	if value == nil {
+		value = ctx.Value(name)
+	}
+
+	s, ok := value.(string)
+	return s, ok && s != ""
+}
+
+// addRouterHeader adds the router name stored under routerContextKey to the response,
+// when it is known and the header is missing.
+func (p *Plugin) addRouterHeader(header http.Header, req *http.Request) {
+	name, ok := contextString(req.Context(), p.routerContextKey)
+	if !ok || !p.shouldAddHeader(header, p.routerHeader) {
+		return
+	}
+	if name, ok = p.checkValue(p.routerHeader, name); ok {
+		header.Set(p.routerHeader, name)
+	}
+}
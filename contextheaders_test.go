@@ -0,0 +1,143 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+// plainKey mimics middlewares storing values under plain string keys.
+type plainKey = string
+
+func TestContextHeaders(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ContextHeaders = map[string]string{"x-tenant-id": "tenant"}
+
+	testCases := []struct {
+		name     string
+		ctx      func(ctx context.Context) context.Context
+		existing string
+		expected string
+	}{
+		{"Exported key", func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, add_missing_headers.ContextKey("tenant"), "acme")
+		}, "", "acme"},
+		{"Plain string key", func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, plainKey("tenant"), "acme")
+		}, "", "acme"},
+		{"Absent value", func(ctx context.Context) context.Context {
+			return ctx
+		}, "", ""},
+		{"Not a string", func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, add_missing_headers.ContextKey("tenant"), 42)
+		}, "", ""},
+		{"Existing header wins", func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, add_missing_headers.ContextKey("tenant"), "acme")
+		}, "other", "other"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var values []string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				values = req.Header.Values("X-Tenant-Id")
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req = req.WithContext(tc.ctx(req.Context()))
+			if tc.existing != "" {
+				req.Header.Set("X-Tenant-Id", tc.existing)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+
+			if tc.expected == "" && values != nil {
+				t.Errorf("Expected X-Tenant-Id to be absent, got %q", values)
+			}
+			if tc.expected != "" && (len(values) != 1 || values[0] != tc.expected) {
+				t.Errorf("Expected X-Tenant-Id %q, got %q", tc.expected, values)
+			}
+		})
+	}
+}
+
+func TestStampRouterHeader(t *testing.T) {
+	testCases := []struct {
+		name       string
+		headerName string
+		contextKey string
+		ctx        func(ctx context.Context) context.Context
+		existing   string
+		header     string
+		expected   string
+	}{
+		{"Typed key", "", "", func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, add_missing_headers.ContextKey("router"), "my-router@file")
+		}, "", "X-Router", "my-router@file"},
+		{"Plain string key", "", "", func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, plainKey("router"), "my-router@file")
+		}, "", "X-Router", "my-router@file"},
+		{"Custom context key", "", "routerName", func(ctx context.Context) context.Context {
+			ctx = context.WithValue(ctx, add_missing_headers.ContextKey("router"), "other@file")
+			return context.WithValue(ctx, add_missing_headers.ContextKey("routerName"), "my-router@file")
+		}, "", "X-Router", "my-router@file"},
+		{"Custom header name", "x-matched-router", "", func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, add_missing_headers.ContextKey("router"), "my-router@file")
+		}, "", "X-Matched-Router", "my-router@file"},
+		{"Absent value", "", "", func(ctx context.Context) context.Context {
+			return ctx
+		}, "", "X-Router", ""},
+		{"Absent custom key", "", "routerName", func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, add_missing_headers.ContextKey("router"), "my-router@file")
+		}, "", "X-Router", ""},
+		{"Existing header wins", "", "", func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, add_missing_headers.ContextKey("router"), "my-router@file")
+		}, "upstream", "X-Router", "upstream"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.StampRouterHeader = true
+			cfg.RouterHeaderName = tc.headerName
+			cfg.RouterContextKey = tc.contextKey
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tc.existing != "" {
+					rw.Header().Set(tc.header, tc.existing)
+				}
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req = req.WithContext(tc.ctx(req.Context()))
+
+			recorder := httptest.NewRecorder()
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			values := recorder.Header().Values(tc.header)
+			if tc.expected == "" && values != nil {
+				t.Errorf("Expected %s to be absent, got %q", tc.header, values)
+			}
+			if tc.expected != "" && (len(values) != 1 || values[0] != tc.expected) {
+				t.Errorf("Expected %s %q, got %q", tc.header, tc.expected, values)
+			}
+		})
+	}
+}
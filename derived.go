@@ -0,0 +1,102 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"sort"
+)
+
+// DerivedHeader computes a response header from another response header.
+// Template is a Go template where {{ .Value }} is the source header value;
+// when empty, the source value is copied as-is.
+type DerivedHeader struct {
+	Source   string `json:"source,omitempty" yaml:"source,omitempty"`
+	Template string `json:"template,omitempty" yaml:"template,omitempty"`
+}
+
+// derivedHeader is a compiled DerivedHeader.
+type derivedHeader struct {
+	key    string
+	source string
+	tmpl   *valueTemplate
+}
+
+// compileDerivedHeaders compiles derived headers, sorted by target header name.
+func compileDerivedHeaders(config map[string]DerivedHeader) ([]derivedHeader, error) {
+	seen := make(map[string]string, len(config))
+	derived := make([]derivedHeader, 0, len(config))
+	for key, d := range config {
+		canonicalKey, err := canonicalHeaderName(seen, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if d.Source == "" {
+			return nil, fmt.Errorf("header %q: missing source", key)
+		}
+
+		entry := derivedHeader{key: canonicalKey, source: textproto.CanonicalMIMEHeaderKey(d.Source)}
+		if d.Template != "" {
+			tmpl, err := compileTemplate(canonicalKey, d.Template)
+			if err != nil {
+				return nil, fmt.Errorf("header %q: invalid template: %w", key, err)
+			}
+			entry.tmpl = tmpl
+		}
+
+		derived = append(derived, entry)
+	}
+
+	sort.Slice(derived, func(i, j int) bool { return derived[i].key < derived[j].key })
+
+	return derived, nil
+}
+
+// addDerivedHeaders adds missing headers computed from the upstream's response headers.
+// Sources are read before any derived header is set, so derived headers can't feed each other.
+func (p *Plugin) addDerivedHeaders(header http.Header, data *templateData) {
+	if len(p.derivedHeaders) == 0 {
+		return
+	}
+
+	encoded := len(p.skipWhenEncoded) > 0 && isEncoded(header)
+	values := make([]string, len(p.derivedHeaders))
+	for i, d := range p.derivedHeaders {
+		if header.Values(d.source) == nil || !p.shouldAddHeader(header, d.key) || (encoded && p.skipWhenEncoded[d.key]) {
+			continue
+		}
+
+		value := header.Get(d.source)
+		if d.tmpl != nil {
+			rendered, err := d.tmpl.render(&templateData{Value: value, Status: data.Status, req: data.req, header: data.header, captures: data.captures, cache: data.cache, cacheKey: data.cacheKey})
+			if err != nil {
+				continue
+			}
+			value = rendered
+		}
+		if value, ok := p.checkValue(d.key, value); ok {
+			values[i] = value
+		}
+	}
+
+	for i, d := range p.derivedHeaders {
+		if values[i] != "" {
+			header.Set(d.key, values[i])
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestResponseHeaderFromResponseHeader(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaderFromResponseHeader = map[string]add_missing_headers.DerivedHeader{
+		"Content-Range": {Source: "X-Total-Count", Template: "items */{{ .Value }}"},
+		"X-Count-Copy":  {Source: "x-total-count"},
+	}
+
+	testCases := []struct {
+		name          string
+		totalCount    string
+		expectedRange string
+		expectedCopy  string
+	}{
+		{"Source present", "42", "items */42", "42"},
+		{"Source absent", "", "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tc.totalCount != "" {
+					rw.Header().Set("X-Total-Count", tc.totalCount)
+				}
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "Content-Range", tc.expectedRange)
+			assertResponseHeader(t, recorder, "X-Count-Copy", tc.expectedCopy)
+		})
+	}
+}
+
+func TestResponseHeaderFromResponseHeader_ExistingTarget(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaderFromResponseHeader = map[string]add_missing_headers.DerivedHeader{
+		"Content-Range": {Source: "X-Total-Count", Template: "items */{{ .Value }}"},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Total-Count", "42")
+		rw.Header().Set("Content-Range", "items 0-9/42")
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	assertResponseHeader(t, recorder, "Content-Range", "items 0-9/42")
+}
+
+func TestResponseHeaderFromResponseHeader_InvalidConfig(t *testing.T) {
+	testCases := []struct {
+		name    string
+		derived add_missing_headers.DerivedHeader
+	}{
+		{"Missing source", add_missing_headers.DerivedHeader{Template: "{{ .Value }}"}},
+		{"Invalid template", add_missing_headers.DerivedHeader{Source: "X-Total-Count", Template: "{{ .Value"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.ResponseHeaderFromResponseHeader = map[string]add_missing_headers.DerivedHeader{"Content-Range": tc.derived}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+				t.Error("Expected an error from New")
+			}
+		})
+	}
+}
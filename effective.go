@@ -0,0 +1,256 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+// redactedSecret replaces secrets in the effective configuration.
+const redactedSecret = "REDACTED"
+
+// EffectiveConfig returns the configuration the plugin ended up with: header files and
+// presets merged in, header names canonicalized, and values trimmed or truncated as configured.
+// Headers from files are the ones currently in use, reloads included.
+// The returned value is a deep copy, and the disable header secret is redacted.
+func (p *Plugin) EffectiveConfig() Config {
+	effective := copyConfig(p.effectiveConfig)
+	p.currentFileHeaders().resolveEffective(effective)
+	return *effective
+}
+
+// resolveEffectiveConfig records the resolved configuration, once every option has been compiled.
+// Headers built from header files are left out, they are resolved by EffectiveConfig.
+func (p *Plugin) resolveEffectiveConfig(config *Config) {
+	effective := copyConfig(config)
+
+	effective.RequestHeaders = nil
+	effective.ResponseHeaders = nil
+	effective.HostHeaders = nil
+	effective.IdempotencyHeaders = entriesToMap(p.idempotencyHeaders)
+	effective.ConditionalGetHeaders = entriesToMap(p.conditionalGetHeaders)
+	effective.RequestHeadersMulti = entriesToMultiMap(p.requestMultiHeaders)
+	effective.ResponseHeadersMulti = entriesToMultiMap(p.responseMultiHeaders)
+	effective.BypassHeaders = matchersToMap(p.bypassHeaders)
+	effective.RequireHeaders = matchersToMap(p.requireHeaders)
+
+	// Files and presets are merged into the header maps above
+	effective.RequestHeadersFile = ""
+	effective.ResponseHeadersFile = ""
+	effective.Presets = nil
+	effective.TrimValues = false
+
+	// The retryAfterOnGatewayErrors rule follows the configured ones and is reported as configured
+	for i := range effective.StatusRules {
+		r := p.statusRules[i]
+		effective.StatusRules[i].ResponseHeaders = entriesToMap(r.responseHeaders)
+		effective.StatusRules[i].RemoveHeaders = append([]string(nil), r.removeHeaders...)
+	}
+
+	effective.MaxHeaderValueAction = p.maxHeaderValueAction
+	effective.UnsafeValueAction = p.unsafeValueAction
+	effective.BypassMode = p.bypassMode
+	effective.BypassScope = p.bypassScope
+	effective.RequireScheme = p.requireScheme
+	if p.jwtClaim != nil {
+		effective.JWTClaim.Header = p.jwtClaim.header
+	}
+	if effective.DisableHeader.Secret != "" {
+		effective.DisableHeader.Secret = redactedSecret
+	}
+
+	p.effectiveConfig = effective
+}
+
+// resolveEffective fills the request, response and host headers of effective with the file headers.
+func (h *fileHeaders) resolveEffective(effective *Config) {
+	effective.RequestHeaders = entriesToMap(h.requestHeaders)
+	effective.ResponseHeaders = entriesToMap(h.responseHeaders)
+
+	if h.hostHeaders != nil {
+		effective.HostHeaders = make(map[string]map[string]string)
+		for host, entries := range h.hostHeaders.exact {
+			effective.HostHeaders[host] = entriesToMap(entries)
+		}
+		for _, rule := range h.hostHeaders.wildcard {
+			effective.HostHeaders["*"+rule.suffix] = entriesToMap(rule.headers)
+		}
+	}
+}
+
+// entriesToMap converts compiled headers back to a header map.
+func entriesToMap(entries []headerEntry) map[string]string {
+	headers := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		headers[entry.key] = entry.value
+	}
+	return headers
+}
+
+// entriesToMultiMap converts compiled multi-value headers back to a header map.
+func entriesToMultiMap(entries []headerEntry) map[string][]string {
+	headers := make(map[string][]string, len(entries))
+	for _, entry := range entries {
+		headers[entry.key] = append([]string(nil), entry.values...)
+	}
+	return headers
+}
+
+// matchersToMap converts compiled header matchers back to a header map.
+func matchersToMap(matchers []headerMatcher) map[string]string {
+	headers := make(map[string]string, len(matchers))
+	for _, m := range matchers {
+		headers[m.name] = m.value
+	}
+	return headers
+}
+
+// copyConfig returns a deep copy of config.
+func copyConfig(config *Config) *Config {
+	c := *config
+
+	c.RequestHeaders = copyHeaderMap(config.RequestHeaders)
+	c.ResponseHeaders = copyHeaderMap(config.ResponseHeaders)
+	c.BypassHeaders = copyHeaderMap(config.BypassHeaders)
+	c.RequireHeaders = copyHeaderMap(config.RequireHeaders)
+	c.IdempotencyHeaders = copyHeaderMap(config.IdempotencyHeaders)
+	c.ConditionalGetHeaders = copyHeaderMap(config.ConditionalGetHeaders)
+	c.ResponseHeaderDependencies = copyHeaderMap(config.ResponseHeaderDependencies)
+	c.ContextHeaders = copyHeaderMap(config.ContextHeaders)
+	c.SkipIfResponseHeaderPresent = copyHeaderMap(config.SkipIfResponseHeaderPresent)
+	c.RequestHeaderTransforms = copyHeaderMap(config.RequestHeaderTransforms)
+	c.RequestHeadersMulti = copyMultiMap(config.RequestHeadersMulti)
+	c.WeightedHeaders = copyWeightedMap(config.WeightedHeaders)
+	c.ResponseHeadersMulti = copyMultiMap(config.ResponseHeadersMulti)
+
+	c.ExcludeStatuses = append([]int(nil), config.ExcludeStatuses...)
+	c.CIDRLabels = append([]CIDRLabel(nil), config.CIDRLabels...)
+	c.GzipContentTypes = append([]string(nil), config.GzipContentTypes...)
+	c.RequireResponseHeaders = append([]string(nil), config.RequireResponseHeaders...)
+	c.Presets = append([]string(nil), config.Presets...)
+	c.BypassCIDRs = append([]string(nil), config.BypassCIDRs...)
+	c.BypassTimeWindows = append([]string(nil), config.BypassTimeWindows...)
+	c.RemoveResponseHeaderPrefixes = append([]string(nil), config.RemoveResponseHeaderPrefixes...)
+	c.RemoveResponseHeaderPatterns = append([]string(nil), config.RemoveResponseHeaderPatterns...)
+	c.SkipWhenEncoded = append([]string(nil), config.SkipWhenEncoded...)
+	c.ApplyWhen.Methods = append([]string(nil), config.ApplyWhen.Methods...)
+	c.RequestHeaderAllowlist = append([]string(nil), config.RequestHeaderAllowlist...)
+	c.BypassIfMissing = append([]string(nil), config.BypassIfMissing...)
+
+	if config.HostHeaders != nil {
+		c.HostHeaders = make(map[string]map[string]string, len(config.HostHeaders))
+		for host, headers := range config.HostHeaders {
+			c.HostHeaders[host] = copyHeaderMap(headers)
+		}
+	}
+
+	if config.RequestSizeHeaders != nil {
+		c.RequestSizeHeaders = make(map[string]map[string]string, len(config.RequestSizeHeaders))
+		for size, headers := range config.RequestSizeHeaders {
+			c.RequestSizeHeaders[size] = copyHeaderMap(headers)
+		}
+	}
+
+	if config.ResponseHeaderRequestConditions != nil {
+		c.ResponseHeaderRequestConditions = make(map[string]map[string]string, len(config.ResponseHeaderRequestConditions))
+		for key, conditions := range config.ResponseHeaderRequestConditions {
+			c.ResponseHeaderRequestConditions[key] = copyHeaderMap(conditions)
+		}
+	}
+
+	if config.ResponseHeaderFromResponseHeader != nil {
+		c.ResponseHeaderFromResponseHeader = make(map[string]DerivedHeader, len(config.ResponseHeaderFromResponseHeader))
+		for key, d := range config.ResponseHeaderFromResponseHeader {
+			c.ResponseHeaderFromResponseHeader[key] = d
+		}
+	}
+
+	if config.FallbackHeaders != nil {
+		c.FallbackHeaders = make(map[string]FallbackHeader, len(config.FallbackHeaders))
+		for key, f := range config.FallbackHeaders {
+			f.Sources = append([]string(nil), f.Sources...)
+			c.FallbackHeaders[key] = f
+		}
+	}
+
+	if config.ResponseHeaderRewrites != nil {
+		c.ResponseHeaderRewrites = make(map[string]HeaderRewrite, len(config.ResponseHeaderRewrites))
+		for key, rw := range config.ResponseHeaderRewrites {
+			c.ResponseHeaderRewrites[key] = rw
+		}
+	}
+
+	if config.OverrideStatusCode != nil {
+		c.OverrideStatusCode = make(map[string]int, len(config.OverrideStatusCode))
+		for from, to := range config.OverrideStatusCode {
+			c.OverrideStatusCode[from] = to
+		}
+	}
+
+	c.QueryConditions = make([]QueryCondition, len(config.QueryConditions))
+	for i, qc := range config.QueryConditions {
+		qc.RequestHeaders = copyHeaderMap(qc.RequestHeaders)
+		qc.ResponseHeaders = copyHeaderMap(qc.ResponseHeaders)
+		c.QueryConditions[i] = qc
+	}
+
+	c.StatusRules = make([]StatusRule, len(config.StatusRules))
+	for i, r := range config.StatusRules {
+		r.ResponseHeaders = copyHeaderMap(r.ResponseHeaders)
+		r.RemoveHeaders = append([]string(nil), r.RemoveHeaders...)
+		c.StatusRules[i] = r
+	}
+
+	c.HashBuckets.Buckets = make([]HashBucket, len(config.HashBuckets.Buckets))
+	for i, b := range config.HashBuckets.Buckets {
+		b.RequestHeaders = copyHeaderMap(b.RequestHeaders)
+		b.ResponseHeaders = copyHeaderMap(b.ResponseHeaders)
+		c.HashBuckets.Buckets[i] = b
+	}
+
+	return &c
+}
+
+// copyHeaderMap returns a copy of a header map.
+func copyHeaderMap(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	c := make(map[string]string, len(headers))
+	for key, value := range headers {
+		c[key] = value
+	}
+	return c
+}
+
+// copyMultiMap returns a deep copy of a multi-value header map.
+func copyMultiMap(headers map[string][]string) map[string][]string {
+	if headers == nil {
+		return nil
+	}
+	c := make(map[string][]string, len(headers))
+	for key, values := range headers {
+		c[key] = append([]string(nil), values...)
+	}
+	return c
+}
+
+// copyWeightedMap returns a deep copy of a weighted header map.
+func copyWeightedMap(headers map[string][]WeightedValue) map[string][]WeightedValue {
+	if headers == nil {
+		return nil
+	}
+	c := make(map[string][]WeightedValue, len(headers))
+	for key, values := range headers {
+		c[key] = append([]WeightedValue(nil), values...)
+	}
+	return c
+}
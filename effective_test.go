@@ -0,0 +1,77 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestEffectiveConfig(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["x-request"] = "configured"
+	cfg.ResponseHeaders["x-frame-options"] = "SAMEORIGIN"
+	cfg.Presets = []string{"owasp-basic"}
+	cfg.HostHeaders = map[string]map[string]string{
+		"*.example.com": {"x-host": "wildcard"},
+	}
+	cfg.DisableHeader.Name = "X-Disable"
+	cfg.DisableHeader.Secret = "secret"
+
+	handler, err := add_missing_headers.New(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}), cfg, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin := handler.(*add_missing_headers.Plugin)
+
+	effective := plugin.EffectiveConfig()
+	if got := effective.RequestHeaders["X-Request"]; got != "configured" {
+		t.Errorf("Expected canonical request header X-Request, got %q", got)
+	}
+	if got := effective.ResponseHeaders["X-Frame-Options"]; got != "SAMEORIGIN" {
+		t.Errorf("Expected the configured X-Frame-Options to win over the preset, got %q", got)
+	}
+	if got := effective.ResponseHeaders["X-Content-Type-Options"]; got != "nosniff" {
+		t.Errorf("Expected the preset X-Content-Type-Options, got %q", got)
+	}
+	if effective.Presets != nil {
+		t.Errorf("Expected presets to be expanded, got %v", effective.Presets)
+	}
+	if got := effective.HostHeaders["*.example.com"]["X-Host"]; got != "wildcard" {
+		t.Errorf("Expected the wildcard host header, got %q", got)
+	}
+	if effective.DisableHeader.Secret == "secret" {
+		t.Error("Expected the disable header secret to be redacted")
+	}
+
+	// Mutating the copy must not affect the plugin
+	effective.RequestHeaders["X-Request"] = "mutated"
+	effective.ResponseHeaders["X-Frame-Options"] = "mutated"
+	effective.HostHeaders["*.example.com"]["X-Host"] = "mutated"
+
+	if got := plugin.EffectiveConfig().RequestHeaders["X-Request"]; got != "configured" {
+		t.Errorf("Expected a fresh copy, got %q", got)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	handler.ServeHTTP(recorder, req)
+
+	assertResponseHeader(t, recorder, "X-Frame-Options", "SAMEORIGIN")
+}
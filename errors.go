@@ -0,0 +1,30 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import "errors"
+
+// Errors returned by New, wrapped with the option and header they were found in.
+// Use errors.Is to tell them apart.
+var (
+	// ErrInvalidHeaderName reports a header name that isn't a valid HTTP token.
+	ErrInvalidHeaderName = errors.New("invalid header name")
+	// ErrHeaderNameCollision reports distinct names referring to the same canonical header.
+	ErrHeaderNameCollision = errors.New("header name collision")
+	// ErrInvalidRegex reports a regular expression that doesn't compile.
+	ErrInvalidRegex = errors.New("invalid regular expression")
+	// ErrInvalidWeight reports a weight that is not positive or a total that overflows.
+	ErrInvalidWeight = errors.New("invalid weight")
+)
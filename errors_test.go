@@ -0,0 +1,136 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestNew_Errors(t *testing.T) {
+	headerFile := filepath.Join(t.TempDir(), "headers.txt")
+	if err := os.WriteFile(headerFile, []byte("X Bad: value\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		setup    func(cfg *add_missing_headers.Config)
+		expected error
+	}{
+		{"Invalid request header name", func(cfg *add_missing_headers.Config) {
+			cfg.RequestHeaders["X Bad"] = "value"
+		}, add_missing_headers.ErrInvalidHeaderName},
+		{"Empty response header name", func(cfg *add_missing_headers.Config) {
+			cfg.ResponseHeaders[""] = "value"
+		}, add_missing_headers.ErrInvalidHeaderName},
+		{"Invalid multi-value header name", func(cfg *add_missing_headers.Config) {
+			cfg.ResponseHeadersMulti = map[string][]string{"X:Bad": {"value"}}
+		}, add_missing_headers.ErrInvalidHeaderName},
+		{"Invalid bypass header name", func(cfg *add_missing_headers.Config) {
+			cfg.BypassHeaders["X\tBad"] = ""
+		}, add_missing_headers.ErrInvalidHeaderName},
+		{"Invalid header file name", func(cfg *add_missing_headers.Config) {
+			cfg.ResponseHeadersFile = headerFile
+		}, add_missing_headers.ErrInvalidHeaderName},
+		{"Response header collision", func(cfg *add_missing_headers.Config) {
+			cfg.ResponseHeaders["x-test"] = "one"
+			cfg.ResponseHeaders["X-Test"] = "two"
+		}, add_missing_headers.ErrHeaderNameCollision},
+		{"Multi-value header collision", func(cfg *add_missing_headers.Config) {
+			cfg.RequestHeadersMulti = map[string][]string{"x-test": {"one"}, "X-TEST": {"two"}}
+		}, add_missing_headers.ErrHeaderNameCollision},
+		{"Derived header collision", func(cfg *add_missing_headers.Config) {
+			cfg.ResponseHeaderFromResponseHeader = map[string]add_missing_headers.DerivedHeader{
+				"x-test": {Source: "X-Source"},
+				"X-Test": {Source: "X-Source"},
+			}
+		}, add_missing_headers.ErrHeaderNameCollision},
+		{"Invalid bypass regex", func(cfg *add_missing_headers.Config) {
+			cfg.BypassHeaders["X-Client"] = "regex:("
+		}, add_missing_headers.ErrInvalidRegex},
+		{"Hash bucket weights overflow", func(cfg *add_missing_headers.Config) {
+			cfg.HashBuckets = add_missing_headers.HashBuckets{Buckets: []add_missing_headers.HashBucket{
+				{Weight: math.MaxUint32},
+				{Weight: 1},
+			}}
+		}, add_missing_headers.ErrInvalidWeight},
+		{"Weighted header weights overflow", func(cfg *add_missing_headers.Config) {
+			cfg.WeightedHeaders = map[string][]add_missing_headers.WeightedValue{
+				"X-Variant": {{Value: "a", Weight: math.MaxInt}, {Value: "b", Weight: 1}},
+			}
+		}, add_missing_headers.ErrInvalidWeight},
+		{"Invalid router header name", func(cfg *add_missing_headers.Config) {
+			cfg.StampRouterHeader = true
+			cfg.RouterHeaderName = "X Router"
+		}, add_missing_headers.ErrInvalidHeaderName},
+		{"Invalid processed header name", func(cfg *add_missing_headers.Config) {
+			cfg.StampProcessedHeader = true
+			cfg.ProcessedHeaderName = "X Processed"
+		}, add_missing_headers.ErrInvalidHeaderName},
+		{"Invalid original status header name", func(cfg *add_missing_headers.Config) {
+			cfg.OriginalStatusHeader = "X:Status"
+		}, add_missing_headers.ErrInvalidHeaderName},
+		{"Invalid CIDR label header name", func(cfg *add_missing_headers.Config) {
+			cfg.CIDRLabels = []add_missing_headers.CIDRLabel{{CIDR: "10.0.0.0/8", Label: "internal"}}
+			cfg.CIDRLabelHeader = "X Network"
+		}, add_missing_headers.ErrInvalidHeaderName},
+		{"Invalid applyWhen header name", func(cfg *add_missing_headers.Config) {
+			cfg.ApplyWhen.Header = "X Feature"
+		}, add_missing_headers.ErrInvalidHeaderName},
+		{"Invalid jwtClaim header name", func(cfg *add_missing_headers.Config) {
+			cfg.JWTClaim = add_missing_headers.JWTClaim{Claim: "sub", Header: "X Token"}
+		}, add_missing_headers.ErrInvalidHeaderName},
+		{"Invalid required response header name", func(cfg *add_missing_headers.Config) {
+			cfg.RequireResponseHeaders = []string{"X Upstream"}
+		}, add_missing_headers.ErrInvalidHeaderName},
+		{"Invalid status rule removed header name", func(cfg *add_missing_headers.Config) {
+			cfg.StatusRules = []add_missing_headers.StatusRule{{Statuses: "5xx", RemoveHeaders: []string{"X Debug"}}}
+		}, add_missing_headers.ErrInvalidHeaderName},
+		{"Invalid response header dependency", func(cfg *add_missing_headers.Config) {
+			cfg.ResponseHeaderDependencies = map[string]string{"X-Frame-Options": "Content Type"}
+		}, add_missing_headers.ErrInvalidHeaderName},
+		{"Invalid hash bucket header name", func(cfg *add_missing_headers.Config) {
+			cfg.HashBuckets = add_missing_headers.HashBuckets{
+				Header:  "X User",
+				Buckets: []add_missing_headers.HashBucket{{Weight: 1}},
+			}
+		}, add_missing_headers.ErrInvalidHeaderName},
+		{"Invalid rewrite pattern", func(cfg *add_missing_headers.Config) {
+			cfg.ResponseHeaderRewrites = map[string]add_missing_headers.HeaderRewrite{"Location": {Pattern: "[a-"}}
+		}, add_missing_headers.ErrInvalidRegex},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			tc.setup(cfg)
+
+			_, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin")
+			if !errors.Is(err, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// SetTimeNow replaces the clock used by templates and time windows and returns a function restoring it.
+func SetTimeNow(now func() time.Time) func() {
+	previous := timeNow
+	timeNow = now
+	return func() { timeNow = previous }
+}
+
+// SetLogOutput redirects plugin log messages and returns a function restoring the previous output.
+func SetLogOutput(w io.Writer) func() {
+	previous := logger.Writer()
+	logger.SetOutput(w)
+	return func() { logger.SetOutput(previous) }
+}
+
+// ParseHeaderFile exposes parseHeaderFile to the fuzz test.
+var ParseHeaderFile = parseHeaderFile
+
+// SetRandReader replaces the source of request IDs and returns a function restoring it.
+func SetRandReader(r io.Reader) func() {
+	previous := randReader
+	randReader = r
+	return func() { randReader = previous }
+}
+
+// TemplateCacheMisses returns the number of template evaluations the handler's template cache didn't save.
+func TemplateCacheMisses(h http.Handler) int {
+	cache := h.(*Plugin).templateCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.misses
+}
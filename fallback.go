@@ -0,0 +1,107 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"sort"
+)
+
+// FallbackHeader sets a request header from the first of Sources the request carries
+// with a non-empty value, or else from Default when it is not empty.
+type FallbackHeader struct {
+	Sources []string `json:"sources,omitempty" yaml:"sources,omitempty"`
+	Default string   `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+// fallbackHeader is a compiled FallbackHeader.
+type fallbackHeader struct {
+	key     string
+	sources []string
+	// defaults holds the default value, it is empty when none is configured.
+	defaults []string
+}
+
+// compileFallbackHeaders compiles fallback headers, sorted by target header name.
+func compileFallbackHeaders(config map[string]FallbackHeader) ([]fallbackHeader, error) {
+	seen := make(map[string]string, len(config))
+	fallbacks := make([]fallbackHeader, 0, len(config))
+	for key, f := range config {
+		canonicalKey, err := canonicalHeaderName(seen, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(f.Sources) == 0 {
+			return nil, fmt.Errorf("header %q: missing sources", key)
+		}
+		sources := make([]string, 0, len(f.Sources))
+		for _, source := range f.Sources {
+			if !validHeaderName(source) {
+				return nil, fmt.Errorf("header %q: source: %w %q", key, ErrInvalidHeaderName, source)
+			}
+			sources = append(sources, textproto.CanonicalMIMEHeaderKey(source))
+		}
+
+		fallback := fallbackHeader{key: canonicalKey, sources: sources}
+		if f.Default != "" {
+			fallback.defaults = []string{f.Default}
+		}
+		fallbacks = append(fallbacks, fallback)
+	}
+
+	sort.Slice(fallbacks, func(i, j int) bool { return fallbacks[i].key < fallbacks[j].key })
+
+	return fallbacks, nil
+}
+
+// resolve returns the value of the first non-empty source, or else the default.
+func (f fallbackHeader) resolve(header http.Header) (string, bool) {
+	for _, source := range f.sources {
+		if value := header.Get(source); value != "" {
+			return value, true
+		}
+	}
+	if len(f.defaults) == 0 {
+		return "", false
+	}
+	return f.defaults[0], true
+}
+
+// addFallbackHeaders adds missing headers resolved from their fallback chain.
+// Every chain is resolved before any header is set, so fallback headers can't feed each other.
+func (p *Plugin) addFallbackHeaders(header http.Header) {
+	values := make([]string, len(p.fallbackHeaders))
+	for i, f := range p.fallbackHeaders {
+		if !p.shouldAddHeader(header, f.key) {
+			continue
+		}
+		value, ok := f.resolve(header)
+		if !ok {
+			continue
+		}
+		if value, ok = p.checkValue(f.key, value); ok {
+			values[i] = value
+		}
+	}
+
+	for i, f := range p.fallbackHeaders {
+		if values[i] != "" {
+			header.Set(f.key, values[i])
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestFallbackHeaders(t *testing.T) {
+	testCases := []struct {
+		name         string
+		defaultValue string
+		header       http.Header
+		expected     string
+	}{
+		{"First source", "anonymous", http.Header{"X-Auth-User": {"alice"}, "X-Legacy-User": {"bob"}}, "alice"},
+		{"Second source", "anonymous", http.Header{"X-Auth-User": {""}, "X-Legacy-User": {"bob"}}, "bob"},
+		{"Default", "anonymous", http.Header{}, "anonymous"},
+		{"No default", "", http.Header{}, ""},
+		{"Existing header wins", "anonymous", http.Header{"X-User-Id": {"carol"}, "X-Auth-User": {"alice"}}, "carol"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.FallbackHeaders = map[string]add_missing_headers.FallbackHeader{
+				"x-user-id": {Sources: []string{"x-auth-user", "X-Legacy-User"}, Default: tc.defaultValue},
+			}
+
+			var req *http.Request
+			next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				req = r
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			r.Header = tc.header
+
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), r)
+
+			assertHeader(t, req, "X-User-Id", tc.expected)
+		})
+	}
+}
+
+func TestFallbackHeaders_Invalid(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config map[string]add_missing_headers.FallbackHeader
+	}{
+		{"Missing sources", map[string]add_missing_headers.FallbackHeader{"X-User-Id": {Default: "anonymous"}}},
+		{"Invalid source", map[string]add_missing_headers.FallbackHeader{"X-User-Id": {Sources: []string{"X Auth"}}}},
+		{"Invalid header", map[string]add_missing_headers.FallbackHeader{"X User": {Sources: []string{"X-Auth-User"}}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.FallbackHeaders = tc.config
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
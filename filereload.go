@@ -0,0 +1,132 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// fileHeaders holds the headers built from header files, replaced as a whole on reload.
+type fileHeaders struct {
+	requestHeaders  []headerEntry
+	responseHeaders []headerEntry
+	hostHeaders     *hostHeaders
+	// readsHeaders is set when any template, from files or not, calls the "header" function.
+	readsHeaders bool
+}
+
+// compileFileHeaders compiles the request, response and host headers, merging in
+// header files and presets.
+func compileFileHeaders(config *Config) (*fileHeaders, error) {
+	requestHeaderMap, err := mergeHeaderFile(config.RequestHeadersFile, config.RequestHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("requestHeadersFile: %w", err)
+	}
+
+	requestHeaders, err := compileHeaders(requestHeaderMap)
+	if err != nil {
+		return nil, fmt.Errorf("requestHeaders: %w", err)
+	}
+
+	responseHeaderMap, err := mergeHeaderFile(config.ResponseHeadersFile, config.ResponseHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("responseHeadersFile: %w", err)
+	}
+
+	presetHeaders, err := expandPresets(config.Presets)
+	if err != nil {
+		return nil, fmt.Errorf("presets: %w", err)
+	}
+
+	// Presets are defaults for host-specific headers too
+	responseHeaderMap = mergeHeaders(presetHeaders, responseHeaderMap)
+
+	responseHeaders, err := compileHeaders(responseHeaderMap)
+	if err != nil {
+		return nil, fmt.Errorf("responseHeaders: %w", err)
+	}
+
+	hostHeaders, err := compileHostHeaders(config.HostHeaders, responseHeaderMap)
+	if err != nil {
+		return nil, fmt.Errorf("hostHeaders: %w", err)
+	}
+
+	return &fileHeaders{
+		requestHeaders:  requestHeaders,
+		responseHeaders: responseHeaders,
+		hostHeaders:     hostHeaders,
+	}, nil
+}
+
+// sets returns the compiled lists of headers, named after the option configuring them.
+func (h *fileHeaders) sets() []headerSet {
+	sets := []headerSet{
+		{"requestHeaders", h.requestHeaders},
+		{"responseHeaders", h.responseHeaders},
+	}
+
+	if h.hostHeaders != nil {
+		for host, entries := range h.hostHeaders.exact {
+			sets = append(sets, headerSet{fmt.Sprintf("hostHeaders[%s]", host), entries})
+		}
+		for _, rule := range h.hostHeaders.wildcard {
+			sets = append(sets, headerSet{fmt.Sprintf("hostHeaders[*%s]", rule.suffix), rule.headers})
+		}
+	}
+
+	return sets
+}
+
+// currentFileHeaders returns the headers built from the latest successful read of the header files.
+func (p *Plugin) currentFileHeaders() *fileHeaders {
+	return p.files.Load().(*fileHeaders)
+}
+
+// watchHeaderFiles reloads the header files every interval until ctx is done.
+func (p *Plugin) watchHeaderFiles(ctx context.Context, config *Config, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.reloadHeaderFiles(config); err != nil {
+				p.logf("failed to reload header files, keeping the previous headers: %v", err)
+			}
+		}
+	}
+}
+
+// reloadHeaderFiles reads the header files again and swaps in the new headers.
+// Requests being processed keep the headers they started with.
+func (p *Plugin) reloadHeaderFiles(config *Config) error {
+	files, err := compileFileHeaders(config)
+	if err != nil {
+		return err
+	}
+
+	if err := p.limitSets(files.sets()); err != nil {
+		return fmt.Errorf("maxHeaderValueLength: %w", err)
+	}
+	// The initial result covers the other templates, and at worst snapshots headers for nothing
+	files.readsHeaders = p.readsHeaders || setsReadHeaders(files.sets())
+
+	p.files.Store(files)
+	return nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use, for logs written by background reloads.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitForResponseHeader sends requests until the response header has the expected value.
+func waitForResponseHeader(t *testing.T, handler http.Handler, name, expected string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://localhost", nil))
+		got := recorder.Header().Get(name)
+		if got == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s %q, still got %q", name, expected, got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFileReloadInterval(t *testing.T) {
+	var logs syncBuffer
+	defer add_missing_headers.SetLogOutput(&logs)()
+
+	path := filepath.Join(t.TempDir(), "headers.txt")
+	if err := os.WriteFile(path, []byte("X-Api-Key: first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeadersFile = path
+	cfg.ResponseHeaders["X-Inline"] = "inline"
+	cfg.FileReloadInterval = "10ms"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := add_missing_headers.New(ctx, next, cfg, "test-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForResponseHeader(t, handler, "X-Api-Key", "first")
+
+	if err := os.WriteFile(path, []byte("X-Api-Key: second\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitForResponseHeader(t, handler, "X-Api-Key", "second")
+	waitForResponseHeader(t, handler, "X-Inline", "inline")
+
+	// The effective configuration follows the reload
+	effective := handler.(*add_missing_headers.Plugin).EffectiveConfig()
+	if got := effective.ResponseHeaders["X-Api-Key"]; got != "second" {
+		t.Errorf("Expected the reloaded X-Api-Key in the effective config, got %q", got)
+	}
+
+	// An invalid file keeps the previous headers
+	if err := os.WriteFile(path, []byte("not a header\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), "failed to reload header files") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a reload error to be logged, got %q", logs.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	waitForResponseHeader(t, handler, "X-Api-Key", "second")
+}
+
+func TestFileReloadInterval_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "headers.txt")
+	if err := os.WriteFile(path, []byte("X-Api-Key: first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		interval string
+		file     string
+	}{
+		{"Invalid duration", "soon", path},
+		{"Negative duration", "-1s", path},
+		{"No header file", "1s", ""},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.ResponseHeadersFile = tc.file
+			cfg.FileReloadInterval = tc.interval
+
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+				t.Error("Expected an error from New")
+			}
+		})
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/giacomoferretti/add-missing-headers
 
-go 1.19
+go 1.20
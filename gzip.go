@@ -0,0 +1,104 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultGzipContentTypes lists the media types compressed when GzipContentTypes is empty.
+var defaultGzipContentTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"image/svg+xml",
+}
+
+// normalizeContentTypes lowercases media types, falling back to the defaults when empty.
+func normalizeContentTypes(contentTypes []string) []string {
+	if len(contentTypes) == 0 {
+		contentTypes = defaultGzipContentTypes
+	}
+
+	normalized := make([]string, 0, len(contentTypes))
+	for _, contentType := range contentTypes {
+		normalized = append(normalized, strings.ToLower(strings.TrimSpace(contentType)))
+	}
+	return normalized
+}
+
+// isCompressible reports whether the media type of contentType is in the compressible list.
+// Entries ending in "/*" match every subtype.
+func (p *Plugin) isCompressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "" {
+		return false
+	}
+
+	for _, candidate := range p.gzipContentTypes {
+		if strings.HasSuffix(candidate, "/*") {
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(candidate, "*")) {
+				return true
+			}
+			continue
+		}
+		if mediaType == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip reports whether the client accepts a gzip-encoded response.
+func acceptsGzip(req *http.Request) bool {
+	for _, value := range req.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "gzip" && name != "*" {
+				continue
+			}
+
+			// A zero quality value explicitly refuses the coding
+			params = strings.ToLower(strings.TrimSpace(params))
+			if strings.HasPrefix(params, "q=") {
+				if quality, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64); err == nil && quality == 0 {
+					continue
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// forbidsTransform reports whether Cache-Control carries the no-transform directive.
+func forbidsTransform(header http.Header) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-transform") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestGzip(t *testing.T) {
+	const body = "hello, hello, hello, hello, hello"
+
+	testCases := []struct {
+		name                 string
+		acceptEncoding       string
+		contentType          string
+		disableExplicitFlush bool
+		shouldCompress       bool
+	}{
+		{"Compressible type", "gzip, deflate", "text/plain; charset=utf-8", true, true},
+		{"Compressible type with explicit flushing", "gzip", "application/json", false, true},
+		{"Client does not accept gzip", "br", "text/plain", true, false},
+		{"Client refuses gzip", "gzip;q=0", "text/plain", true, false},
+		{"Non-compressible type", "gzip", "image/png", true, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.EnableGzip = true
+			cfg.DisableExplicitFlush = tc.disableExplicitFlush
+			cfg.ResponseHeaders["X-Test"] = "test"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Type", tc.contentType)
+				rw.Header().Set("Content-Length", "33")
+				for _, part := range strings.SplitAfter(body, " ") {
+					_, _ = rw.Write([]byte(part))
+				}
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "X-Test", "test")
+
+			if !tc.shouldCompress {
+				assertResponseHeader(t, recorder, "Content-Encoding", "")
+				assertResponseHeader(t, recorder, "Content-Length", "33")
+				if recorder.Body.String() != body {
+					t.Errorf("Expected body %q, got %q", body, recorder.Body.String())
+				}
+				return
+			}
+
+			assertResponseHeader(t, recorder, "Content-Encoding", "gzip")
+			assertResponseHeader(t, recorder, "Content-Length", "")
+			assertResponseHeader(t, recorder, "Vary", "Accept-Encoding")
+
+			reader, err := gzip.NewReader(recorder.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decoded) != body {
+				t.Errorf("Expected decoded body %q, got %q", body, decoded)
+			}
+		})
+	}
+}
+
+func TestGzip_SkipsEncodedAndBodilessResponses(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.EnableGzip = true
+
+	testCases := []struct {
+		name    string
+		method  string
+		status  int
+		encoded bool
+	}{
+		{"Already encoded", http.MethodGet, http.StatusOK, true},
+		{"No content", http.MethodGet, http.StatusNoContent, false},
+		{"Not modified", http.MethodGet, http.StatusNotModified, false},
+		{"HEAD request", http.MethodHead, http.StatusOK, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Type", "text/plain")
+				if tc.encoded {
+					rw.Header().Set("Content-Encoding", "br")
+				}
+				rw.WriteHeader(tc.status)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, "http://localhost", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			if recorder.Header().Get("Content-Encoding") == "gzip" {
+				t.Error("Expected the response not to be gzipped")
+			}
+			if recorder.Body.Len() != 0 {
+				t.Errorf("Expected an empty body, got %q", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestGzip_SkipsRangesAndNoTransform(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.EnableGzip = true
+
+	testCases := []struct {
+		name   string
+		status int
+		header map[string]string
+	}{
+		{"Partial content", http.StatusPartialContent, map[string]string{"Content-Range": "bytes 0-4/10"}},
+		{"Content-Range", http.StatusOK, map[string]string{"Content-Range": "bytes 0-4/10"}},
+		{"No-transform", http.StatusOK, map[string]string{"Cache-Control": "public, No-Transform"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Type", "text/plain")
+				for name, value := range tc.header {
+					rw.Header().Set(name, value)
+				}
+				rw.WriteHeader(tc.status)
+				_, _ = rw.Write([]byte("hello"))
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			if recorder.Header().Get("Content-Encoding") == "gzip" {
+				t.Error("Expected the response not to be gzipped")
+			}
+			if recorder.Code != tc.status {
+				t.Errorf("Expected status %d, got %d", tc.status, recorder.Code)
+			}
+			if recorder.Body.String() != "hello" {
+				t.Errorf("Expected the identity body, got %q", recorder.Body.String())
+			}
+		})
+	}
+}
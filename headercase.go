@@ -0,0 +1,51 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import "net/http"
+
+// preservesCase reports whether the header must be set with its configured, non-canonical name.
+func (p *Plugin) preservesCase(entry *headerEntry) bool {
+	return p.preserveHeaderCase && entry.name != "" && entry.name != entry.key
+}
+
+// addMissingRawHeader adds a missing header under its configured name, bypassing the
+// canonicalization of http.Header methods. The header counts as present under either name.
+func (p *Plugin) addMissingRawHeader(target http.Header, entry *headerEntry, data *templateData) {
+	existing := append(append([]string(nil), target[entry.key]...), target[entry.name]...)
+	if !p.shouldAddHeader(http.Header{entry.key: existing}, entry.key) {
+		return
+	}
+
+	var values []string
+	if entry.values != nil {
+		for _, value := range entry.values {
+			if value, ok := p.checkValue(entry.key, value); ok {
+				values = append(values, value)
+			}
+		}
+	} else if value, ok := entry.render(data); ok {
+		if value, ok = p.checkValue(entry.key, value); ok {
+			values = []string{value}
+		}
+	}
+	if values == nil {
+		return
+	}
+
+	// Replace empty values found under either name in loose mode
+	delete(target, entry.key)
+	target[entry.name] = values
+}
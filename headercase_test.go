@@ -0,0 +1,137 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestPreserveHeaderCase(t *testing.T) {
+	testCases := []struct {
+		name     string
+		preserve bool
+		upstream map[string]string
+		expected map[string][]string
+	}{
+		{"Canonicalized by default", false, nil, map[string][]string{
+			"X-Lower-Case": {"value"},
+			"X-Multi":      {"a", "b"},
+		}},
+		{"Configured names preserved", true, nil, map[string][]string{
+			"x-lower-case": {"value"},
+			"x-MULTI":      {"a", "b"},
+		}},
+		{"Existing canonical header kept", true, map[string]string{"X-Lower-Case": "upstream"}, map[string][]string{
+			"X-Lower-Case": {"upstream"},
+			"x-MULTI":      {"a", "b"},
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.PreserveHeaderCase = tc.preserve
+			cfg.RequestHeaders["x-lower-case"] = "value"
+			cfg.ResponseHeaders["x-lower-case"] = "value"
+			cfg.ResponseHeadersMulti = map[string][]string{"x-MULTI": {"a", "b"}}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tc.preserve {
+					if got := req.Header["x-lower-case"]; !reflect.DeepEqual(got, []string{"value"}) {
+						t.Errorf("Expected raw request header x-lower-case, got %q", got)
+					}
+				} else {
+					assertHeader(t, req, "X-Lower-Case", "value")
+				}
+				for key, value := range tc.upstream {
+					rw.Header().Set(key, value)
+				}
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			header := recorder.Header()
+			delete(header, "Content-Type")
+			if !reflect.DeepEqual(map[string][]string(header), tc.expected) {
+				t.Errorf("Expected headers %v, got %v", tc.expected, header)
+			}
+		})
+	}
+}
+
+func TestPreserveHeaderCase_HTTP1(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.PreserveHeaderCase = true
+	cfg.ResponseHeaders["x-lower-case"] = "value"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	server := httptest.NewServer(newTestHandler(t, cfg, next))
+	defer server.Close()
+
+	// Go's client canonicalizes response headers, read the raw response instead
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(raw), "\r\nx-lower-case: value\r\n") {
+		t.Errorf("Expected the raw header name on the wire, got %q", raw)
+	}
+}
+
+func TestPreserveHeaderCase_HostHeaders(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.PreserveHeaderCase = true
+	cfg.ResponseHeaders["x-default"] = "default"
+	cfg.HostHeaders = map[string]map[string]string{
+		"example.com": {"x-HOST": "host"},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	expected := http.Header{
+		"x-default": {"default"},
+		"x-HOST":    {"host"},
+	}
+	if got := recorder.Header(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected headers %v, got %v", expected, got)
+	}
+}
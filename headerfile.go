@@ -0,0 +1,130 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+// byteOrderMark is the UTF-8 byte order mark some editors put at the start of files.
+const byteOrderMark = "\ufeff"
+
+// loadHeaderFile reads header definitions from a file, see parseHeaderFile.
+func loadHeaderFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open header file: %w", err)
+	}
+	defer f.Close()
+
+	headers, err := parseHeaderFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return headers, nil
+}
+
+// parseHeaderFile parses header definitions using one "Key: Value" pair per line.
+// Blank lines and lines starting with '#' are ignored, and surrounding whitespace is trimmed.
+// A header defined twice, whatever the casing, keeps its last value and a warning is logged.
+func parseHeaderFile(r io.Reader) (map[string]string, error) {
+	headers := make(map[string]string)
+	names := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if lineNumber == 1 {
+			line = strings.TrimPrefix(line, byteOrderMark)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if !found || key == "" {
+			return nil, fmt.Errorf("line %d: expected \"Key: Value\"", lineNumber)
+		}
+		if !validHeaderName(key) {
+			return nil, fmt.Errorf("line %d: %w %q", lineNumber, ErrInvalidHeaderName, key)
+		}
+		if !validHeaderValue(value) {
+			return nil, fmt.Errorf("line %d: header %q: invalid characters in value", lineNumber, key)
+		}
+
+		canonicalKey := textproto.CanonicalMIMEHeaderKey(key)
+		if previous, ok := names[canonicalKey]; ok {
+			logger.Printf("header file line %d: header %q redefined, the last value wins", lineNumber, canonicalKey)
+			delete(headers, previous)
+		}
+		names[canonicalKey] = key
+		headers[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read header file: %w", err)
+	}
+
+	return headers, nil
+}
+
+// validHeaderName reports whether name is a valid header field name, an RFC 7230 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValue reports whether value has no control characters other than tabs.
+func validHeaderValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeHeaderFile merges headers loaded from a file with inline headers.
+// Inline headers take precedence over file headers with the same canonical name.
+func mergeHeaderFile(path string, inline map[string]string) (map[string]string, error) {
+	if path == "" {
+		return inline, nil
+	}
+
+	fileHeaders, err := loadHeaderFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeHeaders(fileHeaders, inline), nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestHeaderFiles(t *testing.T) {
+	dir := t.TempDir()
+	requestFile := writeFile(t, dir, "request.headers", `# Shared request headers
+X-Request-From-File: file-value
+
+x-overridden: from-file
+`)
+	responseFile := writeFile(t, dir, "response.headers", `X-Frame-Options: DENY
+Content-Security-Policy: default-src 'self'; img-src https://example.com
+`)
+
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeadersFile = requestFile
+	cfg.ResponseHeadersFile = responseFile
+	cfg.RequestHeaders["X-Overridden"] = "inline"
+	cfg.ResponseHeaders["X-Frame-Options"] = "SAMEORIGIN"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assertHeader(t, req, "X-Request-From-File", "file-value")
+		assertHeader(t, req, "X-Overridden", "inline") // Inline takes precedence
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	assertResponseHeader(t, recorder, "X-Frame-Options", "SAMEORIGIN")
+	assertResponseHeader(t, recorder, "Content-Security-Policy", "default-src 'self'; img-src https://example.com")
+}
+
+func TestHeaderFiles_Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	testCases := []struct {
+		name string
+		path string
+	}{
+		{"Missing file", filepath.Join(dir, "missing.headers")},
+		{"Line without colon", writeFile(t, dir, "invalid.headers", "X-Valid: value\nnot a header\n")},
+		{"Empty key", writeFile(t, dir, "empty-key.headers", ": value\n")},
+		{"Invalid name", writeFile(t, dir, "invalid-name.headers", "X Spaced: value\n")},
+		{"Control character in value", writeFile(t, dir, "control.headers", "X-Valid: a\x00b\n")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.ResponseHeadersFile = tc.path
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+				t.Error("Expected an error from New")
+			}
+		})
+	}
+}
+
+func TestParseHeaderFile(t *testing.T) {
+	var logs bytes.Buffer
+	defer add_missing_headers.SetLogOutput(&logs)()
+
+	input := "\ufeffX-First: one  \r\n" +
+		"x-duplicate: first\n" +
+		"\tX-Spaced :  value with  spaces \t\n" +
+		"X-Duplicate: last\n" +
+		"X-Empty:\n" +
+		"X-Colons: a:b:c\n"
+
+	headers, err := add_missing_headers.ParseHeaderFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"X-First":     "one",
+		"X-Spaced":    "value with  spaces",
+		"X-Duplicate": "last",
+		"X-Empty":     "",
+		"X-Colons":    "a:b:c",
+	}
+	if !reflect.DeepEqual(headers, expected) {
+		t.Errorf("Expected %v, got %v", expected, headers)
+	}
+	if !strings.Contains(logs.String(), `"X-Duplicate" redefined`) {
+		t.Errorf("Expected a warning for the duplicate header, got logs %q", logs.String())
+	}
+}
+
+func FuzzParseHeaderFile(f *testing.F) {
+	f.Add("X-Key: value\n# comment\n\nOther: a:b\n")
+	f.Add("\ufeffX-Key: value\r\nx-key: again\n")
+	f.Add("no colon\n")
+	f.Add(": empty key\n")
+	f.Add("X-Key: \x00\x7f\n")
+
+	defer add_missing_headers.SetLogOutput(io.Discard)()
+
+	f.Fuzz(func(t *testing.T, input string) {
+		headers, err := add_missing_headers.ParseHeaderFile(strings.NewReader(input))
+		if err != nil {
+			if headers != nil {
+				t.Errorf("Expected no headers with an error, got %v", headers)
+			}
+			return
+		}
+
+		seen := make(map[string]bool)
+		for key, value := range headers {
+			canonicalKey := http.CanonicalHeaderKey(key)
+			if seen[canonicalKey] {
+				t.Errorf("Duplicate header %q", canonicalKey)
+			}
+			seen[canonicalKey] = true
+
+			if key == "" || strings.ContainsAny(key, " \t\r\n:") {
+				t.Errorf("Invalid header name %q", key)
+			}
+			if strings.ContainsAny(value, "\r\n\x00") || strings.TrimSpace(value) != value {
+				t.Errorf("Invalid value %q for header %q", value, key)
+			}
+			if !utf8.ValidString(key) {
+				t.Errorf("Header name %q is not valid UTF-8", key)
+			}
+		}
+	})
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,49 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"math/rand"
+	"net/http"
+)
+
+// Option customizes a Plugin created with NewWithOptions.
+// Options carry Go values such as functions, so they are only available
+// when embedding this package, not through Traefik configuration.
+type Option func(p *Plugin)
+
+// WithRequestHook registers a function called for every processed request,
+// after the configured request headers have been added.
+func WithRequestHook(hook func(req *http.Request)) Option {
+	return func(p *Plugin) {
+		p.requestHook = hook
+	}
+}
+
+// WithResponseHook registers a function called for every processed response with its
+// status code and header map, after the configured response headers have been added.
+func WithResponseHook(hook func(code int, header http.Header)) Option {
+	return func(p *Plugin) {
+		p.responseHook = hook
+	}
+}
+
+// WithRandSource sets the source of randomness used to pick weighted header values,
+// for example a fixed seed with rand.NewSource for reproducible tests.
+func WithRandSource(src rand.Source) Option {
+	return func(p *Plugin) {
+		p.rand = newLockedRand(src)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestHooks(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Request"] = "configured"
+	cfg.ResponseHeaders["X-Response"] = "configured"
+
+	var hookCode int
+	handler, err := add_missing_headers.NewWithOptions(context.Background(), http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assertHeader(t, req, "X-Request", "configured")
+		assertHeader(t, req, "X-Hooked", "configured")
+		rw.WriteHeader(http.StatusAccepted)
+	}), cfg, "test",
+		add_missing_headers.WithRequestHook(func(req *http.Request) {
+			// Runs after the configured headers were added
+			req.Header.Set("X-Hooked", req.Header.Get("X-Request"))
+		}),
+		add_missing_headers.WithResponseHook(func(code int, header http.Header) {
+			hookCode = code
+			header.Set("X-Hooked", header.Get("X-Response"))
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+	handler.ServeHTTP(recorder, req)
+
+	if hookCode != http.StatusAccepted {
+		t.Errorf("Expected the response hook to get status %d, got %d", http.StatusAccepted, hookCode)
+	}
+	assertResponseHeader(t, recorder, "X-Hooked", "configured")
+}
@@ -0,0 +1,51 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// stripHopByHop removes the headers listed in the Connection header, then the standard
+// hop-by-hop headers, as a proxy does before forwarding a request (RFC 7230 section 6.1).
+// Like Go's reverse proxy, "Te: trailers" is kept, and so is the protocol upgrade of upgrade requests.
+func stripHopByHop(header http.Header) {
+	upgrade := ""
+	if headerHasToken(header, "Connection", "upgrade") {
+		upgrade = header.Get("Upgrade")
+	}
+	trailers := headerHasToken(header, "Te", "trailers")
+
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(textproto.CanonicalMIMEHeaderKey(name))
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+
+	if trailers {
+		header.Set("Te", "trailers")
+	}
+	if upgrade != "" {
+		header.Set("Connection", "Upgrade")
+		header.Set("Upgrade", upgrade)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestStripHopByHop(t *testing.T) {
+	testCases := []struct {
+		name     string
+		header   http.Header
+		expected http.Header
+	}{
+		{
+			name: "Listed in Connection",
+			header: http.Header{
+				"Connection": {"keep-alive, X-Custom"},
+				"Keep-Alive": {"timeout=5"},
+				"X-Custom":   {"hop"},
+				"X-Other":    {"end-to-end"},
+			},
+			expected: http.Header{
+				"X-Other": {"end-to-end"},
+			},
+		},
+		{
+			name: "Standard headers",
+			header: http.Header{
+				"Proxy-Authorization": {"Basic Zm9vOmJhcg=="},
+				"Proxy-Connection":    {"keep-alive"},
+				"Te":                  {"gzip"},
+				"Trailer":             {"X-Checksum"},
+				"X-Other":             {"end-to-end"},
+			},
+			expected: http.Header{
+				"X-Other": {"end-to-end"},
+			},
+		},
+		{
+			name: "Trailers kept",
+			header: http.Header{
+				"Te": {"gzip, trailers"},
+			},
+			expected: http.Header{
+				"Te": {"trailers"},
+			},
+		},
+		{
+			name: "Upgrade kept",
+			header: http.Header{
+				"Connection": {"Upgrade, X-Custom"},
+				"Upgrade":    {"websocket"},
+				"X-Custom":   {"hop"},
+			},
+			expected: http.Header{
+				"Connection": {"Upgrade"},
+				"Upgrade":    {"websocket"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.StripHopByHop = true
+
+			var header http.Header
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				header = req.Header.Clone()
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.Header = tc.header
+
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+
+			if !reflect.DeepEqual(header, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, header)
+			}
+		})
+	}
+}
+
+func TestStripHopByHop_AddedHeadersKept(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.StripHopByHop = true
+	cfg.RequestHeaders["X-Custom"] = "added"
+
+	var req *http.Request
+	next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		req = r
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	r.Header.Set("Connection", "X-Custom")
+	r.Header.Set("X-Custom", "hop")
+
+	newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), r)
+
+	assertHeader(t, req, "X-Custom", "added")
+	assertHeader(t, req, "Connection", "")
+}
@@ -0,0 +1,114 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// hostRule holds the response headers selected for a host pattern.
+type hostRule struct {
+	// suffix is set for wildcard patterns, e.g. ".example.com" for "*.example.com"
+	suffix  string
+	headers []headerEntry
+}
+
+// hostHeaders selects response headers by request host.
+type hostHeaders struct {
+	exact    map[string][]headerEntry
+	wildcard []hostRule
+}
+
+// compileHostHeaders compiles host-specific headers, each merged over the default headers.
+func compileHostHeaders(config map[string]map[string]string, defaults map[string]string) (*hostHeaders, error) {
+	if len(config) == 0 {
+		return nil, nil
+	}
+
+	hosts := &hostHeaders{exact: make(map[string][]headerEntry)}
+	for pattern, headers := range config {
+		host := normalizeHost(pattern)
+		if host == "" {
+			return nil, fmt.Errorf("empty host pattern %q", pattern)
+		}
+
+		merged, err := mergeOverDefaults(defaults, headers)
+		if err != nil {
+			return nil, fmt.Errorf("host %q: %w", pattern, err)
+		}
+
+		if strings.HasPrefix(host, "*.") {
+			suffix := host[1:]
+			if strings.Contains(suffix, "*") {
+				return nil, fmt.Errorf("invalid host pattern %q: only a leading wildcard is supported", pattern)
+			}
+			hosts.wildcard = append(hosts.wildcard, hostRule{suffix: suffix, headers: merged})
+			continue
+		}
+
+		if strings.Contains(host, "*") {
+			return nil, fmt.Errorf("invalid host pattern %q: only a leading wildcard is supported", pattern)
+		}
+		if _, ok := hosts.exact[host]; ok {
+			return nil, fmt.Errorf("duplicate host pattern %q", pattern)
+		}
+		hosts.exact[host] = merged
+	}
+
+	// Prefer the most specific wildcard
+	sort.Slice(hosts.wildcard, func(i, j int) bool {
+		return len(hosts.wildcard[i].suffix) > len(hosts.wildcard[j].suffix)
+	})
+
+	return hosts, nil
+}
+
+// lookup returns the headers for a request host, if any pattern matches.
+func (h *hostHeaders) lookup(requestHost string) ([]headerEntry, bool) {
+	host := normalizeHost(requestHost)
+
+	if headers, ok := h.exact[host]; ok {
+		return headers, true
+	}
+
+	for _, rule := range h.wildcard {
+		if strings.HasSuffix(host, rule.suffix) && len(host) > len(rule.suffix) {
+			return rule.headers, true
+		}
+	}
+
+	return nil, false
+}
+
+// mergeOverDefaults merges headers over defaults, the former taking precedence.
+// Entries keep the configured names, for preserveHeaderCase.
+func mergeOverDefaults(defaults, headers map[string]string) ([]headerEntry, error) {
+	// Collisions are reported within headers, before the defaults hide them
+	if _, err := canonicalizeHeaders(headers); err != nil {
+		return nil, err
+	}
+	return compileHeaders(mergeHeaders(defaults, headers))
+}
+
+// normalizeHost lowercases a host and strips its port and trailing dot.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
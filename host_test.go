@@ -0,0 +1,84 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestHostHeaders(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["Content-Security-Policy"] = "default-src 'self'"
+	cfg.ResponseHeaders["X-Frame-Options"] = "DENY"
+	cfg.HostHeaders = map[string]map[string]string{
+		"shop.example.com": {"Content-Security-Policy": "default-src 'self' https://pay.example.com"},
+		"*.example.com":    {"content-security-policy": "default-src 'self' https://cdn.example.com"},
+		"*.api.example.com": {
+			"Content-Security-Policy": "default-src 'none'",
+			"X-Api":                   "1",
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		host        string
+		expectedCSP string
+		expectedAPI string
+	}{
+		{"Exact host", "shop.example.com", "default-src 'self' https://pay.example.com", ""},
+		{"Exact host with port and case", "Shop.Example.com:8443", "default-src 'self' https://pay.example.com", ""},
+		{"Wildcard host", "blog.example.com", "default-src 'self' https://cdn.example.com", ""},
+		{"Most specific wildcard", "v1.api.example.com", "default-src 'none'", "1"},
+		{"Wildcard does not match apex", "example.com", "default-src 'self'", ""},
+		{"Unknown host falls back to defaults", "other.org", "default-src 'self'", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.Host = tc.host
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "Content-Security-Policy", tc.expectedCSP)
+			assertResponseHeader(t, recorder, "X-Api", tc.expectedAPI)
+			// Defaults are merged under host-specific headers
+			assertResponseHeader(t, recorder, "X-Frame-Options", "DENY")
+		})
+	}
+}
+
+func TestHostHeaders_InvalidPattern(t *testing.T) {
+	for _, pattern := range []string{"", "shop.*.example.com", "*.*.example.com"} {
+		cfg := add_missing_headers.CreateConfig()
+		cfg.HostHeaders = map[string]map[string]string{pattern: {"X-Test": "1"}}
+
+		next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+		if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+			t.Errorf("Expected an error for host pattern %q", pattern)
+		}
+	}
+}
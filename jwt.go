@@ -0,0 +1,154 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// defaultJWTHeader is the header holding the token when JWTClaim.Header is empty.
+const defaultJWTHeader = "Authorization"
+
+// JWTClaim restricts the middleware to requests carrying a JWT with a given claim.
+// The token signature is not verified, it must be validated before reaching the middleware.
+// Claim is a dot-separated path into the token payload. When Value is empty the claim
+// only needs to be present and not false or null, otherwise it must equal Value, or
+// contain it when the claim is an array.
+type JWTClaim struct {
+	Header string `json:"header,omitempty" yaml:"header,omitempty"`
+	Claim  string `json:"claim,omitempty" yaml:"claim,omitempty"`
+	Value  string `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// jwtCondition is a compiled JWTClaim.
+type jwtCondition struct {
+	header string
+	path   []string
+	value  string
+}
+
+// compileJWTClaim compiles the claim condition, returning nil when none is configured.
+func compileJWTClaim(claim JWTClaim) (*jwtCondition, error) {
+	if claim.Claim == "" {
+		if claim.Header != "" || claim.Value != "" {
+			return nil, fmt.Errorf("missing claim")
+		}
+		return nil, nil
+	}
+
+	path := strings.Split(claim.Claim, ".")
+	for _, segment := range path {
+		if segment == "" {
+			return nil, fmt.Errorf("invalid claim path %q", claim.Claim)
+		}
+	}
+
+	header := defaultJWTHeader
+	if claim.Header != "" {
+		if !validHeaderName(claim.Header) {
+			return nil, fmt.Errorf("header: %w %q", ErrInvalidHeaderName, claim.Header)
+		}
+		header = http.CanonicalHeaderKey(claim.Header)
+	}
+
+	return &jwtCondition{header: header, path: path, value: claim.Value}, nil
+}
+
+// matches reports whether the request carries a token with the claim.
+// Missing and malformed tokens never match.
+func (c *jwtCondition) matches(req *http.Request) bool {
+	payload, ok := jwtPayload(req.Header.Get(c.header))
+	if !ok {
+		return false
+	}
+
+	var claim interface{} = payload
+	for _, segment := range c.path {
+		object, ok := claim.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if claim, ok = object[segment]; !ok {
+			return false
+		}
+	}
+
+	if c.value == "" {
+		return claim != nil && claim != false
+	}
+
+	if values, ok := claim.([]interface{}); ok {
+		for _, v := range values {
+			if claimString(v) == c.value {
+				return true
+			}
+		}
+		return false
+	}
+	return claimString(claim) == c.value
+}
+
+// jwtPayload decodes the payload of a compact JWT, with or without a "Bearer" prefix.
+func jwtPayload(token string) (map[string]interface{}, bool) {
+	if scheme, rest, found := strings.Cut(token, " "); found && strings.EqualFold(scheme, "Bearer") {
+		token = rest
+	}
+
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var payload map[string]interface{}
+	if err := decoder.Decode(&payload); err != nil || payload == nil {
+		return nil, false
+	}
+	return payload, true
+}
+
+// claimString formats a scalar claim for comparison, objects and arrays never compare equal.
+func claimString(claim interface{}) string {
+	switch v := claim.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	default:
+		return ""
+	}
+}
+
+// hasJWTClaim reports whether the request matches the jwtClaim condition.
+func (p *Plugin) hasJWTClaim(req *http.Request) bool {
+	return p.jwtClaim == nil || p.jwtClaim.matches(req)
+}
@@ -0,0 +1,112 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+// testJWT builds a compact JWT with a dummy header and signature around a raw JSON payload.
+func testJWT(payload string) string {
+	return "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2lnbmF0dXJl"
+}
+
+func TestJWTClaim(t *testing.T) {
+	testCases := []struct {
+		name     string
+		claim    add_missing_headers.JWTClaim
+		token    string
+		expected string
+	}{
+		{"Claim present", add_missing_headers.JWTClaim{Claim: "admin"}, "Bearer " + testJWT(`{"admin":true}`), "admin"},
+		{"Claim false", add_missing_headers.JWTClaim{Claim: "admin"}, "Bearer " + testJWT(`{"admin":false}`), ""},
+		{"Claim missing", add_missing_headers.JWTClaim{Claim: "admin"}, "Bearer " + testJWT(`{"sub":"alice"}`), ""},
+		{"Without Bearer prefix", add_missing_headers.JWTClaim{Claim: "admin"}, testJWT(`{"admin":true}`), "admin"},
+		{"Value match", add_missing_headers.JWTClaim{Claim: "role", Value: "admin"}, "Bearer " + testJWT(`{"role":"admin"}`), "admin"},
+		{"Value mismatch", add_missing_headers.JWTClaim{Claim: "role", Value: "admin"}, "Bearer " + testJWT(`{"role":"user"}`), ""},
+		{"Array contains value", add_missing_headers.JWTClaim{Claim: "roles", Value: "admin"}, "Bearer " + testJWT(`{"roles":["user","admin"]}`), "admin"},
+		{"Nested claim", add_missing_headers.JWTClaim{Claim: "realm.level", Value: "3"}, "Bearer " + testJWT(`{"realm":{"level":3}}`), "admin"},
+		{"Nested claim in scalar", add_missing_headers.JWTClaim{Claim: "realm.level"}, "Bearer " + testJWT(`{"realm":"x"}`), ""},
+		{"Custom header", add_missing_headers.JWTClaim{Header: "x-token", Claim: "admin"}, testJWT(`{"admin":true}`), "admin"},
+		{"Missing token", add_missing_headers.JWTClaim{Claim: "admin"}, "", ""},
+		{"Not a JWT", add_missing_headers.JWTClaim{Claim: "admin"}, "Bearer opaque-token", ""},
+		{"Invalid base64", add_missing_headers.JWTClaim{Claim: "admin"}, "Bearer a.!!!.c", ""},
+		{"Invalid JSON", add_missing_headers.JWTClaim{Claim: "admin"}, "Bearer " + testJWT(`{"admin":`), ""},
+		{"Non-object payload", add_missing_headers.JWTClaim{Claim: "admin"}, "Bearer " + testJWT(`[true]`), ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeaders["X-User-Role"] = "admin"
+			cfg.JWTClaim = tc.claim
+
+			var got string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				got = req.Header.Get("X-User-Role")
+			})
+
+			handler, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			header := "Authorization"
+			if tc.claim.Header != "" {
+				header = tc.claim.Header
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			if tc.token != "" {
+				req.Header.Set(header, tc.token)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tc.expected {
+				t.Errorf("Expected X-User-Role %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestJWTClaim_Invalid(t *testing.T) {
+	testCases := []struct {
+		name  string
+		claim add_missing_headers.JWTClaim
+	}{
+		{"Missing claim", add_missing_headers.JWTClaim{Value: "admin"}},
+		{"Empty path segment", add_missing_headers.JWTClaim{Claim: "realm..roles"}},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.JWTClaim = tc.claim
+
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+				t.Error("Expected an error from New")
+			}
+		})
+	}
+}
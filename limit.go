@@ -0,0 +1,146 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	// lengthActionReject rejects configured values over the limit and skips rendered ones.
+	lengthActionReject = "reject"
+	// lengthActionTruncate shortens values over the limit.
+	lengthActionTruncate = "truncate"
+)
+
+// headerSet is a named list of compiled headers.
+type headerSet struct {
+	name    string
+	entries []headerEntry
+}
+
+// headerSets returns every compiled list of headers, named after the option configuring it.
+func (p *Plugin) headerSets() []headerSet {
+	sets := append(p.currentFileHeaders().sets(),
+		headerSet{"requestHeadersMulti", p.requestMultiHeaders},
+		headerSet{"responseHeadersMulti", p.responseMultiHeaders},
+		headerSet{"idempotencyHeaders", p.idempotencyHeaders},
+		headerSet{"conditionalGetHeaders", p.conditionalGetHeaders},
+	)
+
+	for i, c := range p.queryConditions {
+		sets = append(sets,
+			headerSet{fmt.Sprintf("queryConditions[%d].requestHeaders", i), c.requestHeaders},
+			headerSet{fmt.Sprintf("queryConditions[%d].responseHeaders", i), c.responseHeaders},
+		)
+	}
+
+	// Weighted and fallback values are shared with the entries, so limits apply to the values added
+	weighted := make([]headerEntry, len(p.weightedHeaders))
+	for i, h := range p.weightedHeaders {
+		weighted[i] = headerEntry{key: h.key, name: h.key, values: h.values}
+	}
+	sets = append(sets, headerSet{"weightedHeaders", weighted})
+
+	fallbacks := make([]headerEntry, len(p.fallbackHeaders))
+	for i, f := range p.fallbackHeaders {
+		fallbacks[i] = headerEntry{key: f.key, name: f.key, values: f.defaults}
+	}
+	sets = append(sets, headerSet{"fallbackHeaders", fallbacks})
+
+	for _, r := range p.sizeRules {
+		sets = append(sets, headerSet{fmt.Sprintf("requestSizeHeaders[%d]", r.minBytes), r.requestHeaders})
+	}
+
+	for i, r := range p.statusRules {
+		sets = append(sets, headerSet{fmt.Sprintf("statusRules[%d].responseHeaders", i), r.responseHeaders})
+	}
+
+	if p.hashBuckets != nil {
+		for i, b := range p.hashBuckets.buckets {
+			sets = append(sets,
+				headerSet{fmt.Sprintf("hashBuckets[%d].requestHeaders", i), b.requestHeaders},
+				headerSet{fmt.Sprintf("hashBuckets[%d].responseHeaders", i), b.responseHeaders},
+			)
+		}
+	}
+
+	return sets
+}
+
+// limitConfiguredValues applies the value length limit to every static header value.
+// Template values are only known per request and are checked by limitValue.
+func (p *Plugin) limitConfiguredValues() error {
+	return p.limitSets(p.headerSets())
+}
+
+// limitSets applies the value length limit to the static values of sets.
+func (p *Plugin) limitSets(sets []headerSet) error {
+	if p.maxHeaderValueLength == 0 {
+		return nil
+	}
+
+	for _, set := range sets {
+		for i := range set.entries {
+			entry := &set.entries[i]
+			if entry.tmpl != nil {
+				continue
+			}
+			if err := p.limitConfiguredValue(&entry.value); err != nil {
+				return fmt.Errorf("%s: header %q: %w", set.name, entry.key, err)
+			}
+			for j := range entry.values {
+				if err := p.limitConfiguredValue(&entry.values[j]); err != nil {
+					return fmt.Errorf("%s: header %q: %w", set.name, entry.key, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// limitConfiguredValue rejects or truncates a configured value over the limit.
+func (p *Plugin) limitConfiguredValue(value *string) error {
+	if len(*value) <= p.maxHeaderValueLength {
+		return nil
+	}
+	if p.maxHeaderValueAction == lengthActionReject {
+		return fmt.Errorf("value is %d bytes long, the maximum is %d", len(*value), p.maxHeaderValueLength)
+	}
+	*value = truncateValue(*value, p.maxHeaderValueLength)
+	return nil
+}
+
+// limitValue applies the value length limit to a value computed for a request.
+// The boolean is false when the header should be skipped.
+func (p *Plugin) limitValue(value string) (string, bool) {
+	if p.maxHeaderValueLength == 0 || len(value) <= p.maxHeaderValueLength {
+		return value, true
+	}
+	if p.maxHeaderValueAction == lengthActionReject {
+		return "", false
+	}
+	return truncateValue(value, p.maxHeaderValueLength), true
+}
+
+// truncateValue shortens value to at most limit bytes without splitting a UTF-8 sequence.
+func truncateValue(value string, limit int) string {
+	for limit > 0 && !utf8.RuneStart(value[limit]) {
+		limit--
+	}
+	return value[:limit]
+}
@@ -0,0 +1,109 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestMaxHeaderValueLength_Configured(t *testing.T) {
+	testCases := []struct {
+		name        string
+		action      string
+		configure   func(cfg *add_missing_headers.Config)
+		expectError bool
+	}{
+		{"Rejected by default", "", func(cfg *add_missing_headers.Config) {
+			cfg.ResponseHeaders["X-Long"] = strings.Repeat("a", 17)
+		}, true},
+		{"Rejected in host headers", "reject", func(cfg *add_missing_headers.Config) {
+			cfg.HostHeaders = map[string]map[string]string{"example.com": {"X-Long": strings.Repeat("a", 17)}}
+		}, true},
+		{"Rejected in weighted headers", "reject", func(cfg *add_missing_headers.Config) {
+			cfg.WeightedHeaders = map[string][]add_missing_headers.WeightedValue{"X-Long": {{Value: strings.Repeat("a", 17), Weight: 1}}}
+		}, true},
+		{"Rejected in fallback defaults", "reject", func(cfg *add_missing_headers.Config) {
+			cfg.FallbackHeaders = map[string]add_missing_headers.FallbackHeader{"X-Long": {Sources: []string{"X-Source"}, Default: strings.Repeat("a", 17)}}
+		}, true},
+		{"At the limit", "reject", func(cfg *add_missing_headers.Config) {
+			cfg.ResponseHeaders["X-Long"] = strings.Repeat("a", 16)
+		}, false},
+		{"Truncated", "truncate", func(cfg *add_missing_headers.Config) {
+			cfg.ResponseHeaders["X-Long"] = strings.Repeat("a", 17)
+		}, false},
+		{"Unknown action", "shorten", func(cfg *add_missing_headers.Config) {}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.MaxHeaderValueLength = 16
+			cfg.MaxHeaderValueAction = tc.action
+			tc.configure(cfg)
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			_, err := add_missing_headers.New(context.Background(), next, cfg, "test")
+			if tc.expectError && err == nil {
+				t.Error("expected an error")
+			}
+			if !tc.expectError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestMaxHeaderValueLength_Applied(t *testing.T) {
+	testCases := []struct {
+		name             string
+		action           string
+		expectedStatic   string
+		expectedTemplate string
+	}{
+		{"Truncate", "truncate", "0123456789", "gold-gold-"},
+		{"Reject", "reject", "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.MaxHeaderValueLength = 10
+			cfg.MaxHeaderValueAction = tc.action
+			cfg.ResponseHeaders["X-Template"] = `{{ cookie "tier" }}-{{ cookie "tier" }}-{{ cookie "tier" }}`
+			if tc.action == "truncate" {
+				cfg.ResponseHeaders["X-Static"] = "0123456789abcdef"
+			}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.AddCookie(&http.Cookie{Name: "tier", Value: "gold"})
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "X-Static", tc.expectedStatic)
+			assertResponseHeader(t, recorder, "X-Template", tc.expectedTemplate)
+		})
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"log"
+	"os"
+)
+
+// logger writes plugin messages to stderr, where Traefik collects plugin output.
+var logger = log.New(os.Stderr, "add-missing-headers: ", log.LstdFlags)
+
+// logf logs a message prefixed with the middleware name.
+func (p *Plugin) logf(format string, args ...interface{}) {
+	logger.Printf("["+p.name+"] "+format, args...)
+}
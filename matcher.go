@@ -0,0 +1,347 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// globPrefix marks a header value as a glob pattern.
+	globPrefix = "glob:"
+	// filePrefix marks a header value as a file listing accepted values.
+	filePrefix = "@file:"
+	// regexPrefix marks a header value as a regular expression.
+	regexPrefix = "regex:"
+)
+
+// headerMatcher matches a single request header against a configured value.
+type headerMatcher struct {
+	name  string
+	value string
+	glob  *regexp.Regexp
+	regex *regexp.Regexp
+	set   map[string]struct{}
+	// ignoreCase compares values case-insensitively, see ignoreValueCase.
+	ignoreCase bool
+	// everyValue checks every value of the header instead of the first one, see matchEveryValue.
+	everyValue bool
+}
+
+// compileHeaderMatchers compiles a header condition map into matchers, sorted by header name.
+func compileHeaderMatchers(headers map[string]string) ([]headerMatcher, error) {
+	headers, err := canonicalizeHeaders(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	matchers := make([]headerMatcher, 0, len(headers))
+	for name, value := range headers {
+		m := headerMatcher{name: name, value: value}
+
+		if strings.HasPrefix(value, globPrefix) {
+			glob, err := compileGlob(strings.TrimPrefix(value, globPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("invalid glob pattern for header %q: %w", name, err)
+			}
+			m.glob = glob
+		}
+
+		if strings.HasPrefix(value, regexPrefix) {
+			regex, err := regexp.Compile(strings.TrimPrefix(value, regexPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("%w for header %q: %w", ErrInvalidRegex, name, err)
+			}
+			m.regex = regex
+		}
+
+		if strings.HasPrefix(value, filePrefix) {
+			set, err := loadValueSet(strings.TrimPrefix(value, filePrefix))
+			if err != nil {
+				return nil, fmt.Errorf("invalid value file for header %q: %w", name, err)
+			}
+			m.set = set
+		}
+
+		matchers = append(matchers, m)
+	}
+
+	sort.Slice(matchers, func(i, j int) bool { return matchers[i].name < matchers[j].name })
+
+	return matchers, nil
+}
+
+// matches checks the header against the configured value.
+// An empty value only checks for the presence of the header.
+func (m headerMatcher) matches(header http.Header) bool {
+	_, ok := m.match(header)
+	return ok
+}
+
+// match returns the header value matching the configured value. Only the first line of the
+// header is checked, unless the matcher checks every value, see matchEveryValue.
+func (m headerMatcher) match(header http.Header) (string, bool) {
+	values := header.Values(m.name)
+	if values == nil {
+		return "", false
+	}
+
+	// If value is empty, match if header exists with any value
+	if m.value == "" {
+		return values[0], true
+	}
+
+	if !m.everyValue {
+		return values[0], m.matchesValue(values[0])
+	}
+
+	// Every line is checked, then each element of comma-separated lines such as "text/html, application/json"
+	for _, line := range values {
+		if m.matchesValue(line) {
+			return line, true
+		}
+		if !strings.Contains(line, ",") {
+			continue
+		}
+		for _, element := range strings.Split(line, ",") {
+			if element = strings.TrimSpace(element); m.matchesValue(element) {
+				return element, true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchesValue checks a single header value against the configured value.
+func (m headerMatcher) matchesValue(actualValue string) bool {
+	// Glob patterns must match the whole value
+	if m.glob != nil {
+		return m.glob.MatchString(actualValue)
+	}
+
+	// Regular expressions may match part of the value
+	if m.regex != nil {
+		return m.regex.MatchString(actualValue)
+	}
+
+	// Value sets accept any of the listed values
+	if m.set != nil {
+		if m.ignoreCase {
+			actualValue = strings.ToLower(actualValue)
+		}
+		_, ok := m.set[actualValue]
+		return ok
+	}
+
+	// Otherwise, check for exact match
+	if m.ignoreCase {
+		return strings.EqualFold(actualValue, m.value)
+	}
+	return actualValue == m.value
+}
+
+// ignoreValueCase makes the matchers compare values case-insensitively,
+// including glob patterns, regular expressions and value sets.
+func ignoreValueCase(matchers []headerMatcher) {
+	for i := range matchers {
+		m := &matchers[i]
+		m.ignoreCase = true
+		// Patterns compiled once already, adding the flag can't make them invalid
+		if m.glob != nil {
+			m.glob = regexp.MustCompile("(?i)" + m.glob.String())
+		}
+		if m.regex != nil {
+			m.regex = regexp.MustCompile("(?i)" + m.regex.String())
+		}
+		if m.set != nil {
+			set := make(map[string]struct{}, len(m.set))
+			for value := range m.set {
+				set[strings.ToLower(value)] = struct{}{}
+			}
+			m.set = set
+		}
+	}
+}
+
+// matchEveryValue makes the matchers check every line of the header and each element of
+// comma-separated lines, matching when any of them does.
+func matchEveryValue(matchers []headerMatcher) {
+	for i := range matchers {
+		matchers[i].everyValue = true
+	}
+}
+
+// captures returns the submatches of a regular expression matcher, or nil when it doesn't match.
+func (m headerMatcher) captures(header http.Header) []string {
+	if m.regex == nil {
+		return nil
+	}
+	value, ok := m.match(header)
+	if !ok {
+		return nil
+	}
+	return m.regex.FindStringSubmatch(value)
+}
+
+// compileGlob translates a glob pattern into an anchored regular expression.
+// It supports the path.Match syntax ('*', '?', '[...]' and '\\' escapes), except
+// that '*' and '?' also match '/' since header values are not paths, and that
+// "[!...]" negates a class like "[^...]" does.
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString(`(?s)^`)
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			expr.WriteString(`.*`)
+		case '?':
+			expr.WriteString(`.`)
+		case '\\':
+			i++
+			if i >= len(pattern) {
+				return nil, fmt.Errorf("trailing escape in %q", pattern)
+			}
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			class, n, err := compileGlobClass(pattern[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("%s in %q", err, pattern)
+			}
+			expr.WriteString(class)
+			i += n
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	expr.WriteString(`$`)
+
+	return regexp.Compile(expr.String())
+}
+
+// compileGlobClass translates the character class at the start of class, which follows
+// the opening '[', returning the regexp class and the number of bytes consumed, ']' included.
+// Like path.Match, "[^...]" negates the class, ranges are written "a-z" and '\\' escapes the
+// next character. "[!...]" is accepted as a negation as well, as in shell globs.
+func compileGlobClass(class string) (string, int, error) {
+	var expr strings.Builder
+	expr.WriteByte('[')
+
+	i := 0
+	if i < len(class) && (class[i] == '^' || class[i] == '!') {
+		expr.WriteByte('^')
+		i++
+	}
+
+	ranges := 0
+	for {
+		if i >= len(class) {
+			return "", 0, fmt.Errorf("unterminated character class")
+		}
+		if class[i] == ']' && ranges > 0 {
+			break
+		}
+
+		lo, n, err := globClassChar(class[i:])
+		if err != nil {
+			return "", 0, err
+		}
+		i += n
+		hi := lo
+		if i < len(class) && class[i] == '-' {
+			hi, n, err = globClassChar(class[i+1:])
+			if err != nil {
+				return "", 0, err
+			}
+			if hi < lo {
+				return "", 0, fmt.Errorf("invalid range %q-%q", lo, hi)
+			}
+			i += 1 + n
+		}
+
+		writeClassRune(&expr, lo)
+		if hi != lo {
+			expr.WriteByte('-')
+			writeClassRune(&expr, hi)
+		}
+		ranges++
+	}
+
+	expr.WriteByte(']')
+	return expr.String(), i + 1, nil
+}
+
+// globClassChar returns the possibly escaped character at the start of s and its length.
+// An unescaped '-' or ']' is rejected, as by path.Match.
+func globClassChar(s string) (rune, int, error) {
+	if s == "" || s[0] == '-' || s[0] == ']' {
+		return 0, 0, fmt.Errorf("empty character class or range")
+	}
+
+	n := 0
+	if s[0] == '\\' {
+		n = 1
+		if len(s) == 1 {
+			return 0, 0, fmt.Errorf("trailing escape")
+		}
+	}
+
+	r, size := utf8.DecodeRuneInString(s[n:])
+	if r == utf8.RuneError && size <= 1 {
+		return 0, 0, fmt.Errorf("invalid UTF-8 in character class")
+	}
+	return r, n + size, nil
+}
+
+// writeClassRune writes r to a regexp character class, escaping ASCII punctuation.
+func writeClassRune(expr *strings.Builder, r rune) {
+	if r < utf8.RuneSelf && !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+		expr.WriteByte('\\')
+	}
+	expr.WriteRune(r)
+}
+
+// loadValueSet reads newline-separated values from a file, ignoring blank lines.
+func loadValueSet(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	set := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		set[line] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
@@ -0,0 +1,377 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestBypassHeaders_Glob(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Test-Header"] = "test-value"
+	cfg.BypassHeaders["User-Agent"] = "glob:*bot*"
+	cfg.BypassHeaders["X-Literal"] = "a*b" // No prefix, exact match only
+
+	testCases := []struct {
+		name         string
+		headers      map[string]string
+		shouldBypass bool
+	}{
+		{"Glob matches anywhere", map[string]string{"User-Agent": "Mozilla/5.0 (compatible; Googlebot/2.1)"}, true},
+		{"Glob does not match", map[string]string{"User-Agent": "Mozilla/5.0 (X11; Linux x86_64)"}, false},
+		{"Literal asterisk matches exactly", map[string]string{"X-Literal": "a*b"}, true},
+		{"Literal is not a glob", map[string]string{"X-Literal": "axxb"}, false},
+		{"Glob requires the header", map[string]string{}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tc.shouldBypass {
+					if req.Header.Get("X-Test-Header") != "" {
+						t.Error("Request headers should not be modified when bypassed")
+					}
+				} else {
+					assertHeader(t, req, "X-Test-Header", "test-value")
+				}
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			for key, value := range tc.headers {
+				req.Header.Set(key, value)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+		})
+	}
+}
+
+func TestBypassHeaders_GlobClasses(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		value   string
+		matches bool
+	}{
+		{"v[0-9]", "v1", true},
+		{"v[0-9]", "vx", false},
+		{"v[^0-9]", "vx", true},
+		{"v[^0-9]", "v1", false},
+		{"v[!0-9]", "vx", true},
+		{"v[!0-9]", "v1", false},
+		{"v[a^]", "v^", true},
+		{"v[a!]", "v!", true},
+		{`v[\]]`, "v]", true},
+		{`v[\-a]`, "v-", true},
+		{"v[é-ë]", "vê", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.pattern+" "+tc.value, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeaders["X-Test-Header"] = "test-value"
+			cfg.BypassHeaders["X-Version"] = "glob:" + tc.pattern
+
+			var bypassed bool
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				bypassed = req.Header.Get("X-Test-Header") == ""
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.Header.Set("X-Version", tc.value)
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+
+			if bypassed != tc.matches {
+				t.Errorf("Expected %q to match %q: %t", tc.pattern, tc.value, tc.matches)
+			}
+		})
+	}
+}
+
+func TestBypassHeaders_InvalidGlob(t *testing.T) {
+	for _, pattern := range []string{"glob:[bot", "glob:bot\\", "glob:[]", "glob:[^]", "glob:[z-a]", "glob:[a-]", "glob:[\\"} {
+		cfg := add_missing_headers.CreateConfig()
+		cfg.BypassHeaders["User-Agent"] = pattern
+
+		next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+		if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+			t.Errorf("Expected an error for invalid glob %q", pattern)
+		}
+	}
+}
+
+func TestRequireHeaders_RegexCaptures(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequireHeaders["X-Tenant"] = `regex:^tenant-(\w+)$`
+	cfg.RequestHeaders["X-Tenant-ID"] = "{{ .Match 1 }}"
+	cfg.ResponseHeaders["X-Served-Tenant"] = "{{ .Match 1 }}"
+	cfg.ResponseHeaders["X-Missing-Group"] = "{{ .Match 2 }}"
+
+	testCases := []struct {
+		name     string
+		tenant   string
+		expected string
+	}{
+		{"Regex matches", "tenant-acme", "acme"},
+		{"Regex doesn't match", "acme", ""},
+		{"Header absent", "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				assertHeader(t, req, "X-Tenant-ID", tc.expected)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			if tc.tenant != "" {
+				req.Header.Set("X-Tenant", tc.tenant)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "X-Served-Tenant", tc.expected)
+			assertResponseHeader(t, recorder, "X-Missing-Group", "")
+		})
+	}
+}
+
+func TestBypassHeaders_InvalidRegex(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.BypassHeaders["X-Tenant"] = "regex:tenant-(\\w+"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+		t.Error("Expected an error for an invalid regular expression")
+	}
+}
+
+func TestBypassHeaders_ValueFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.txt")
+	if err := os.WriteFile(path, []byte("token-a\n\ntoken-b\r\n  token-c  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Test-Header"] = "test-value"
+	cfg.BypassHeaders["X-Bypass-Token"] = "@file:" + path
+
+	testCases := []struct {
+		name         string
+		headers      map[string]string
+		shouldBypass bool
+	}{
+		{"First token", map[string]string{"X-Bypass-Token": "token-a"}, true},
+		{"Token with CRLF line ending", map[string]string{"X-Bypass-Token": "token-b"}, true},
+		{"Token with surrounding spaces", map[string]string{"X-Bypass-Token": "token-c"}, true},
+		{"Unknown token", map[string]string{"X-Bypass-Token": "token-d"}, false},
+		{"Empty token", map[string]string{"X-Bypass-Token": ""}, false},
+		{"Absent header", map[string]string{}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tc.shouldBypass {
+					if req.Header.Get("X-Test-Header") != "" {
+						t.Error("Request headers should not be modified when bypassed")
+					}
+				} else {
+					assertHeader(t, req, "X-Test-Header", "test-value")
+				}
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			for key, value := range tc.headers {
+				req.Header.Set(key, value)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+		})
+	}
+}
+
+func TestBypassHeaders_MissingValueFile(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.BypassHeaders["X-Bypass-Token"] = "@file:" + filepath.Join(t.TempDir(), "missing.txt")
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+		t.Error("Expected an error for a missing value file")
+	}
+}
+
+func TestBypassCaseInsensitive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.txt")
+	if err := os.WriteFile(path, []byte("Token-A\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name            string
+		header          string
+		value           string
+		caseSensitive   bool
+		caseInsensitive bool
+	}{
+		{"Exact value", "X-Skip", "TRUE", false, true},
+		{"Exact value, same case", "X-Skip", "true", true, true},
+		{"Exact value, other value", "X-Skip", "false", false, false},
+		{"Glob", "User-Agent", "Mozilla/5.0 (compatible; GoogleBot/2.1)", false, true},
+		{"Regex", "X-Client", "INTERNAL-42", false, true},
+		{"Value file", "X-Bypass-Token", "token-a", false, true},
+	}
+
+	for _, tc := range testCases {
+		for _, insensitive := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/insensitive=%t", tc.name, insensitive), func(t *testing.T) {
+				cfg := add_missing_headers.CreateConfig()
+				cfg.RequestHeaders["X-Test-Header"] = "test-value"
+				cfg.BypassHeaders["X-Skip"] = "true"
+				cfg.BypassHeaders["User-Agent"] = "glob:*bot*"
+				cfg.BypassHeaders["X-Client"] = "regex:^internal-[0-9]+$"
+				cfg.BypassHeaders["X-Bypass-Token"] = "@file:" + path
+				cfg.BypassCaseInsensitive = insensitive
+
+				shouldBypass := tc.caseSensitive
+				if insensitive {
+					shouldBypass = tc.caseInsensitive
+				}
+
+				next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+					expected := "test-value"
+					if shouldBypass {
+						expected = ""
+					}
+					assertHeader(t, req, "X-Test-Header", expected)
+				})
+
+				req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+				req.Header.Set(tc.header, tc.value)
+
+				newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+			})
+		}
+	}
+}
+
+func TestBypassHeaders_MultipleValues(t *testing.T) {
+	testCases := []struct {
+		name           string
+		values         []string
+		bypass         bool
+		expectedReason string
+	}{
+		{"Second line", []string{"text/html", "application/json"}, true, "application/json"},
+		{"Second element", []string{"text/html, application/json"}, true, "application/json"},
+		{"Elements compared whole", []string{"application/json;q=0.9, application/json"}, true, "application/json"},
+		{"No match", []string{"text/html, text/plain"}, false, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeaders["X-Test-Header"] = "test-value"
+			cfg.BypassHeaders["Accept"] = "application/json"
+			cfg.RecordBypassReason = true
+
+			var reason add_missing_headers.BypassReason
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				expected := "test-value"
+				if tc.bypass {
+					expected = ""
+				}
+				assertHeader(t, req, "X-Test-Header", expected)
+				reason, _ = req.Context().Value(add_missing_headers.BypassReasonKey).(add_missing_headers.BypassReason)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			for _, value := range tc.values {
+				req.Header.Add("Accept", value)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+
+			if reason.Value != tc.expectedReason {
+				t.Errorf("Expected bypass reason value %q, got %q", tc.expectedReason, reason.Value)
+			}
+		})
+	}
+}
+
+func TestRequireHeaders_FirstValueOnly(t *testing.T) {
+	testCases := []struct {
+		name     string
+		values   []string
+		required string
+		applied  bool
+	}{
+		{"First line matches", []string{"application/json", "text/html"}, "application/json", true},
+		{"Second line ignored", []string{"text/html", "application/json"}, "application/json", false},
+		{"Line compared whole", []string{"text/html, application/json"}, "application/json", false},
+		{"Comma-separated value", []string{"text/html, application/json"}, "text/html, application/json", true},
+		{"Regex on the first line", []string{"tenant-acme", "tenant-other"}, `regex:^tenant-other$`, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeaders["X-Test-Header"] = "test-value"
+			cfg.RequireHeaders["Accept"] = tc.required
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				expected := ""
+				if tc.applied {
+					expected = "test-value"
+				}
+				assertHeader(t, req, "X-Test-Header", expected)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			for _, value := range tc.values {
+				req.Header.Add("Accept", value)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+		})
+	}
+}
+
+func TestRequireHeaders_RegexCapturesFirstValue(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequireHeaders["X-Tenant"] = `regex:^tenant-(\w+)$`
+	cfg.RequestHeaders["X-Tenant-ID"] = "{{ .Match 1 }}"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assertHeader(t, req, "X-Tenant-ID", "acme")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Add("X-Tenant", "tenant-acme")
+	req.Header.Add("X-Tenant", "tenant-other")
+
+	newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+}
@@ -0,0 +1,52 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"sort"
+)
+
+// compileMultiHeaders converts a multi-value header map into entries sorted by canonical name.
+// Each value is added as a separate header line.
+func compileMultiHeaders(headers map[string][]string) ([]headerEntry, error) {
+	seen := make(map[string]string, len(headers))
+	entries := make([]headerEntry, 0, len(headers))
+	for key, values := range headers {
+		canonicalKey, err := canonicalHeaderName(seen, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(values) == 0 {
+			return nil, fmt.Errorf("header %q: no values", key)
+		}
+
+		entries = append(entries, headerEntry{key: canonicalKey, name: key, values: append([]string(nil), values...)})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	return entries, nil
+}
+
+// withMultiHeaders returns multi-value headers followed by headers, so that
+// multi-value headers win when both define the same header.
+func withMultiHeaders(multi, headers []headerEntry) []headerEntry {
+	if len(multi) == 0 {
+		return headers
+	}
+	return append(append(make([]headerEntry, 0, len(multi)+len(headers)), multi...), headers...)
+}
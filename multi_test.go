@@ -0,0 +1,103 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestMultiValueHeaders(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["Accept"] = "text/plain"
+	cfg.RequestHeadersMulti = map[string][]string{
+		"accept": {"text/html", "application/json"},
+	}
+	cfg.ResponseHeaders["X-Single"] = "single"
+	cfg.ResponseHeadersMulti = map[string][]string{
+		"Set-Cookie": {"a=1; Path=/", "b=2; Path=/"},
+	}
+
+	var accept []string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		accept = req.Header.Values("Accept")
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	// Multi-value headers win over single values
+	if expected := []string{"text/html", "application/json"}; !reflect.DeepEqual(accept, expected) {
+		t.Errorf("Expected Accept %q, got %q", expected, accept)
+	}
+
+	// Every cookie is a separate header line
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 2 || cookies[0].Name != "a" || cookies[1].Name != "b" {
+		t.Errorf("Expected cookies a and b, got %v", cookies)
+	}
+	assertResponseHeader(t, recorder, "X-Single", "single")
+}
+
+func TestMultiValueHeaders_Existing(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeadersMulti = map[string][]string{
+		"Set-Cookie": {"a=1", "b=2"},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Add("Set-Cookie", "session=abc")
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	if values := recorder.Header().Values("Set-Cookie"); !reflect.DeepEqual(values, []string{"session=abc"}) {
+		t.Errorf("Expected the upstream cookie only, got %q", values)
+	}
+}
+
+func TestMultiValueHeaders_Invalid(t *testing.T) {
+	testCases := []struct {
+		name  string
+		multi map[string][]string
+	}{
+		{"No values", map[string][]string{"Accept": {}}},
+		{"Duplicate canonical names", map[string][]string{"accept": {"a"}, "ACCEPT": {"b"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeadersMulti = tc.multi
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
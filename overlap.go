@@ -0,0 +1,111 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+const (
+	// overlapActionWarn logs bypass headers also added to requests.
+	overlapActionWarn = "warn"
+	// overlapActionError rejects the configuration when bypass headers are also added to requests.
+	overlapActionError = "error"
+)
+
+// requestHeaderSets returns every compiled list of request headers, named after the option configuring it.
+func (p *Plugin) requestHeaderSets() []headerSet {
+	sets := []headerSet{
+		{"requestHeaders", p.currentFileHeaders().requestHeaders},
+		{"requestHeadersMulti", p.requestMultiHeaders},
+		{"idempotencyHeaders", p.idempotencyHeaders},
+	}
+
+	for i, c := range p.queryConditions {
+		sets = append(sets, headerSet{fmt.Sprintf("queryConditions[%d].requestHeaders", i), c.requestHeaders})
+	}
+
+	for _, r := range p.sizeRules {
+		sets = append(sets, headerSet{fmt.Sprintf("requestSizeHeaders[%d]", r.minBytes), r.requestHeaders})
+	}
+
+	if p.hashBuckets != nil {
+		for i, b := range p.hashBuckets.buckets {
+			sets = append(sets, headerSet{fmt.Sprintf("hashBuckets[%d].requestHeaders", i), b.requestHeaders})
+		}
+	}
+
+	return sets
+}
+
+// bypassOverlaps lists the bypass headers the middleware also adds to requests.
+func (p *Plugin) bypassOverlaps() []string {
+	if len(p.bypassHeaders) == 0 {
+		return nil
+	}
+
+	added := make(map[string][]string)
+	for _, set := range p.requestHeaderSets() {
+		for _, entry := range set.entries {
+			added[entry.key] = append(added[entry.key], set.name)
+		}
+	}
+	for _, h := range p.weightedHeaders {
+		added[h.key] = append(added[h.key], "weightedHeaders")
+	}
+	for _, h := range p.contextHeaders {
+		added[h.key] = append(added[h.key], "contextHeaders")
+	}
+	for _, h := range p.fallbackHeaders {
+		added[h.key] = append(added[h.key], "fallbackHeaders")
+	}
+	if p.cidrLabelHeader != "" {
+		key := textproto.CanonicalMIMEHeaderKey(p.cidrLabelHeader)
+		added[key] = append(added[key], "cidrLabelHeader")
+	}
+	if p.requestIDHeader != "" {
+		added[p.requestIDHeader] = append(added[p.requestIDHeader], "requestIDHeader")
+	}
+
+	var overlaps []string
+	for _, m := range p.bypassHeaders {
+		if options, ok := added[m.name]; ok {
+			overlaps = append(overlaps, fmt.Sprintf("%q (%s)", m.name, strings.Join(options, ", ")))
+		}
+	}
+	sort.Strings(overlaps)
+
+	return overlaps
+}
+
+// checkBypassOverlaps reports bypass headers also added to requests, whose value
+// set by this middleware could bypass later middlewares or instances.
+func (p *Plugin) checkBypassOverlaps(action string) error {
+	overlaps := p.bypassOverlaps()
+	if len(overlaps) == 0 {
+		return nil
+	}
+
+	message := "headers also added to requests: " + strings.Join(overlaps, ", ")
+	if action == overlapActionError {
+		return errors.New(message)
+	}
+	p.logf("bypassHeaders: %s", message)
+	return nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestBypassOverlap(t *testing.T) {
+	testCases := []struct {
+		name     string
+		setup    func(cfg *add_missing_headers.Config)
+		expected []string
+	}{
+		{
+			name: "request header",
+			setup: func(cfg *add_missing_headers.Config) {
+				cfg.RequestHeaders["x-internal"] = "true"
+			},
+			expected: []string{`"X-Internal" (requestHeaders)`},
+		},
+		{
+			name: "several sources",
+			setup: func(cfg *add_missing_headers.Config) {
+				cfg.RequestHeaders["X-Internal"] = "true"
+				cfg.IdempotencyHeaders["X-Internal"] = "true"
+				cfg.CIDRLabelHeader = "x-internal"
+			},
+			expected: []string{`"X-Internal" (requestHeaders, idempotencyHeaders, cidrLabelHeader)`},
+		},
+		{
+			name: "request ID",
+			setup: func(cfg *add_missing_headers.Config) {
+				cfg.GenerateRequestID = true
+				cfg.RequestIDHeader = "x-internal"
+			},
+			expected: []string{`"X-Internal" (requestIDHeader)`},
+		},
+		{
+			name: "response header only",
+			setup: func(cfg *add_missing_headers.Config) {
+				cfg.ResponseHeaders["X-Internal"] = "true"
+			},
+		},
+		{
+			name: "other request header",
+			setup: func(cfg *add_missing_headers.Config) {
+				cfg.RequestHeaders["X-Other"] = "true"
+			},
+		},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			defer add_missing_headers.SetLogOutput(&logs)()
+
+			cfg := add_missing_headers.CreateConfig()
+			cfg.BypassHeaders["X-Internal"] = "true"
+			tc.setup(cfg)
+
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(tc.expected) == 0 {
+				if logs.Len() != 0 {
+					t.Errorf("Expected no warning, got %q", logs.String())
+				}
+				return
+			}
+			for _, expected := range tc.expected {
+				if !strings.Contains(logs.String(), expected) {
+					t.Errorf("Expected warning to contain %q, got %q", expected, logs.String())
+				}
+			}
+
+			cfg.BypassOverlapAction = "error"
+			_, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin")
+			if err == nil {
+				t.Fatal("Expected an error from New")
+			}
+			for _, expected := range tc.expected {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("Expected error to contain %q, got %q", expected, err.Error())
+				}
+			}
+		})
+	}
+}
+
+func TestBypassOverlapAction_Unknown(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.BypassOverlapAction = "ignore"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+		t.Error("Expected an error from New")
+	}
+}
@@ -16,81 +16,1126 @@
 package add_missing_headers
 
 import (
-	"bufio"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/textproto"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// requestCountHeader is the response header carrying the per-instance request counter.
+	requestCountHeader = "X-Request-Count"
+	// idempotencyKeyHeader is the request header marking a request as deduplicable.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// dryRunHeader is the response header listing the headers that would change in dry-run mode.
+	dryRunHeader = "X-Add-Missing-Headers-DryRun"
+	// flushModeHeader is the response header reporting the effective flush mode.
+	flushModeHeader = "X-Flush"
+	// defaultProcessedHeader names the plugin instances that processed a response.
+	defaultProcessedHeader = "X-Processed-By"
+	// defaultRouterHeader is the response header carrying the router name.
+	defaultRouterHeader = "X-Router"
+	// defaultRouterContextKey is the request context key holding the router name.
+	defaultRouterContextKey = "router"
 )
 
 // Config holds the plugin configuration.
 type Config struct {
-	RequestHeaders       map[string]string `yaml:"requestHeaders,omitempty"`
-	ResponseHeaders      map[string]string `yaml:"responseHeaders,omitempty"`
-	DisableExplicitFlush bool              `yaml:"disableExplicitFlush,omitempty"`
-	StrictHeaderCheck    bool              `yaml:"strictHeaderCheck,omitempty"`
-	BypassHeaders        map[string]string `yaml:"bypassHeaders,omitempty"`
+	RequestHeaders                   map[string]string            `json:"requestHeaders,omitempty" yaml:"requestHeaders,omitempty"`
+	ResponseHeaders                  map[string]string            `json:"responseHeaders,omitempty" yaml:"responseHeaders,omitempty"`
+	DisableExplicitFlush             bool                         `json:"disableExplicitFlush,omitempty" yaml:"disableExplicitFlush,omitempty"`
+	StrictHeaderCheck                bool                         `json:"strictHeaderCheck,omitempty" yaml:"strictHeaderCheck,omitempty"`
+	BypassHeaders                    map[string]string            `json:"bypassHeaders,omitempty" yaml:"bypassHeaders,omitempty"`
+	DisableHeader                    DisableHeader                `json:"disableHeader,omitempty" yaml:"disableHeader,omitempty"`
+	EmitRequestCount                 bool                         `json:"emitRequestCount,omitempty" yaml:"emitRequestCount,omitempty"`
+	RequireHeaders                   map[string]string            `json:"requireHeaders,omitempty" yaml:"requireHeaders,omitempty"`
+	AutoVary                         bool                         `json:"autoVary,omitempty" yaml:"autoVary,omitempty"`
+	CloneRequest                     bool                         `json:"cloneRequest,omitempty" yaml:"cloneRequest,omitempty"`
+	ExcludeStatuses                  []int                        `json:"excludeStatuses,omitempty" yaml:"excludeStatuses,omitempty"`
+	RequestHeadersFile               string                       `json:"requestHeadersFile,omitempty" yaml:"requestHeadersFile,omitempty"`
+	ResponseHeadersFile              string                       `json:"responseHeadersFile,omitempty" yaml:"responseHeadersFile,omitempty"`
+	CIDRLabelHeader                  string                       `json:"cidrLabelHeader,omitempty" yaml:"cidrLabelHeader,omitempty"`
+	CIDRLabels                       []CIDRLabel                  `json:"cidrLabels,omitempty" yaml:"cidrLabels,omitempty"`
+	EnableGzip                       bool                         `json:"enableGzip,omitempty" yaml:"enableGzip,omitempty"`
+	GzipContentTypes                 []string                     `json:"gzipContentTypes,omitempty" yaml:"gzipContentTypes,omitempty"`
+	IdempotencyHeaders               map[string]string            `json:"idempotencyHeaders,omitempty" yaml:"idempotencyHeaders,omitempty"`
+	HostHeaders                      map[string]map[string]string `json:"hostHeaders,omitempty" yaml:"hostHeaders,omitempty"`
+	ResponseHeaderFromResponseHeader map[string]DerivedHeader     `json:"responseHeaderFromResponseHeader,omitempty" yaml:"responseHeaderFromResponseHeader,omitempty"`
+	DryRun                           bool                         `json:"dryRun,omitempty" yaml:"dryRun,omitempty"`
+	UpstreamTimeout                  string                       `json:"upstreamTimeout,omitempty" yaml:"upstreamTimeout,omitempty"`
+	ConditionalGetHeaders            map[string]string            `json:"conditionalGetHeaders,omitempty" yaml:"conditionalGetHeaders,omitempty"`
+	QueryConditions                  []QueryCondition             `json:"queryConditions,omitempty" yaml:"queryConditions,omitempty"`
+	EmitFlushMode                    bool                         `json:"emitFlushMode,omitempty" yaml:"emitFlushMode,omitempty"`
+	ApplyWhen                        ApplyWhen                    `json:"applyWhen,omitempty" yaml:"applyWhen,omitempty"`
+	TreatEmptyAsMissing              bool                         `json:"treatEmptyAsMissing,omitempty" yaml:"treatEmptyAsMissing,omitempty"`
+	RecordBypassReason               bool                         `json:"recordBypassReason,omitempty" yaml:"recordBypassReason,omitempty"`
+	OverrideStatusCode               map[string]int               `json:"overrideStatusCode,omitempty" yaml:"overrideStatusCode,omitempty"`
+	OriginalStatusHeader             string                       `json:"originalStatusHeader,omitempty" yaml:"originalStatusHeader,omitempty"`
+	RequireResponseHeaders           []string                     `json:"requireResponseHeaders,omitempty" yaml:"requireResponseHeaders,omitempty"`
+	Presets                          []string                     `json:"presets,omitempty" yaml:"presets,omitempty"`
+	StripServerHeader                bool                         `json:"stripServerHeader,omitempty" yaml:"stripServerHeader,omitempty"`
+	HashBuckets                      HashBuckets                  `json:"hashBuckets,omitempty" yaml:"hashBuckets,omitempty"`
+	BypassCIDRs                      []string                     `json:"bypassCIDRs,omitempty" yaml:"bypassCIDRs,omitempty"`
+	TrustForwardedFor                bool                         `json:"trustForwardedFor,omitempty" yaml:"trustForwardedFor,omitempty"`
+	TrimValues                       bool                         `json:"trimValues,omitempty" yaml:"trimValues,omitempty"`
+	MaxHeaderValueLength             int                          `json:"maxHeaderValueLength,omitempty" yaml:"maxHeaderValueLength,omitempty"`
+	MaxHeaderValueAction             string                       `json:"maxHeaderValueAction,omitempty" yaml:"maxHeaderValueAction,omitempty"`
+	ResponseHeaderDependencies       map[string]string            `json:"responseHeaderDependencies,omitempty" yaml:"responseHeaderDependencies,omitempty"`
+	ResponseHeaderRewrites           map[string]HeaderRewrite     `json:"responseHeaderRewrites,omitempty" yaml:"responseHeaderRewrites,omitempty"`
+	ContextHeaders                   map[string]string            `json:"contextHeaders,omitempty" yaml:"contextHeaders,omitempty"`
+	DisableRequestHeaders            bool                         `json:"disableRequestHeaders,omitempty" yaml:"disableRequestHeaders,omitempty"`
+	DisableResponseHeaders           bool                         `json:"disableResponseHeaders,omitempty" yaml:"disableResponseHeaders,omitempty"`
+	RequestHeadersMulti              map[string][]string          `json:"requestHeadersMulti,omitempty" yaml:"requestHeadersMulti,omitempty"`
+	ResponseHeadersMulti             map[string][]string          `json:"responseHeadersMulti,omitempty" yaml:"responseHeadersMulti,omitempty"`
+	RemoveResponseHeaderPrefixes     []string                     `json:"removeResponseHeaderPrefixes,omitempty" yaml:"removeResponseHeaderPrefixes,omitempty"`
+	WrapWebSocketUpgrades            bool                         `json:"wrapWebSocketUpgrades,omitempty" yaml:"wrapWebSocketUpgrades,omitempty"`
+	ResponseHeaderRequestConditions  map[string]map[string]string `json:"responseHeaderRequestConditions,omitempty" yaml:"responseHeaderRequestConditions,omitempty"`
+	UnsafeValueAction                string                       `json:"unsafeValueAction,omitempty" yaml:"unsafeValueAction,omitempty"`
+	SuccessfulOnly                   bool                         `json:"successfulOnly,omitempty" yaml:"successfulOnly,omitempty"`
+	BypassMode                       string                       `json:"bypassMode,omitempty" yaml:"bypassMode,omitempty"`
+	RequireScheme                    string                       `json:"requireScheme,omitempty" yaml:"requireScheme,omitempty"`
+	TrustForwardedProto              bool                         `json:"trustForwardedProto,omitempty" yaml:"trustForwardedProto,omitempty"`
+	MaxRequestHeaders                int                          `json:"maxRequestHeaders,omitempty" yaml:"maxRequestHeaders,omitempty"`
+	RecoverPanics                    bool                         `json:"recoverPanics,omitempty" yaml:"recoverPanics,omitempty"`
+	RepanicAfterRecover              bool                         `json:"repanicAfterRecover,omitempty" yaml:"repanicAfterRecover,omitempty"`
+	StatusRules                      []StatusRule                 `json:"statusRules,omitempty" yaml:"statusRules,omitempty"`
+	BypassTimeWindows                []string                     `json:"bypassTimeWindows,omitempty" yaml:"bypassTimeWindows,omitempty"`
+	PreserveHeaderCase               bool                         `json:"preserveHeaderCase,omitempty" yaml:"preserveHeaderCase,omitempty"`
+	StampProcessedHeader             bool                         `json:"stampProcessedHeader,omitempty" yaml:"stampProcessedHeader,omitempty"`
+	ProcessedHeaderName              string                       `json:"processedHeaderName,omitempty" yaml:"processedHeaderName,omitempty"`
+	BypassOverlapAction              string                       `json:"bypassOverlapAction,omitempty" yaml:"bypassOverlapAction,omitempty"`
+	JWTClaim                         JWTClaim                     `json:"jwtClaim,omitempty" yaml:"jwtClaim,omitempty"`
+	DropContentLength                bool                         `json:"dropContentLength,omitempty" yaml:"dropContentLength,omitempty"`
+	RequestHeaderAllowlist           []string                     `json:"requestHeaderAllowlist,omitempty" yaml:"requestHeaderAllowlist,omitempty"`
+	WeightedHeaders                  map[string][]WeightedValue   `json:"weightedHeaders,omitempty" yaml:"weightedHeaders,omitempty"`
+	WarnUnflushableWriter            bool                         `json:"warnUnflushableWriter,omitempty" yaml:"warnUnflushableWriter,omitempty"`
+	RestoreRequestHeaders            bool                         `json:"restoreRequestHeaders,omitempty" yaml:"restoreRequestHeaders,omitempty"`
+	BypassIfMissing                  []string                     `json:"bypassIfMissing,omitempty" yaml:"bypassIfMissing,omitempty"`
+	BypassCaseInsensitive            bool                         `json:"bypassCaseInsensitive,omitempty" yaml:"bypassCaseInsensitive,omitempty"`
+	FileReloadInterval               string                       `json:"fileReloadInterval,omitempty" yaml:"fileReloadInterval,omitempty"`
+	StampRouterHeader                bool                         `json:"stampRouterHeader,omitempty" yaml:"stampRouterHeader,omitempty"`
+	RouterHeaderName                 string                       `json:"routerHeaderName,omitempty" yaml:"routerHeaderName,omitempty"`
+	RouterContextKey                 string                       `json:"routerContextKey,omitempty" yaml:"routerContextKey,omitempty"`
+	ManageXFF                        bool                         `json:"manageXFF,omitempty" yaml:"manageXFF,omitempty"`
+	TemplateCacheSize                int                          `json:"templateCacheSize,omitempty" yaml:"templateCacheSize,omitempty"`
+	TemplateCacheKey                 string                       `json:"templateCacheKey,omitempty" yaml:"templateCacheKey,omitempty"`
+	RequestSizeHeaders               map[string]map[string]string `json:"requestSizeHeaders,omitempty" yaml:"requestSizeHeaders,omitempty"`
+	StripHopByHop                    bool                         `json:"stripHopByHop,omitempty" yaml:"stripHopByHop,omitempty"`
+	SkipIfResponseHeaderPresent      map[string]string            `json:"skipIfResponseHeaderPresent,omitempty" yaml:"skipIfResponseHeaderPresent,omitempty"`
+	RemoveResponseHeaderPatterns     []string                     `json:"removeResponseHeaderPatterns,omitempty" yaml:"removeResponseHeaderPatterns,omitempty"`
+	RequestHeaderTransforms          map[string]string            `json:"requestHeaderTransforms,omitempty" yaml:"requestHeaderTransforms,omitempty"`
+	FallbackHeaders                  map[string]FallbackHeader    `json:"fallbackHeaders,omitempty" yaml:"fallbackHeaders,omitempty"`
+	SkipWhenEncoded                  []string                     `json:"skipWhenEncoded,omitempty" yaml:"skipWhenEncoded,omitempty"`
+	GenerateRequestID                bool                         `json:"generateRequestID,omitempty" yaml:"generateRequestID,omitempty"`
+	RequestIDHeader                  string                       `json:"requestIDHeader,omitempty" yaml:"requestIDHeader,omitempty"`
+	EchoRequestID                    bool                         `json:"echoRequestID,omitempty" yaml:"echoRequestID,omitempty"`
+	RetryAfterOnGatewayErrors        int                          `json:"retryAfterOnGatewayErrors,omitempty" yaml:"retryAfterOnGatewayErrors,omitempty"`
+	AuditInjectedHeader              string                       `json:"auditInjectedHeader,omitempty" yaml:"auditInjectedHeader,omitempty"`
+	BypassScope                      string                       `json:"bypassScope,omitempty" yaml:"bypassScope,omitempty"`
+}
+
+// DisableHeader configures a signed request header that fully disables the plugin for a request.
+// The header value must be "<nonce>:<signature>", where signature is the hex-encoded
+// HMAC-SHA256 of the nonce computed with Secret.
+type DisableHeader struct {
+	Name   string `json:"name,omitempty" yaml:"name,omitempty"`
+	Secret string `json:"secret,omitempty" yaml:"secret,omitempty"`
 }
 
 // CreateConfig creates the default plugin configuration.
 func CreateConfig() *Config {
 	return &Config{
-		RequestHeaders:       make(map[string]string),
-		ResponseHeaders:      make(map[string]string),
-		DisableExplicitFlush: false,
-		StrictHeaderCheck:    true, // Default to strict (only add if header doesn't exist)
-		BypassHeaders:        make(map[string]string),
+		RequestHeaders:        make(map[string]string),
+		ResponseHeaders:       make(map[string]string),
+		DisableExplicitFlush:  false, // Flushing is automatic, streaming responses are flushed after every write
+		StrictHeaderCheck:     true,  // Default to strict (only add if header doesn't exist)
+		BypassHeaders:         make(map[string]string),
+		RequireHeaders:        make(map[string]string),
+		IdempotencyHeaders:    make(map[string]string),
+		ConditionalGetHeaders: make(map[string]string),
 	}
 }
 
 // Plugin holds the necessary components of a Traefik plugin.
 type Plugin struct {
-	name                 string
-	next                 http.Handler
-	requestHeaders       map[string]string
-	responseHeaders      map[string]string
-	disableExplicitFlush bool
-	strictHeaderCheck    bool
-	bypassHeaders        map[string]string
+	// requestCount is accessed atomically and kept first for 64-bit alignment on 32-bit platforms.
+	requestCount uint64
+
+	name                   string
+	next                   http.Handler
+	disableExplicitFlush   bool
+	strictHeaderCheck      bool
+	bypassHeaders          []headerMatcher
+	disableHeader          DisableHeader
+	emitRequestCount       bool
+	requireHeaders         []headerMatcher
+	varyHeaders            []string
+	cloneRequest           bool
+	excludeStatuses        map[int]bool
+	cidrLabelHeader        string
+	cidrLabels             []labeledNetwork
+	enableGzip             bool
+	gzipContentTypes       []string
+	idempotencyHeaders     []headerEntry
+	derivedHeaders         []derivedHeader
+	dryRun                 bool
+	upstreamTimeout        time.Duration
+	conditionalGetHeaders  []headerEntry
+	queryConditions        []queryCondition
+	emitFlushMode          bool
+	applyWhen              *applyCondition
+	treatEmptyAsMissing    bool
+	recordBypassReason     bool
+	overrideStatusCodes    map[int]int
+	originalStatusHeader   string
+	requireResponseHeaders []string
+	stripServerHeader      bool
+	hashBuckets            *hashBuckets
+	bypassCIDRs            []*net.IPNet
+	trustForwardedFor      bool
+	maxHeaderValueLength   int
+	maxHeaderValueAction   string
+	headerDependencies     map[string]string
+	headerRewrites         []headerRewrite
+	contextHeaders         []contextHeader
+	disableRequestHeaders  bool
+	disableResponseHeaders bool
+	wrapWebSocketUpgrades  bool
+	requestMultiHeaders    []headerEntry
+	responseMultiHeaders   []headerEntry
+	requestHook            func(req *http.Request)
+	responseHook           func(code int, header http.Header)
+	removePrefixes         []string
+	effectiveConfig        *Config
+	requestConditions      map[string][]headerMatcher
+	unsafeValueAction      string
+	successfulOnly         bool
+	bypassMode             string
+	requireScheme          string
+	trustForwardedProto    bool
+	maxRequestHeaders      int
+	readsHeaders           bool
+	recoverPanics          bool
+	repanicAfterRecover    bool
+	statusRules            []statusRule
+	bypassTimeWindows      []timeWindow
+	preserveHeaderCase     bool
+	processedHeader        string
+	jwtClaim               *jwtCondition
+	dropContentLength      bool
+	requestAllowlist       map[string]bool
+	weightedHeaders        []weightedHeader
+	rand                   *lockedRand
+	warnUnflushable        bool
+	restoreRequestHeaders  bool
+	bypassIfMissing        []string
+	routerHeader           string
+	routerContextKey       string
+	manageXFF              bool
+	// templateCache reuses template outputs for requests with the same templateCacheKey value.
+	templateCache    *templateCache
+	templateCacheKey string
+	sizeRules        []sizeRule
+	stripHopByHop    bool
+	// skipResponseMatchers leave responses carrying a matching header untouched.
+	skipResponseMatchers []headerMatcher
+	removePatterns       []string
+	requestTransforms    []headerTransform
+	fallbackHeaders      []fallbackHeader
+	// skipWhenEncoded lists the canonical response headers not added to encoded responses.
+	skipWhenEncoded map[string]bool
+	// requestIDHeader is set when request IDs are generated, echoRequestID copies it to responses.
+	requestIDHeader string
+	echoRequestID   bool
+	// auditHeader is the response header listing the request headers injected by the plugin.
+	auditHeader string
+	bypassScope string
+	// files holds the current *fileHeaders, replaced when header files are reloaded.
+	files atomic.Value
+	// unflushableWarned is set atomically once the unflushable writer warning is logged.
+	unflushableWarned uint32
+}
+
+// requestState holds per-request results shared by the request and response phases.
+type requestState struct {
+	queryConditions []*queryCondition
+	bucket          *hashBucket
+	captures        []string
 }
 
 // New instantiates and returns the required components used to handle an HTTP request.
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
-	return &Plugin{
-		name:                 name,
-		next:                 next,
-		requestHeaders:       config.RequestHeaders,
-		responseHeaders:      config.ResponseHeaders,
-		disableExplicitFlush: config.DisableExplicitFlush,
-		strictHeaderCheck:    config.StrictHeaderCheck,
-		bypassHeaders:        config.BypassHeaders,
-	}, nil
+	return NewWithOptions(ctx, next, config, name)
+}
+
+// NewWithOptions is like New, with options that can't be expressed in Traefik configuration.
+func NewWithOptions(ctx context.Context, next http.Handler, config *Config, name string, opts ...Option) (http.Handler, error) {
+	if config.TrimValues {
+		config = trimConfigValues(config)
+	}
+
+	if config.DisableHeader.Name != "" && config.DisableHeader.Secret == "" {
+		return nil, fmt.Errorf("disableHeader %q requires a secret", config.DisableHeader.Name)
+	}
+
+	files, err := compileFileHeaders(config)
+	if err != nil {
+		return nil, err
+	}
+
+	requestMultiHeaders, err := compileMultiHeaders(config.RequestHeadersMulti)
+	if err != nil {
+		return nil, fmt.Errorf("requestHeadersMulti: %w", err)
+	}
+
+	responseMultiHeaders, err := compileMultiHeaders(config.ResponseHeadersMulti)
+	if err != nil {
+		return nil, fmt.Errorf("responseHeadersMulti: %w", err)
+	}
+
+	idempotencyHeaders, err := compileHeaders(config.IdempotencyHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("idempotencyHeaders: %w", err)
+	}
+
+	conditionalGetHeaders, err := compileHeaders(config.ConditionalGetHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("conditionalGetHeaders: %w", err)
+	}
+
+	queryConditions, err := compileQueryConditions(config.QueryConditions)
+	if err != nil {
+		return nil, fmt.Errorf("queryConditions: %w", err)
+	}
+
+	sizeRules, err := compileSizeRules(config.RequestSizeHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("requestSizeHeaders: %w", err)
+	}
+
+	skipResponseMatchers, err := compileHeaderMatchers(config.SkipIfResponseHeaderPresent)
+	if err != nil {
+		return nil, fmt.Errorf("skipIfResponseHeaderPresent: %w", err)
+	}
+
+	requestTransforms, err := compileHeaderTransforms(config.RequestHeaderTransforms)
+	if err != nil {
+		return nil, fmt.Errorf("requestHeaderTransforms: %w", err)
+	}
+
+	fallbackHeaders, err := compileFallbackHeaders(config.FallbackHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("fallbackHeaders: %w", err)
+	}
+
+	skipWhenEncoded := make(map[string]bool, len(config.SkipWhenEncoded))
+	for _, name := range config.SkipWhenEncoded {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("skipWhenEncoded: %w %q", ErrInvalidHeaderName, name)
+		}
+		skipWhenEncoded[textproto.CanonicalMIMEHeaderKey(name)] = true
+	}
+
+	bypassCIDRs, err := parseCIDRs(config.BypassCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("bypassCIDRs: %w", err)
+	}
+
+	hashBuckets, err := compileHashBuckets(config.HashBuckets)
+	if err != nil {
+		return nil, fmt.Errorf("hashBuckets: %w", err)
+	}
+
+	applyWhen, err := compileApplyWhen(config.ApplyWhen)
+	if err != nil {
+		return nil, fmt.Errorf("applyWhen: %w", err)
+	}
+
+	jwtClaim, err := compileJWTClaim(config.JWTClaim)
+	if err != nil {
+		return nil, fmt.Errorf("jwtClaim: %w", err)
+	}
+
+	derivedHeaders, err := compileDerivedHeaders(config.ResponseHeaderFromResponseHeader)
+	if err != nil {
+		return nil, fmt.Errorf("responseHeaderFromResponseHeader: %w", err)
+	}
+
+	bypassHeaders, err := compileHeaderMatchers(config.BypassHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("bypassHeaders: %w", err)
+	}
+	matchEveryValue(bypassHeaders)
+	if config.BypassCaseInsensitive {
+		ignoreValueCase(bypassHeaders)
+	}
+
+	bypassIfMissing := make([]string, 0, len(config.BypassIfMissing))
+	for _, name := range config.BypassIfMissing {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("bypassIfMissing: %w %q", ErrInvalidHeaderName, name)
+		}
+		bypassIfMissing = append(bypassIfMissing, textproto.CanonicalMIMEHeaderKey(name))
+	}
+
+	requireHeaders, err := compileHeaderMatchers(config.RequireHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("requireHeaders: %w", err)
+	}
+
+	cidrLabels, err := parseCIDRLabels(config.CIDRLabels)
+	if err != nil {
+		return nil, fmt.Errorf("cidrLabels: %w", err)
+	}
+	if len(cidrLabels) > 0 && config.CIDRLabelHeader == "" {
+		return nil, fmt.Errorf("cidrLabels requires cidrLabelHeader to be set")
+	}
+	if config.CIDRLabelHeader != "" && !validHeaderName(config.CIDRLabelHeader) {
+		return nil, fmt.Errorf("cidrLabelHeader: %w %q", ErrInvalidHeaderName, config.CIDRLabelHeader)
+	}
+
+	var upstreamTimeout time.Duration
+	if config.UpstreamTimeout != "" {
+		upstreamTimeout, err = time.ParseDuration(config.UpstreamTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("upstreamTimeout: %w", err)
+		}
+		if upstreamTimeout <= 0 {
+			return nil, fmt.Errorf("upstreamTimeout: must be positive, got %s", config.UpstreamTimeout)
+		}
+	}
+
+	requireResponseHeaders := make([]string, 0, len(config.RequireResponseHeaders))
+	for _, name := range config.RequireResponseHeaders {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("requireResponseHeaders: %w %q", ErrInvalidHeaderName, name)
+		}
+		requireResponseHeaders = append(requireResponseHeaders, textproto.CanonicalMIMEHeaderKey(name))
+	}
+
+	overrideStatusCodes, err := compileStatusOverrides(config.OverrideStatusCode)
+	if err != nil {
+		return nil, fmt.Errorf("overrideStatusCode: %w", err)
+	}
+	if config.OriginalStatusHeader != "" && !validHeaderName(config.OriginalStatusHeader) {
+		return nil, fmt.Errorf("originalStatusHeader: %w %q", ErrInvalidHeaderName, config.OriginalStatusHeader)
+	}
+
+	var routerHeader, routerContextKey string
+	if config.StampRouterHeader {
+		routerHeader = defaultRouterHeader
+		if config.RouterHeaderName != "" {
+			if !validHeaderName(config.RouterHeaderName) {
+				return nil, fmt.Errorf("routerHeaderName: %w %q", ErrInvalidHeaderName, config.RouterHeaderName)
+			}
+			routerHeader = textproto.CanonicalMIMEHeaderKey(config.RouterHeaderName)
+		}
+
+		routerContextKey = defaultRouterContextKey
+		if config.RouterContextKey != "" {
+			routerContextKey = config.RouterContextKey
+		}
+	}
+
+	var auditHeader string
+	if config.AuditInjectedHeader != "" {
+		if !validHeaderName(config.AuditInjectedHeader) {
+			return nil, fmt.Errorf("auditInjectedHeader: %w %q", ErrInvalidHeaderName, config.AuditInjectedHeader)
+		}
+		auditHeader = textproto.CanonicalMIMEHeaderKey(config.AuditInjectedHeader)
+	}
+
+	var requestIDHeader string
+	if config.GenerateRequestID {
+		requestIDHeader = defaultRequestIDHeader
+		if config.RequestIDHeader != "" {
+			if !validHeaderName(config.RequestIDHeader) {
+				return nil, fmt.Errorf("requestIDHeader: %w %q", ErrInvalidHeaderName, config.RequestIDHeader)
+			}
+			requestIDHeader = textproto.CanonicalMIMEHeaderKey(config.RequestIDHeader)
+		}
+	}
+
+	var templateCache *templateCache
+	var templateCacheKey string
+	if config.TemplateCacheSize < 0 {
+		return nil, fmt.Errorf("templateCacheSize: must not be negative, got %d", config.TemplateCacheSize)
+	}
+	if config.TemplateCacheSize > 0 {
+		if config.TemplateCacheKey == "" {
+			return nil, fmt.Errorf("templateCacheSize requires templateCacheKey to be set")
+		}
+		if !validHeaderName(config.TemplateCacheKey) {
+			return nil, fmt.Errorf("templateCacheKey: %w %q", ErrInvalidHeaderName, config.TemplateCacheKey)
+		}
+		templateCache = newTemplateCache(config.TemplateCacheSize)
+		templateCacheKey = textproto.CanonicalMIMEHeaderKey(config.TemplateCacheKey)
+	}
+
+	var fileReloadInterval time.Duration
+	if config.FileReloadInterval != "" {
+		fileReloadInterval, err = time.ParseDuration(config.FileReloadInterval)
+		if err != nil {
+			return nil, fmt.Errorf("fileReloadInterval: %w", err)
+		}
+		if fileReloadInterval <= 0 {
+			return nil, fmt.Errorf("fileReloadInterval: must be positive, got %s", config.FileReloadInterval)
+		}
+		if config.RequestHeadersFile == "" && config.ResponseHeadersFile == "" {
+			return nil, fmt.Errorf("fileReloadInterval requires requestHeadersFile or responseHeadersFile to be set")
+		}
+	}
+
+	var processedHeader string
+	if config.StampProcessedHeader {
+		processedHeader = defaultProcessedHeader
+		if config.ProcessedHeaderName != "" {
+			if !validHeaderName(config.ProcessedHeaderName) {
+				return nil, fmt.Errorf("processedHeaderName: %w %q", ErrInvalidHeaderName, config.ProcessedHeaderName)
+			}
+			processedHeader = textproto.CanonicalMIMEHeaderKey(config.ProcessedHeaderName)
+		}
+	}
+
+	bypassTimeWindows, err := parseTimeWindows(config.BypassTimeWindows)
+	if err != nil {
+		return nil, fmt.Errorf("bypassTimeWindows: %w", err)
+	}
+
+	statusRules, err := compileStatusRules(config.StatusRules)
+	if err != nil {
+		return nil, fmt.Errorf("statusRules: %w", err)
+	}
+
+	// Appended last, so configured rules win
+	if config.RetryAfterOnGatewayErrors < 0 {
+		return nil, fmt.Errorf("retryAfterOnGatewayErrors: must not be negative, got %d", config.RetryAfterOnGatewayErrors)
+	}
+	if config.RetryAfterOnGatewayErrors > 0 {
+		statusRules = append(statusRules, retryAfterRule(config.RetryAfterOnGatewayErrors))
+	}
+
+	excludeStatuses := make(map[int]bool, len(config.ExcludeStatuses))
+	for _, code := range config.ExcludeStatuses {
+		if !validStatus(code) {
+			return nil, fmt.Errorf("excludeStatuses: invalid status code %d", code)
+		}
+		excludeStatuses[code] = true
+	}
+
+	headerDependencies, err := canonicalizeHeaders(config.ResponseHeaderDependencies)
+	if err != nil {
+		return nil, fmt.Errorf("responseHeaderDependencies: %w", err)
+	}
+	for key, dependency := range headerDependencies {
+		if !validHeaderName(dependency) {
+			return nil, fmt.Errorf("responseHeaderDependencies: header %q: %w %q", key, ErrInvalidHeaderName, dependency)
+		}
+		headerDependencies[key] = textproto.CanonicalMIMEHeaderKey(dependency)
+	}
+
+	requestConditions := make(map[string][]headerMatcher, len(config.ResponseHeaderRequestConditions))
+	for key, conditions := range config.ResponseHeaderRequestConditions {
+		if len(conditions) == 0 {
+			return nil, fmt.Errorf("responseHeaderRequestConditions: header %q: no conditions", key)
+		}
+		matchers, err := compileHeaderMatchers(conditions)
+		if err != nil {
+			return nil, fmt.Errorf("responseHeaderRequestConditions: header %q: %w", key, err)
+		}
+		requestConditions[textproto.CanonicalMIMEHeaderKey(key)] = matchers
+	}
+
+	// Headers consulted by conditional rules, advertised in Vary when enabled
+	var varyHeaders []string
+	if config.AutoVary {
+		for _, matcher := range append(bypassHeaders, requireHeaders...) {
+			varyHeaders = append(varyHeaders, matcher.name)
+		}
+		varyHeaders = append(varyHeaders, bypassIfMissing...)
+		if jwtClaim != nil {
+			varyHeaders = append(varyHeaders, jwtClaim.header)
+		}
+		if applyWhen != nil && applyWhen.header != "" {
+			varyHeaders = append(varyHeaders, applyWhen.header)
+		}
+		if hashBuckets != nil && hashBuckets.header != "" {
+			varyHeaders = append(varyHeaders, hashBuckets.header)
+		}
+		varyHeaders = append(varyHeaders, requestConditionHeaders(requestConditions)...)
+	}
+
+	headerRewrites, err := compileHeaderRewrites(config.ResponseHeaderRewrites)
+	if err != nil {
+		return nil, fmt.Errorf("responseHeaderRewrites: %w", err)
+	}
+
+	requestAllowlist, err := compileAllowlist(config.RequestHeaderAllowlist)
+	if err != nil {
+		return nil, fmt.Errorf("requestHeaderAllowlist: %w", err)
+	}
+
+	weightedHeaders, err := compileWeightedHeaders(config.WeightedHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("weightedHeaders: %w", err)
+	}
+
+	contextHeaders, err := compileContextHeaders(config.ContextHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("contextHeaders: %w", err)
+	}
+
+	// Prefixes are matched case-insensitively
+	removePrefixes := make([]string, 0, len(config.RemoveResponseHeaderPrefixes))
+	for _, prefix := range config.RemoveResponseHeaderPrefixes {
+		if prefix == "" {
+			return nil, fmt.Errorf("removeResponseHeaderPrefixes: empty prefix")
+		}
+		removePrefixes = append(removePrefixes, strings.ToLower(prefix))
+	}
+
+	// Patterns are matched case-insensitively as well
+	removePatterns := make([]string, 0, len(config.RemoveResponseHeaderPatterns))
+	for _, pattern := range config.RemoveResponseHeaderPatterns {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, fmt.Errorf("removeResponseHeaderPatterns: invalid pattern %q", pattern)
+		}
+		removePatterns = append(removePatterns, strings.ToLower(pattern))
+	}
+
+	maxHeaderValueAction := config.MaxHeaderValueAction
+	switch maxHeaderValueAction {
+	case "":
+		maxHeaderValueAction = lengthActionReject
+	case lengthActionReject, lengthActionTruncate:
+	default:
+		return nil, fmt.Errorf("maxHeaderValueAction: unknown action %q", maxHeaderValueAction)
+	}
+	bypassMode := config.BypassMode
+	switch bypassMode {
+	case "":
+		bypassMode = bypassModeAny
+	case bypassModeAny, bypassModeAll:
+	default:
+		return nil, fmt.Errorf("bypassMode: unknown mode %q", bypassMode)
+	}
+	bypassScope := config.BypassScope
+	switch bypassScope {
+	case "":
+		bypassScope = bypassScopeAll
+	case bypassScopeAll, bypassScopeRequest, bypassScopeResponse:
+	default:
+		return nil, fmt.Errorf("bypassScope: unknown scope %q", bypassScope)
+	}
+
+	requireScheme := strings.ToLower(config.RequireScheme)
+	if requireScheme != "" && requireScheme != "http" && requireScheme != "https" {
+		return nil, fmt.Errorf("requireScheme: unknown scheme %q", config.RequireScheme)
+	}
+
+	unsafeValueAction := config.UnsafeValueAction
+	switch unsafeValueAction {
+	case "":
+		unsafeValueAction = unsafeValueSkip
+	case unsafeValueSkip, unsafeValueStrip:
+	default:
+		return nil, fmt.Errorf("unsafeValueAction: unknown action %q", unsafeValueAction)
+	}
+
+	bypassOverlapAction := config.BypassOverlapAction
+	switch bypassOverlapAction {
+	case "":
+		bypassOverlapAction = overlapActionWarn
+	case overlapActionWarn, overlapActionError:
+	default:
+		return nil, fmt.Errorf("bypassOverlapAction: unknown action %q", bypassOverlapAction)
+	}
+
+	if config.MaxRequestHeaders < 0 {
+		return nil, fmt.Errorf("maxRequestHeaders: must not be negative, got %d", config.MaxRequestHeaders)
+	}
+
+	if config.MaxHeaderValueLength < 0 {
+		return nil, fmt.Errorf("maxHeaderValueLength: must not be negative, got %d", config.MaxHeaderValueLength)
+	}
+
+	p := &Plugin{
+		name:                   name,
+		next:                   next,
+		disableExplicitFlush:   config.DisableExplicitFlush,
+		strictHeaderCheck:      config.StrictHeaderCheck,
+		bypassHeaders:          bypassHeaders,
+		disableHeader:          config.DisableHeader,
+		emitRequestCount:       config.EmitRequestCount,
+		requireHeaders:         requireHeaders,
+		varyHeaders:            varyHeaders,
+		cloneRequest:           config.CloneRequest,
+		excludeStatuses:        excludeStatuses,
+		cidrLabelHeader:        config.CIDRLabelHeader,
+		cidrLabels:             cidrLabels,
+		enableGzip:             config.EnableGzip,
+		gzipContentTypes:       normalizeContentTypes(config.GzipContentTypes),
+		idempotencyHeaders:     idempotencyHeaders,
+		derivedHeaders:         derivedHeaders,
+		dryRun:                 config.DryRun,
+		upstreamTimeout:        upstreamTimeout,
+		conditionalGetHeaders:  conditionalGetHeaders,
+		queryConditions:        queryConditions,
+		emitFlushMode:          config.EmitFlushMode,
+		applyWhen:              applyWhen,
+		treatEmptyAsMissing:    config.TreatEmptyAsMissing,
+		recordBypassReason:     config.RecordBypassReason,
+		overrideStatusCodes:    overrideStatusCodes,
+		originalStatusHeader:   http.CanonicalHeaderKey(config.OriginalStatusHeader),
+		requireResponseHeaders: requireResponseHeaders,
+		stripServerHeader:      config.StripServerHeader,
+		hashBuckets:            hashBuckets,
+		bypassCIDRs:            bypassCIDRs,
+		trustForwardedFor:      config.TrustForwardedFor,
+		maxHeaderValueLength:   config.MaxHeaderValueLength,
+		maxHeaderValueAction:   maxHeaderValueAction,
+		headerDependencies:     headerDependencies,
+		headerRewrites:         headerRewrites,
+		contextHeaders:         contextHeaders,
+		disableRequestHeaders:  config.DisableRequestHeaders,
+		disableResponseHeaders: config.DisableResponseHeaders,
+		wrapWebSocketUpgrades:  config.WrapWebSocketUpgrades,
+		requestMultiHeaders:    requestMultiHeaders,
+		responseMultiHeaders:   responseMultiHeaders,
+		removePrefixes:         removePrefixes,
+		requestConditions:      requestConditions,
+		unsafeValueAction:      unsafeValueAction,
+		successfulOnly:         config.SuccessfulOnly,
+		bypassMode:             bypassMode,
+		requireScheme:          requireScheme,
+		trustForwardedProto:    config.TrustForwardedProto,
+		maxRequestHeaders:      config.MaxRequestHeaders,
+		recoverPanics:          config.RecoverPanics,
+		repanicAfterRecover:    config.RepanicAfterRecover,
+		statusRules:            statusRules,
+		bypassTimeWindows:      bypassTimeWindows,
+		preserveHeaderCase:     config.PreserveHeaderCase,
+		processedHeader:        processedHeader,
+		jwtClaim:               jwtClaim,
+		dropContentLength:      config.DropContentLength,
+		requestAllowlist:       requestAllowlist,
+		weightedHeaders:        weightedHeaders,
+		rand:                   newLockedRand(defaultRandSource()),
+		warnUnflushable:        config.WarnUnflushableWriter,
+		restoreRequestHeaders:  config.RestoreRequestHeaders,
+		bypassIfMissing:        bypassIfMissing,
+		routerHeader:           routerHeader,
+		routerContextKey:       routerContextKey,
+		manageXFF:              config.ManageXFF,
+		templateCache:          templateCache,
+		templateCacheKey:       templateCacheKey,
+		sizeRules:              sizeRules,
+		stripHopByHop:          config.StripHopByHop,
+		skipResponseMatchers:   skipResponseMatchers,
+		removePatterns:         removePatterns,
+		requestTransforms:      requestTransforms,
+		fallbackHeaders:        fallbackHeaders,
+		skipWhenEncoded:        skipWhenEncoded,
+		requestIDHeader:        requestIDHeader,
+		echoRequestID:          config.GenerateRequestID && config.EchoRequestID,
+		auditHeader:            auditHeader,
+		bypassScope:            bypassScope,
+	}
+
+	// The checks below read the header files through p.files, nothing is serving requests yet
+	p.files.Store(files)
+	if err := p.limitConfiguredValues(); err != nil {
+		return nil, fmt.Errorf("maxHeaderValueLength: %w", err)
+	}
+	p.readsHeaders = p.templatesReadHeaders()
+	files.readsHeaders = p.readsHeaders
+
+	if err := p.checkBypassOverlaps(bypassOverlapAction); err != nil {
+		return nil, fmt.Errorf("bypassHeaders: %w", err)
+	}
+
+	p.resolveEffectiveConfig(config)
+	p.effectiveConfig.BypassOverlapAction = bypassOverlapAction
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if fileReloadInterval > 0 {
+		go p.watchHeaderFiles(ctx, copyConfig(config), fileReloadInterval)
+	}
+
+	return p, nil
 }
 
 // ServeHTTP implements the http.Handler interface.
+//
+// Every request is evaluated in the same order, each step only running when the previous
+// ones let the request through:
+//
+//  1. A valid disable header passes the request through untouched.
+//  2. Requests with too many headers are rejected.
+//  3. Bypass headers, bypass networks and bypass time windows skip the phases selected by
+//     bypassScope, both by default. Required headers, applyWhen and jwtClaim always pass
+//     the request through untouched.
+//  4. Query conditions and the hash bucket are matched once for both phases.
+//  5. Request phase: missing request headers are added, see modifyRequest.
+//  6. Response phase: the response is wrapped and its headers modified when the upstream
+//     writes them, see responseModifier.WriteHeader.
+//
+// Headers are only added when missing, so the first source setting a header wins.
 func (p *Plugin) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	// Check if we should bypass the middleware
-	if p.shouldBypass(req) {
+	count := atomic.AddUint64(&p.requestCount, 1)
+
+	// 1. Check if the plugin has been disabled for this request
+	if p.isDisabled(req) {
 		p.next.ServeHTTP(rw, req)
 		return
 	}
 
-	// Add missing request headers
-	p.addMissingHeaders(req.Header, p.requestHeaders)
+	// 2. Reject header flooding before anything else looks at the headers
+	if p.maxRequestHeaders > 0 && len(req.Header) > p.maxRequestHeaders && !p.dryRun {
+		http.Error(rw, http.StatusText(http.StatusRequestHeaderFieldsTooLarge), http.StatusRequestHeaderFieldsTooLarge)
+		return
+	}
+
+	// 3. Check if we should bypass the middleware (bypass wins over requirements).
+	// Only a bypassScope of all stops here, the other scopes skip a single phase below.
+	bypassed := false
+	if reason, ok := p.matchBypass(req); ok {
+		if p.recordBypassReason {
+			req = withBypassReason(req, reason)
+		}
+		bypassed = true
+	} else {
+		bypassed = p.bypassedByIP(req) || p.bypassedByTime()
+	}
+
+	if (bypassed && p.bypassScope == bypassScopeAll) || !p.meetsRequirements(req) || !p.applies(req) || !p.hasJWTClaim(req) {
+		p.passThrough(rw, req)
+		return
+	}
+
+	// 4. Match the conditions shared by both phases
+	state := &requestState{
+		queryConditions: p.matchQueryConditions(req),
+		captures:        p.requirementCaptures(req),
+	}
+	if p.hashBuckets != nil {
+		state.bucket = p.hashBuckets.pick(req)
+	}
+
+	// 5. Request phase
+	var dryRunHeaders, injected []string
+	if !p.disableRequestHeaders && !(bypassed && p.bypassScope == bypassScopeRequest) {
+		// Work on a deep copy so the caller's request is never mutated
+		if p.cloneRequest {
+			req = req.Clone(req.Context())
+		} else if p.restoreRequestHeaders && !p.dryRun {
+			// Middlewares in front see the original headers again once next returns
+			defer restoreHeader(req.Header, req.Header.Clone())
+		}
+
+		// In dry-run mode, only record the headers that would change
+		if p.dryRun {
+			shadow := *req
+			shadow.Header = req.Header.Clone()
+			p.modifyRequest(&shadow, state)
+			dryRunHeaders = changedHeaders(req.Header, shadow.Header)
+		} else {
+			// Snapshot the headers to audit what the plugin injects, before the hook runs
+			var before http.Header
+			if p.auditHeader != "" {
+				before = req.Header.Clone()
+			}
+			p.modifyRequest(req, state)
+			if before != nil {
+				injected = injectedHeaders(before, req.Header)
+			}
+			if p.requestHook != nil {
+				p.requestHook(req)
+			}
+		}
+	}
+
+	// Enforce a deadline on the next handler
+	if p.upstreamTimeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), p.upstreamTimeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	// 6. Response phase, responses to WebSocket upgrades are not wrapped, headers after the 101 are meaningless
+	if !p.wrapWebSocketUpgrades && isWebSocketUpgrade(req) {
+		p.next.ServeHTTP(rw, req)
+		return
+	}
+	if p.disableResponseHeaders || (bypassed && p.bypassScope == bypassScopeResponse) {
+		p.passThrough(rw, req)
+		return
+	}
+
+	// Expose the per-instance request counter
+	if p.emitRequestCount {
+		if p.dryRun {
+			dryRunHeaders = append(dryRunHeaders, requestCountHeader)
+		} else {
+			rw.Header().Set(requestCountHeader, strconv.FormatUint(count, 10))
+		}
+	}
 
 	// If no response headers to add, pass through directly
-	if len(p.responseHeaders) == 0 {
+	responseHeaders := p.responseHeadersFor(req, state)
+	if !p.needsResponseModifier(responseHeaders) {
 		p.next.ServeHTTP(rw, req)
 		return
 	}
 
 	// Use response modifier to add missing response headers
-	p.next.ServeHTTP(newResponseModifier(p.responseHeaders, p.disableExplicitFlush, p.strictHeaderCheck, rw), req)
+	rm := newResponseModifier(p, req, responseHeaders, rw)
+	rm.dryRunHeaders = dryRunHeaders
+	rm.captures = state.captures
+	rm.injected = injected
+	p.serveNext(rm, req)
+
+	// Answer with a gateway timeout if the deadline expired before anything was written
+	if p.upstreamTimeout > 0 && errors.Is(req.Context().Err(), context.DeadlineExceeded) && !rm.headersSent && !rm.hijacked {
+		rm.WriteHeader(http.StatusGatewayTimeout)
+	}
+
+	rm.finish()
+	rm.release()
+}
+
+// passThrough forwards the request without modifying the response, except for Vary: when autoVary
+// is enabled, bypassed and gated-out responses depend on the consulted request headers too.
+func (p *Plugin) passThrough(rw http.ResponseWriter, req *http.Request) {
+	if len(p.varyHeaders) == 0 {
+		p.next.ServeHTTP(rw, req)
+		return
+	}
+
+	rm := newResponseModifier(p, req, nil, rw)
+	rm.varyOnly = true
+	p.next.ServeHTTP(rm, req)
+
+	// Handlers that return without writing anything still get Vary
+	if !rm.headersSent && !rm.hijacked {
+		rm.WriteHeader(rm.code)
+	}
+	rm.release()
+}
+
+// needsResponseModifier reports whether the response must be wrapped for the given response headers.
+func (p *Plugin) needsResponseModifier(responseHeaders []headerEntry) bool {
+	return len(responseHeaders) > 0 ||
+		len(p.derivedHeaders) > 0 ||
+		len(p.varyHeaders) > 0 ||
+		p.enableGzip ||
+		p.dryRun ||
+		p.upstreamTimeout > 0 ||
+		len(p.responseMultiHeaders) > 0 ||
+		p.emitFlushMode ||
+		len(p.overrideStatusCodes) > 0 ||
+		len(p.requireResponseHeaders) > 0 ||
+		p.stripServerHeader ||
+		p.dropContentLength ||
+		len(p.headerRewrites) > 0 ||
+		p.responseHook != nil ||
+		len(p.removePrefixes) > 0 ||
+		len(p.removePatterns) > 0 ||
+		len(p.statusRules) > 0 ||
+		p.processedHeader != "" ||
+		p.routerHeader != "" ||
+		p.echoRequestID ||
+		p.auditHeader != "" ||
+		p.recoverPanics
+}
+
+// modifyRequest applies all request header modifications: the allowlist, the client network
+// label, then missing headers from the most to the least specific source.
+func (p *Plugin) modifyRequest(req *http.Request, state *requestState) {
+	// Connection-level headers never reach the upstream, nor are read by templates
+	if p.stripHopByHop {
+		stripHopByHop(req.Header)
+	}
+
+	// Filter first, so that templates can't read dropped headers and configured ones are kept
+	if p.requestAllowlist != nil {
+		p.filterRequestHeaders(req.Header)
+	}
+
+	// Client values are normalized before templates read them and headers are added
+	p.transformRequestHeaders(req.Header)
+
+	// Generated before the template snapshot, so templates can read the ID
+	if p.requestIDHeader != "" {
+		p.addRequestID(req.Header)
+	}
+
+	data := p.newTemplateData(req, req.Header)
+	data.captures = state.captures
+
+	// Label the request with the matching client network
+	if len(p.cidrLabels) > 0 {
+		p.setCIDRLabel(req)
+	}
+
+	// The chain is extended after the client IP was read from it, whatever the checking mode
+	if p.manageXFF {
+		appendForwardedFor(req)
+	}
+
+	// Fallback sources are read before any header is added, only client values are considered
+	if len(p.fallbackHeaders) > 0 {
+		p.addFallbackHeaders(req.Header)
+	}
+
+	// Add missing request headers from matched query conditions first, they are more specific
+	for _, c := range state.queryConditions {
+		p.addMissingHeaders(req.Header, c.requestHeaders, data)
+	}
+	if state.bucket != nil {
+		p.addMissingHeaders(req.Header, state.bucket.requestHeaders, data)
+	}
+	if len(p.sizeRules) > 0 {
+		p.addSizeHeaders(req, data)
+	}
+
+	// Values fed by earlier middlewares are more specific than static headers
+	if len(p.contextHeaders) > 0 {
+		p.addContextHeaders(req)
+	}
+
+	// Weighted values win over static ones, so a variant isn't pinned by requestHeaders
+	if len(p.weightedHeaders) > 0 {
+		p.addWeightedHeaders(req.Header)
+	}
+
+	// Add missing request headers, multi-value ones first so they win
+	p.addMissingHeaders(req.Header, p.requestMultiHeaders, data)
+	p.addMissingHeaders(req.Header, p.currentFileHeaders().requestHeaders, data)
+
+	// Add missing request headers for idempotent requests
+	if req.Header.Values(idempotencyKeyHeader) != nil {
+		p.addMissingHeaders(req.Header, p.idempotencyHeaders, data)
+	}
+}
+
+// restoreHeader replaces the content of header with snapshot, keeping the same map
+// so that every request sharing it sees the restored headers.
+func restoreHeader(header, snapshot http.Header) {
+	for key := range header {
+		delete(header, key)
+	}
+	for key, values := range snapshot {
+		header[key] = values
+	}
+}
+
+// changedHeaders returns the sorted names of headers that differ between before and after.
+func changedHeaders(before, after http.Header) []string {
+	var changed []string
+	for key, values := range after {
+		if previous, ok := before[key]; !ok || !equalValues(previous, values) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// equalValues reports whether two header value lists are identical.
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// responseHeadersFor returns the response headers for the request's host,
+// falling back to the default response headers when no host matches.
+// Conditional headers come first, so they win over the others.
+func (p *Plugin) responseHeadersFor(req *http.Request, state *requestState) []headerEntry {
+	files := p.currentFileHeaders()
+	headers := files.responseHeaders
+	if files.hostHeaders != nil {
+		if hostHeaders, ok := files.hostHeaders.lookup(req.Host); ok {
+			headers = hostHeaders
+		}
+	}
+	headers = withMultiHeaders(p.responseMultiHeaders, headers)
+
+	var conditional []headerEntry
+	for _, c := range state.queryConditions {
+		conditional = append(conditional, c.responseHeaders...)
+	}
+	if state.bucket != nil {
+		conditional = append(conditional, state.bucket.responseHeaders...)
+	}
+	if len(p.conditionalGetHeaders) > 0 && isConditionalGet(req) {
+		conditional = append(conditional, p.conditionalGetHeaders...)
+	}
+	if len(conditional) > 0 {
+		headers = append(conditional, headers...)
+	}
+
+	return headers
+}
+
+// isWebSocketUpgrade reports whether the request asks to switch to the WebSocket protocol.
+func isWebSocketUpgrade(req *http.Request) bool {
+	return headerHasToken(req.Header, "Connection", "upgrade") && headerHasToken(req.Header, "Upgrade", "websocket")
+}
+
+// headerHasToken reports whether a comma-separated header lists token, ignoring case.
+func headerHasToken(header http.Header, key, token string) bool {
+	for _, value := range header.Values(key) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isConditionalGet reports whether the request is a GET or HEAD carrying a cache validator.
+func isConditionalGet(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return req.Header.Values("If-None-Match") != nil || req.Header.Values("If-Modified-Since") != nil
 }
 
-// shouldAddHeader determines if a header should be added based on the strict check setting.
-func shouldAddHeader(header http.Header, key string, strictCheck bool) bool {
-	if strictCheck {
+// shouldAddHeader determines if a header should be added based on the header check settings.
+func (p *Plugin) shouldAddHeader(header http.Header, key string) bool {
+	if p.strictHeaderCheck {
+		if p.treatEmptyAsMissing {
+			// Strict, filling empty: add if header doesn't exist or only has empty values
+			return !hasNonEmptyValue(header.Values(key))
+		}
 		// Strict: only add if header doesn't exist at all
 		return header.Values(key) == nil
 	}
@@ -98,115 +1143,215 @@ func shouldAddHeader(header http.Header, key string, strictCheck bool) bool {
 	return header.Get(key) == ""
 }
 
-// shouldBypass determines if the middleware should be bypassed based on request headers.
-func (p *Plugin) shouldBypass(req *http.Request) bool {
-	for headerName, expectedValue := range p.bypassHeaders {
-		actualValue := req.Header.Get(headerName)
-
-		// If expectedValue is empty, bypass if header exists with any value
-		if expectedValue == "" && req.Header.Values(headerName) != nil {
+// hasNonEmptyValue reports whether any of the values is not empty.
+func hasNonEmptyValue(values []string) bool {
+	for _, value := range values {
+		if value != "" {
 			return true
 		}
+	}
+	return false
+}
 
-		// If expectedValue is not empty, check for exact match
-		if expectedValue != "" && actualValue == expectedValue {
-			return true
+// requirementCaptures returns the submatches of the first regular expression in requireHeaders.
+func (p *Plugin) requirementCaptures(req *http.Request) []string {
+	for _, matcher := range p.requireHeaders {
+		if matcher.regex != nil {
+			return matcher.captures(req.Header)
 		}
 	}
-	return false
+	return nil
 }
 
-// addMissingHeaders adds headers to the target header map if they don't already exist.
-func (p *Plugin) addMissingHeaders(target http.Header, headers map[string]string) {
-	for key, value := range headers {
-		if shouldAddHeader(target, key, p.strictHeaderCheck) {
-			target.Set(key, value)
+// meetsRequirements reports whether all required headers are present or matched.
+func (p *Plugin) meetsRequirements(req *http.Request) bool {
+	for _, matcher := range p.requireHeaders {
+		if !matcher.matches(req.Header) {
+			return false
 		}
 	}
+	return true
 }
 
-// responseModifier wraps http.ResponseWriter to add missing response headers.
-type responseModifier struct {
-	rw                   http.ResponseWriter
-	flusher              http.Flusher
-	responseHeaders      map[string]string
-	disableExplicitFlush bool
-	strictHeaderCheck    bool
-	headersSent          bool
-	code                 int
+// applies reports whether the request matches the applyWhen conditions.
+func (p *Plugin) applies(req *http.Request) bool {
+	return p.applyWhen == nil || p.applyWhen.matches(req)
 }
 
-// newResponseModifier creates a new response modifier.
-func newResponseModifier(responseHeaders map[string]string, disableExplicitFlush bool, strictHeaderCheck bool, w http.ResponseWriter) http.ResponseWriter {
-	rm := &responseModifier{
-		rw:                   w,
-		code:                 http.StatusOK,
-		responseHeaders:      responseHeaders,
-		disableExplicitFlush: disableExplicitFlush,
-		strictHeaderCheck:    strictHeaderCheck,
+// isDisabled reports whether the request carries a validly signed disable header.
+func (p *Plugin) isDisabled(req *http.Request) bool {
+	if p.disableHeader.Name == "" {
+		return false
 	}
 
-	// Check if the underlying ResponseWriter supports flushing
-	if f, ok := w.(http.Flusher); ok {
-		rm.flusher = f
+	value := req.Header.Get(p.disableHeader.Name)
+	nonce, signature, found := strings.Cut(value, ":")
+	if !found || nonce == "" {
+		return false
 	}
 
-	return rm
+	actual, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(p.disableHeader.Secret))
+	mac.Write([]byte(nonce))
+
+	// Constant-time comparison to avoid leaking the expected signature
+	return hmac.Equal(actual, mac.Sum(nil))
 }
 
-// Header returns the header map that will be sent by WriteHeader.
-func (r *responseModifier) Header() http.Header {
-	return r.rw.Header()
+// headerEntry is a configured header with its canonical name.
+// Values containing "{{" are templates rendered for every request.
+// Multi-value headers have values instead of value, each added as a separate line.
+type headerEntry struct {
+	key string
+	// name is the header name as configured, used with preserveHeaderCase.
+	name   string
+	value  string
+	tmpl   *valueTemplate
+	values []string
 }
 
-// WriteHeader sends an HTTP response header with the provided status code.
-func (r *responseModifier) WriteHeader(code int) {
-	if r.headersSent {
-		return
+// render returns the header value, rendering it when it is a template.
+// The boolean is false when the header should be skipped.
+func (e *headerEntry) render(data *templateData) (string, bool) {
+	if e.tmpl == nil {
+		return e.value, true
 	}
 
-	r.addMissingResponseHeaders()
-	r.rw.WriteHeader(code)
+	value, err := e.tmpl.render(data)
+	if err != nil || value == "" {
+		return "", false
+	}
+	return value, true
+}
 
-	r.code = code
-	r.headersSent = true
+// canonicalizeHeaders returns a copy of headers keyed by canonical header names.
+// Two distinct keys with the same canonical form are reported as an error, since
+// one would otherwise silently overwrite the other.
+func canonicalizeHeaders(headers map[string]string) (map[string]string, error) {
+	canonical := make(map[string]string, len(headers))
+	seen := make(map[string]string, len(headers))
+	for key, value := range headers {
+		canonicalKey, err := canonicalHeaderName(seen, key)
+		if err != nil {
+			return nil, err
+		}
+		canonical[canonicalKey] = value
+	}
+	return canonical, nil
 }
 
-// addMissingResponseHeaders adds missing headers to the response.
-func (r *responseModifier) addMissingResponseHeaders() {
-	for key, value := range r.responseHeaders {
-		if shouldAddHeader(r.rw.Header(), key, r.strictHeaderCheck) {
-			r.rw.Header().Set(key, value)
+// canonicalHeaderName validates key and returns its canonical form. seen maps the canonical
+// names found so far to their configured spelling, so that distinct keys referring to the
+// same header are reported as ErrHeaderNameCollision.
+func canonicalHeaderName(seen map[string]string, key string) (string, error) {
+	if !validHeaderName(key) {
+		return "", fmt.Errorf("%w %q", ErrInvalidHeaderName, key)
+	}
+	canonicalKey := textproto.CanonicalMIMEHeaderKey(key)
+	if other, ok := seen[canonicalKey]; ok {
+		// Report the keys in a stable order
+		if other > key {
+			other, key = key, other
 		}
+		return "", fmt.Errorf("%w: headers %q and %q refer to the same header %q", ErrHeaderNameCollision, other, key, canonicalKey)
 	}
+	seen[canonicalKey] = key
+	return canonicalKey, nil
 }
 
-// Write writes the data to the connection as part of an HTTP reply.
-func (r *responseModifier) Write(b []byte) (int, error) {
-	r.WriteHeader(r.code)
+// mergeHeaders returns base overlaid with overrides, comparing canonical header names.
+func mergeHeaders(base, overrides map[string]string) map[string]string {
+	overridden := make(map[string]bool, len(overrides))
+	for key := range overrides {
+		overridden[textproto.CanonicalMIMEHeaderKey(key)] = true
+	}
+
+	merged := make(map[string]string, len(base)+len(overrides))
+	for key, value := range base {
+		if !overridden[textproto.CanonicalMIMEHeaderKey(key)] {
+			merged[key] = value
+		}
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+
+	return merged
+}
+
+// compileHeaders converts a header map into a slice sorted by canonical name,
+// so headers are always applied in a deterministic order. Base64-encoded values are decoded.
+func compileHeaders(headers map[string]string) ([]headerEntry, error) {
+	// Only checks for duplicates, entries keep the configured names
+	if _, err := canonicalizeHeaders(headers); err != nil {
+		return nil, err
+	}
 
-	n, err := r.rw.Write(b)
+	entries := make([]headerEntry, 0, len(headers))
+	for name, value := range headers {
+		key := textproto.CanonicalMIMEHeaderKey(name)
+		value, encoded, err := decodeValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("header %q: %w", key, err)
+		}
+		entry := headerEntry{key: key, name: name, value: value}
+		// Decoded values are taken literally
+		if !encoded && isTemplate(value) {
+			tmpl, err := compileValueTemplate(key, value)
+			if err != nil {
+				return nil, fmt.Errorf("header %q: invalid template: %w", key, err)
+			}
+			entry.tmpl = tmpl
+		}
 
-	// Explicitly flush after write if enabled and supported
-	if !r.disableExplicitFlush && r.flusher != nil {
-		r.flusher.Flush()
+		entries = append(entries, entry)
 	}
 
-	return n, err
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	return entries, nil
 }
 
-// Hijack hijacks the connection if the underlying ResponseWriter supports hijacking.
-func (r *responseModifier) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	hijacker, ok := r.rw.(http.Hijacker)
-	if !ok {
-		return nil, nil, fmt.Errorf("responseWriter does not support hijacking: %T", r.rw)
+// setCIDRLabel sets the label header to the first network matching the client IP.
+// The header is always overwritten, or removed when no network matches, so clients can't spoof it.
+func (p *Plugin) setCIDRLabel(req *http.Request) {
+	if label, ok := matchLabel(p.cidrLabels, p.clientIP(req)); ok {
+		req.Header.Set(p.cidrLabelHeader, label)
+		return
 	}
-	return hijacker.Hijack()
+	req.Header.Del(p.cidrLabelHeader)
 }
 
-// Flush sends any buffered data to the client if flushing is supported.
-func (r *responseModifier) Flush() {
-	if r.flusher != nil {
-		r.flusher.Flush()
+// addMissingHeaders adds headers to the target header map if they don't already exist,
+// rendering templates with data.
+func (p *Plugin) addMissingHeaders(target http.Header, headers []headerEntry, data *templateData) {
+	for i := range headers {
+		entry := &headers[i]
+		if p.preservesCase(entry) {
+			p.addMissingRawHeader(target, entry, data)
+			continue
+		}
+		if !p.shouldAddHeader(target, entry.key) {
+			continue
+		}
+		if entry.values != nil {
+			for _, value := range entry.values {
+				if value, ok := p.checkValue(entry.key, value); ok {
+					target.Add(entry.key, value)
+				}
+			}
+			continue
+		}
+		value, ok := entry.render(data)
+		if !ok {
+			continue
+		}
+		if value, ok = p.checkValue(entry.key, value); ok {
+			target.Set(entry.key, value)
+		}
 	}
 }
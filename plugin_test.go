@@ -15,14 +15,99 @@
 package add_missing_headers_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
 )
 
+func TestConfig_JSON(t *testing.T) {
+	blob := `{
+		"requestHeaders": {"X-Forwarded-Proto": "https"},
+		"responseHeaders": {"X-Frame-Options": "DENY"},
+		"strictHeaderCheck": true,
+		"bypassHeaders": {"X-Skip": ""},
+		"disableHeader": {"name": "X-Disable", "secret": "secret"},
+		"excludeStatuses": [101],
+		"hostHeaders": {"*.example.com": {"X-Host": "wildcard"}},
+		"upstreamTimeout": "5s",
+		"queryConditions": [{"param": "debug", "responseHeaders": {"X-Debug": "1"}}],
+		"hashBuckets": {"header": "X-User", "buckets": [{"weight": 10, "responseHeaders": {"X-Bucket": "a"}}]},
+		"responseHeaderRewrites": {"Location": {"pattern": "^http:", "replacement": "https:"}},
+		"requestHeadersMulti": {"X-Multi": ["a", "b"]},
+		"maxHeaderValueLength": 4096
+	}`
+
+	var cfg add_missing_headers.Config
+	if err := json.Unmarshal([]byte(blob), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.RequestHeaders["X-Forwarded-Proto"] != "https" || cfg.ResponseHeaders["X-Frame-Options"] != "DENY" {
+		t.Errorf("Expected request and response headers, got %v and %v", cfg.RequestHeaders, cfg.ResponseHeaders)
+	}
+	if !cfg.StrictHeaderCheck || cfg.MaxHeaderValueLength != 4096 || cfg.UpstreamTimeout != "5s" {
+		t.Errorf("Expected scalar options to be set, got %+v", cfg)
+	}
+	if _, ok := cfg.BypassHeaders["X-Skip"]; !ok {
+		t.Errorf("Expected bypass header X-Skip, got %v", cfg.BypassHeaders)
+	}
+	if cfg.DisableHeader.Name != "X-Disable" || cfg.DisableHeader.Secret != "secret" {
+		t.Errorf("Expected the disable header, got %+v", cfg.DisableHeader)
+	}
+	if !reflect.DeepEqual(cfg.ExcludeStatuses, []int{101}) {
+		t.Errorf("Expected excluded statuses [101], got %v", cfg.ExcludeStatuses)
+	}
+	if cfg.HostHeaders["*.example.com"]["X-Host"] != "wildcard" {
+		t.Errorf("Expected host headers, got %v", cfg.HostHeaders)
+	}
+	if len(cfg.QueryConditions) != 1 || cfg.QueryConditions[0].Param != "debug" || cfg.QueryConditions[0].ResponseHeaders["X-Debug"] != "1" {
+		t.Errorf("Expected a query condition, got %+v", cfg.QueryConditions)
+	}
+	if cfg.HashBuckets.Header != "X-User" || len(cfg.HashBuckets.Buckets) != 1 || cfg.HashBuckets.Buckets[0].Weight != 10 {
+		t.Errorf("Expected hash buckets, got %+v", cfg.HashBuckets)
+	}
+	if cfg.ResponseHeaderRewrites["Location"].Pattern != "^http:" || cfg.ResponseHeaderRewrites["Location"].Replacement != "https:" {
+		t.Errorf("Expected a rewrite, got %+v", cfg.ResponseHeaderRewrites)
+	}
+	if !reflect.DeepEqual(cfg.RequestHeadersMulti["X-Multi"], []string{"a", "b"}) {
+		t.Errorf("Expected multi-value headers, got %v", cfg.RequestHeadersMulti)
+	}
+
+	// The struct round-trips through encoding/json
+	encoded, err := json.Marshal(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded add_missing_headers.Config
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, decoded) {
+		t.Errorf("Expected the config to round-trip, got %+v", decoded)
+	}
+}
+
 func TestRequestHeaders_StrictMode(t *testing.T) {
 	cfg := add_missing_headers.CreateConfig()
 	cfg.StrictHeaderCheck = true
@@ -56,6 +141,46 @@ func TestRequestHeaders_StrictMode(t *testing.T) {
 	handler.ServeHTTP(recorder, req)
 }
 
+func TestTreatEmptyAsMissing(t *testing.T) {
+	testCases := []struct {
+		name     string
+		values   []string
+		expected []string
+	}{
+		{"Absent", nil, []string{"configured"}},
+		{"Present empty", []string{""}, []string{"configured"}},
+		{"Present empty twice", []string{"", ""}, []string{"configured"}},
+		{"Present non-empty", []string{"existing"}, []string{"existing"}},
+		{"Empty then non-empty", []string{"", "existing"}, []string{"", "existing"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.StrictHeaderCheck = true
+			cfg.TreatEmptyAsMissing = true
+			cfg.RequestHeaders["X-Test"] = "configured"
+
+			var values []string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				values = req.Header.Values("X-Test")
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			for _, value := range tc.values {
+				req.Header.Add("X-Test", value)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			if !reflect.DeepEqual(values, tc.expected) {
+				t.Errorf("Expected X-Test values %q, got %q", tc.expected, values)
+			}
+		})
+	}
+}
+
 func TestRequestHeaders_LooseMode(t *testing.T) {
 	cfg := add_missing_headers.CreateConfig()
 	cfg.StrictHeaderCheck = false
@@ -207,6 +332,15 @@ func TestDefaultConfig(t *testing.T) {
 	if len(cfg.BypassHeaders) != 0 {
 		t.Error("Expected BypassHeaders to be empty by default")
 	}
+	if len(cfg.RequireHeaders) != 0 {
+		t.Error("Expected RequireHeaders to be empty by default")
+	}
+	if len(cfg.IdempotencyHeaders) != 0 {
+		t.Error("Expected IdempotencyHeaders to be empty by default")
+	}
+	if len(cfg.ConditionalGetHeaders) != 0 {
+		t.Error("Expected ConditionalGetHeaders to be empty by default")
+	}
 }
 
 func TestBypassHeaders_HeaderPresence(t *testing.T) {
@@ -319,6 +453,199 @@ func TestBypassHeaders_NoBypass(t *testing.T) {
 	assertResponseHeader(t, recorder, "X-Response-Header", "response-value")
 }
 
+func TestBypassMode_All(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Test-Header"] = "test-value"
+	cfg.BypassHeaders["X-Internal"] = "1" // Value check
+	cfg.BypassHeaders["X-Probe"] = ""     // Presence check
+	cfg.BypassMode = "all"
+
+	testCases := []struct {
+		name         string
+		headers      map[string]string
+		shouldBypass bool
+	}{
+		{"Both match", map[string]string{"X-Internal": "1", "X-Probe": "yes"}, true},
+		{"Presence with empty value", map[string]string{"X-Internal": "1", "X-Probe": ""}, true},
+		{"Only value header", map[string]string{"X-Internal": "1"}, false},
+		{"Only presence header", map[string]string{"X-Probe": "yes"}, false},
+		{"Wrong value", map[string]string{"X-Internal": "0", "X-Probe": "yes"}, false},
+		{"No headers", map[string]string{}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				expected := "test-value"
+				if tc.shouldBypass {
+					expected = ""
+				}
+				assertHeader(t, req, "X-Test-Header", expected)
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			for key, value := range tc.headers {
+				req.Header.Set(key, value)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+		})
+	}
+}
+
+func TestBypassIfMissing(t *testing.T) {
+	testCases := []struct {
+		name         string
+		mode         string
+		headers      map[string]string
+		shouldBypass bool
+	}{
+		{"Any: header present", "any", map[string]string{"X-Gateway-Verified": "1"}, false},
+		{"Any: header present and empty", "any", map[string]string{"X-Gateway-Verified": ""}, false},
+		{"Any: header missing", "any", map[string]string{}, true},
+		{"Any: bypass header matches", "any", map[string]string{"X-Gateway-Verified": "1", "X-Skip": "1"}, true},
+		{"All: missing and bypass header matches", "all", map[string]string{"X-Skip": "1"}, true},
+		{"All: only missing", "all", map[string]string{}, false},
+		{"All: only bypass header matches", "all", map[string]string{"X-Gateway-Verified": "1", "X-Skip": "1"}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeaders["X-Test-Header"] = "test-value"
+			cfg.BypassHeaders["X-Skip"] = "1"
+			cfg.BypassIfMissing = []string{"x-gateway-verified"}
+			cfg.BypassMode = tc.mode
+			cfg.RecordBypassReason = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				expected := "test-value"
+				if tc.shouldBypass {
+					expected = ""
+				}
+				assertHeader(t, req, "X-Test-Header", expected)
+
+				_, recorded := req.Context().Value(add_missing_headers.BypassReasonKey).(add_missing_headers.BypassReason)
+				if recorded != tc.shouldBypass {
+					t.Errorf("Expected a recorded bypass reason: %t, got %t", tc.shouldBypass, recorded)
+				}
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			for key, value := range tc.headers {
+				req.Header.Set(key, value)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+		})
+	}
+}
+
+func TestBypassScope(t *testing.T) {
+	testCases := []struct {
+		scope            string
+		bypass           bool
+		expectedRequest  string
+		expectedResponse string
+	}{
+		{"", true, "", ""},
+		{"all", true, "", ""},
+		{"request", true, "", "response-value"},
+		{"response", true, "request-value", ""},
+		{"request", false, "request-value", "response-value"},
+		{"response", false, "request-value", "response-value"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s/bypass=%t", tc.scope, tc.bypass), func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeaders["X-Request"] = "request-value"
+			cfg.ResponseHeaders["X-Response"] = "response-value"
+			cfg.BypassHeaders["X-Skip"] = "true"
+			cfg.BypassScope = tc.scope
+
+			var req *http.Request
+			next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				req = r
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			if tc.bypass {
+				r.Header.Set("X-Skip", "true")
+			}
+
+			recorder := httptest.NewRecorder()
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, r)
+
+			assertHeader(t, req, "X-Request", tc.expectedRequest)
+			assertResponseHeader(t, recorder, "X-Response", tc.expectedResponse)
+		})
+	}
+}
+
+func TestBypassScope_CIDR(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Request"] = "request-value"
+	cfg.ResponseHeaders["X-Response"] = "response-value"
+	cfg.BypassCIDRs = []string{"192.0.2.0/24"}
+	cfg.BypassScope = "request"
+
+	var req *http.Request
+	next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		req = r
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	recorder := httptest.NewRecorder()
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, r)
+
+	assertHeader(t, req, "X-Request", "")
+	assertResponseHeader(t, recorder, "X-Response", "response-value")
+}
+
+func TestBypassScope_Invalid(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.BypassScope = "both"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+		t.Error("Expected an error for an unknown bypass scope")
+	}
+}
+
+func TestBypassIfMissing_Reason(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.BypassIfMissing = []string{"x-gateway-verified"}
+	cfg.RecordBypassReason = true
+
+	var reason add_missing_headers.BypassReason
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		reason, _ = req.Context().Value(add_missing_headers.BypassReasonKey).(add_missing_headers.BypassReason)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+
+	expected := add_missing_headers.BypassReason{Header: "X-Gateway-Verified"}
+	if reason != expected {
+		t.Errorf("Expected reason %+v, got %+v", expected, reason)
+	}
+}
+
+func TestBypassMode_Unknown(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.BypassMode = "some"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+		t.Error("Expected an error for an unknown bypass mode")
+	}
+}
+
 func TestBypassHeaders_MultipleBypassConditions(t *testing.T) {
 	cfg := add_missing_headers.CreateConfig()
 	cfg.RequestHeaders["X-Test-Header"] = "test-value"
@@ -392,96 +719,106 @@ func TestBypassHeaders_MultipleBypassConditions(t *testing.T) {
 	}
 }
 
-func TestFlushingBehavior(t *testing.T) {
-	cfg := add_missing_headers.CreateConfig()
-	cfg.DisableExplicitFlush = true
-	cfg.ResponseHeaders["X-Test"] = "test"
-
-	ctx := context.Background()
-	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		rw.WriteHeader(http.StatusOK)
-		_, _ = rw.Write([]byte("test"))
-	})
-
-	handler, err := add_missing_headers.New(ctx, next, cfg, "test-plugin")
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	recorder := httptest.NewRecorder()
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	handler.ServeHTTP(recorder, req)
-
-	// Should work normally even with flushing disabled
-	if recorder.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", recorder.Code)
-	}
-	assertResponseHeader(t, recorder, "X-Test", "test")
-}
-
-func TestDefaultStatusCodeBehavior(t *testing.T) {
-	cfg := add_missing_headers.CreateConfig()
-	cfg.ResponseHeaders["X-Custom-Header"] = "custom-value"
-
-	ctx := context.Background()
-	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		// Only call Write() without explicit WriteHeader() - should default to 200 OK
-		_, _ = rw.Write([]byte("response without explicit status"))
-	})
-
-	handler, err := add_missing_headers.New(ctx, next, cfg, "test-plugin")
-	if err != nil {
-		t.Fatal(err)
+func TestRecordBypassReason(t *testing.T) {
+	testCases := []struct {
+		name     string
+		record   bool
+		headers  map[string]string
+		expected *add_missing_headers.BypassReason
+	}{
+		{
+			name:     "Bypassed",
+			record:   true,
+			headers:  map[string]string{"X-Skip-Processing": "true"},
+			expected: &add_missing_headers.BypassReason{Header: "X-Skip-Processing", Value: "true"},
+		},
+		{
+			name:    "Not bypassed",
+			record:  true,
+			headers: map[string]string{"X-Skip-Processing": "false"},
+		},
+		{
+			name:    "Recording disabled",
+			headers: map[string]string{"X-Skip-Processing": "true"},
+		},
 	}
 
-	recorder := httptest.NewRecorder()
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost", nil)
-	if err != nil {
-		t.Fatal(err)
-	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.BypassHeaders["x-skip-processing"] = "true"
+			cfg.RecordBypassReason = tc.record
 
-	handler.ServeHTTP(recorder, req)
+			var reason interface{}
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				reason = req.Context().Value(add_missing_headers.BypassReasonKey)
+			})
 
-	// Should default to 200 OK even when WriteHeader is not called explicitly
-	if recorder.Code != http.StatusOK {
-		t.Errorf("Expected default status 200 OK when WriteHeader not called, got %d", recorder.Code)
-	}
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
 
-	// Headers should still be added
-	assertResponseHeader(t, recorder, "X-Custom-Header", "custom-value")
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
 
-	// Response body should be preserved
-	expectedBody := "response without explicit status"
-	if recorder.Body.String() != expectedBody {
-		t.Errorf("Expected body %q, got %q", expectedBody, recorder.Body.String())
+			if tc.expected == nil {
+				if reason != nil {
+					t.Errorf("Expected no bypass reason, got %#v", reason)
+				}
+				return
+			}
+			if reason != *tc.expected {
+				t.Errorf("Expected bypass reason %#v, got %#v", *tc.expected, reason)
+			}
+		})
 	}
 }
 
-func TestExplicitStatusCodePreservation(t *testing.T) {
+func TestRequireHeaders(t *testing.T) {
 	cfg := add_missing_headers.CreateConfig()
-	cfg.ResponseHeaders["X-Status-Test"] = "status-test"
+	cfg.RequestHeaders["X-Test-Header"] = "test-value"
+	cfg.RequireHeaders["X-Enable-Headers"] = "1" // Value check
+	cfg.RequireHeaders["X-Tenant"] = ""          // Presence check
+	cfg.BypassHeaders["X-Skip-Processing"] = "true"
 
 	testCases := []struct {
-		name         string
-		statusCode   int
-		expectedCode int
+		name        string
+		headers     map[string]string
+		shouldApply bool
 	}{
-		{"Created", http.StatusCreated, http.StatusCreated},
-		{"Not Found", http.StatusNotFound, http.StatusNotFound},
-		{"Internal Server Error", http.StatusInternalServerError, http.StatusInternalServerError},
-		{"Accepted", http.StatusAccepted, http.StatusAccepted},
+		{
+			name:        "Apply when all requirements match",
+			headers:     map[string]string{"X-Enable-Headers": "1", "X-Tenant": "acme"},
+			shouldApply: true,
+		},
+		{
+			name:        "Skip when a required header is missing",
+			headers:     map[string]string{"X-Enable-Headers": "1"},
+			shouldApply: false,
+		},
+		{
+			name:        "Skip on wrong required value",
+			headers:     map[string]string{"X-Enable-Headers": "0", "X-Tenant": "acme"},
+			shouldApply: false,
+		},
+		{
+			name:        "Bypass wins over requirements",
+			headers:     map[string]string{"X-Enable-Headers": "1", "X-Tenant": "acme", "X-Skip-Processing": "true"},
+			shouldApply: false,
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			ctx := context.Background()
 			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-				rw.WriteHeader(tc.statusCode)
-				_, _ = rw.Write([]byte("test response"))
+				if tc.shouldApply {
+					assertHeader(t, req, "X-Test-Header", "test-value")
+				} else if req.Header.Get("X-Test-Header") != "" {
+					t.Error("Request headers should not be modified when requirements are not met")
+				}
+				rw.WriteHeader(http.StatusOK)
 			})
 
 			handler, err := add_missing_headers.New(ctx, next, cfg, "test-plugin")
@@ -495,19 +832,2037 @@ func TestExplicitStatusCodePreservation(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			handler.ServeHTTP(recorder, req)
-
-			// Explicit status codes should be preserved
-			if recorder.Code != tc.expectedCode {
-				t.Errorf("Expected status %d, got %d", tc.expectedCode, recorder.Code)
+			// Set test headers
+			for key, value := range tc.headers {
+				req.Header.Set(key, value)
 			}
 
-			// Headers should still be added regardless of status code
-			assertResponseHeader(t, recorder, "X-Status-Test", "status-test")
+			handler.ServeHTTP(recorder, req)
 		})
 	}
 }
 
+func TestIdempotencyHeaders(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.IdempotencyHeaders["X-Dedup"] = "1"
+
+	testCases := []struct {
+		name     string
+		key      string
+		expected string
+	}{
+		{"Idempotency key present", "8e03978e-40d5-43e8-bc93-6894a57f9324", "1"},
+		{"Idempotency key absent", "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				assertHeader(t, req, "X-Dedup", tc.expected)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "http://localhost", nil)
+			if tc.key != "" {
+				req.Header.Set("Idempotency-Key", tc.key)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.DryRun = true
+	cfg.RequestHeaders["X-Request-Header"] = "request-value"
+	cfg.RequestHeaders["X-Existing-Request"] = "ignored"
+	cfg.ResponseHeaders["X-Response-Header"] = "response-value"
+	cfg.ResponseHeaders["X-Existing-Response"] = "ignored"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// The forwarded request must not be modified
+		assertHeader(t, req, "X-Request-Header", "")
+		assertHeader(t, req, "X-Existing-Request", "existing")
+		rw.Header().Set("X-Existing-Response", "existing")
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("X-Existing-Request", "existing")
+
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	// The client-visible headers must not be modified
+	assertResponseHeader(t, recorder, "X-Response-Header", "")
+	assertResponseHeader(t, recorder, "X-Existing-Response", "existing")
+	assertResponseHeader(t, recorder, "X-Add-Missing-Headers-DryRun", "X-Request-Header,X-Response-Header")
+}
+
+func TestUpstreamTimeout(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.UpstreamTimeout = "50ms"
+	cfg.ResponseHeaders["X-Test"] = "test"
+
+	testCases := []struct {
+		name         string
+		delay        time.Duration
+		writeFirst   bool
+		expectedCode int
+	}{
+		{"Fast handler", 0, false, http.StatusOK},
+		{"Slow handler times out", time.Second, false, http.StatusGatewayTimeout},
+		{"Slow handler already writing", time.Second, true, http.StatusAccepted},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tc.writeFirst {
+					rw.WriteHeader(http.StatusAccepted)
+				}
+				select {
+				case <-time.After(tc.delay):
+				case <-req.Context().Done():
+					return
+				}
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			if recorder.Code != tc.expectedCode {
+				t.Errorf("Expected status %d, got %d", tc.expectedCode, recorder.Code)
+			}
+			assertResponseHeader(t, recorder, "X-Test", "test")
+		})
+	}
+}
+
+func TestUpstreamTimeout_Invalid(t *testing.T) {
+	for _, timeout := range []string{"soon", "-1s", "0s"} {
+		cfg := add_missing_headers.CreateConfig()
+		cfg.UpstreamTimeout = timeout
+
+		next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+		if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+			t.Errorf("Expected an error for upstreamTimeout %q", timeout)
+		}
+	}
+}
+
+func TestConditionalGetHeaders(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["Cache-Control"] = "max-age=3600"
+	cfg.ConditionalGetHeaders["Cache-Control"] = "no-cache"
+	cfg.ConditionalGetHeaders["X-Revalidated"] = "1"
+
+	testCases := []struct {
+		name                 string
+		method               string
+		headers              map[string]string
+		expectedCacheControl string
+		expectedRevalidated  string
+	}{
+		{"If-None-Match", http.MethodGet, map[string]string{"If-None-Match": `"abc"`}, "no-cache", "1"},
+		{"If-Modified-Since", http.MethodGet, map[string]string{"If-Modified-Since": "Wed, 21 Oct 2015 07:28:00 GMT"}, "no-cache", "1"},
+		{"Unconditional GET", http.MethodGet, map[string]string{}, "max-age=3600", ""},
+		{"Conditional POST", http.MethodPost, map[string]string{"If-None-Match": `"abc"`}, "max-age=3600", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, "http://localhost", nil)
+			for key, value := range tc.headers {
+				req.Header.Set(key, value)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "Cache-Control", tc.expectedCacheControl)
+			assertResponseHeader(t, recorder, "X-Revalidated", tc.expectedRevalidated)
+		})
+	}
+}
+
+func TestCloneRequest(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.CloneRequest = true
+	cfg.RequestHeaders["X-Test-Header"] = "test-value"
+
+	var seen *http.Request
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		seen = req
+		assertHeader(t, req, "X-Test-Header", "test-value")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("X-Existing-Header", "existing-value")
+
+	newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+
+	if seen == req {
+		t.Error("Expected next to receive a cloned request")
+	}
+	assertHeader(t, req, "X-Test-Header", "")
+	assertHeader(t, req, "X-Existing-Header", "existing-value")
+}
+
+func TestRestoreRequestHeaders(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RestoreRequestHeaders = true
+	cfg.RequestHeaders["X-Test-Header"] = "test-value"
+	cfg.RequestHeaderAllowlist = []string{"X-Existing-Header"}
+
+	var seen *http.Request
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		seen = req
+		assertHeader(t, req, "X-Test-Header", "test-value")
+		assertHeader(t, req, "X-Dropped-Header", "")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("X-Existing-Header", "existing-value")
+	req.Header.Set("X-Dropped-Header", "dropped-value")
+
+	newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+
+	if seen != req {
+		t.Error("Expected next to receive the original request")
+	}
+	expected := http.Header{
+		"X-Existing-Header": {"existing-value"},
+		"X-Dropped-Header":  {"dropped-value"},
+	}
+	if !reflect.DeepEqual(req.Header, expected) {
+		t.Errorf("Expected the original headers to be restored, got %v", req.Header)
+	}
+}
+
+func TestAutoVary(t *testing.T) {
+	testCases := []struct {
+		name         string
+		autoVary     bool
+		expectedVary []string
+	}{
+		{"Enabled", true, []string{"Accept-Encoding", "X-Skip-Processing, Accept"}},
+		{"Disabled", false, []string{"Accept-Encoding"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.AutoVary = tc.autoVary
+			cfg.ResponseHeaders["X-Response-Header"] = "response-value"
+			cfg.RequireHeaders["accept"] = ""
+			cfg.BypassHeaders["X-Skip-Processing"] = "true"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Vary", "Accept-Encoding")
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.Header.Set("Accept", "text/html")
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			actual := recorder.Header().Values("Vary")
+			if strings.Join(actual, "|") != strings.Join(tc.expectedVary, "|") {
+				t.Errorf("Expected Vary %q, got %q", tc.expectedVary, actual)
+			}
+		})
+	}
+}
+
+func TestAutoVary_EveryResponse(t *testing.T) {
+	testCases := []struct {
+		name      string
+		configure func(cfg *add_missing_headers.Config)
+		headers   map[string]string
+		write     bool
+	}{
+		{"Processed", func(cfg *add_missing_headers.Config) {}, map[string]string{"Accept": "text/html"}, true},
+		{"Bypassed", func(cfg *add_missing_headers.Config) {}, map[string]string{"Accept": "text/html", "X-Skip-Processing": "true"}, true},
+		{"Missing required header", func(cfg *add_missing_headers.Config) {}, nil, true},
+		{"Nothing written", func(cfg *add_missing_headers.Config) {}, nil, false},
+		{"Response bypass scope", func(cfg *add_missing_headers.Config) {
+			cfg.BypassScope = "response"
+		}, map[string]string{"Accept": "text/html", "X-Skip-Processing": "true"}, true},
+		{"Response headers disabled", func(cfg *add_missing_headers.Config) {
+			cfg.DisableResponseHeaders = true
+		}, map[string]string{"Accept": "text/html"}, true},
+		{"Skipped response", func(cfg *add_missing_headers.Config) {
+			cfg.SkipIfResponseHeaderPresent = map[string]string{"X-Upstream": ""}
+		}, map[string]string{"Accept": "text/html"}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.AutoVary = true
+			cfg.ResponseHeaders["X-Response-Header"] = "response-value"
+			cfg.RequireHeaders["accept"] = ""
+			cfg.BypassHeaders["X-Skip-Processing"] = "true"
+			tc.configure(cfg)
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tc.write {
+					rw.Header().Set("X-Upstream", "true")
+					rw.Header().Set("Vary", "Accept-Encoding")
+					rw.WriteHeader(http.StatusOK)
+				}
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			for key, value := range tc.headers {
+				req.Header.Set(key, value)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			vary := strings.Join(recorder.Header().Values("Vary"), ", ")
+			if !strings.Contains(vary, "X-Skip-Processing, Accept") {
+				t.Errorf("Expected Vary to list the consulted headers, got %q", vary)
+			}
+		})
+	}
+}
+
+func TestAutoVary_Sources(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.AutoVary = true
+	cfg.ApplyWhen.Header = "x-tenant"
+	cfg.HashBuckets = add_missing_headers.HashBuckets{
+		Header:  "x-user",
+		Buckets: []add_missing_headers.HashBucket{{Weight: 1}},
+	}
+	cfg.ResponseHeaders["X-Compressed"] = "true"
+	cfg.ResponseHeaderRequestConditions = map[string]map[string]string{
+		"X-Compressed": {"accept-encoding": "", "Accept": ""},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler := newTestHandler(t, cfg, next)
+
+	// Gated out by applyWhen, and processed
+	for _, tenant := range []string{"", "acme"} {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		if tenant != "" {
+			req.Header.Set("X-Tenant", tenant)
+		}
+
+		handler.ServeHTTP(recorder, req)
+
+		assertResponseHeader(t, recorder, "Vary", "X-Tenant, X-User, Accept, Accept-Encoding")
+	}
+}
+
+func TestFlushingBehavior(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		disableExplicitFlush bool
+		contentType          string
+		transferEncoding     string
+		expectFlushed        bool
+	}{
+		{"Server-Sent Events", false, "text/event-stream; charset=utf-8", "", true},
+		{"Chunked body", false, "application/json", "chunked", true},
+		{"Bulk body", false, "application/octet-stream", "", false},
+		{"No content type", false, "", "", false},
+		{"Server-Sent Events with flushing disabled", true, "text/event-stream", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.DisableExplicitFlush = tc.disableExplicitFlush
+			cfg.ResponseHeaders["X-Test"] = "test"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tc.contentType != "" {
+					rw.Header().Set("Content-Type", tc.contentType)
+				}
+				if tc.transferEncoding != "" {
+					rw.Header().Set("Transfer-Encoding", tc.transferEncoding)
+				}
+				rw.WriteHeader(http.StatusOK)
+				_, _ = rw.Write([]byte("data: test\n\n"))
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "X-Test", "test")
+			if recorder.Flushed != tc.expectFlushed {
+				t.Errorf("Expected flushed=%v, got %v", tc.expectFlushed, recorder.Flushed)
+			}
+		})
+	}
+}
+
+func TestChainedInstances(t *testing.T) {
+	security := add_missing_headers.CreateConfig()
+	security.ResponseHeaders["X-Frame-Options"] = "DENY"
+	security.ResponseHeaders["X-Content-Type-Options"] = "nosniff"
+
+	tracing := add_missing_headers.CreateConfig()
+	tracing.ResponseHeaders["X-Frame-Options"] = "SAMEORIGIN"
+	tracing.ResponseHeaders["X-Trace-Id"] = "trace"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 3; i++ {
+			_, _ = rw.Write([]byte("data: event\n\n"))
+		}
+	})
+	handler := newTestHandler(t, security, newTestHandler(t, tracing, next))
+
+	writer := &flushCountingResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+	handler.ServeHTTP(writer, req)
+
+	// The instance closest to the handler adds its headers first
+	assertResponseHeader(t, writer.ResponseRecorder, "X-Frame-Options", "SAMEORIGIN")
+	assertResponseHeader(t, writer.ResponseRecorder, "X-Content-Type-Options", "nosniff")
+	assertResponseHeader(t, writer.ResponseRecorder, "X-Trace-Id", "trace")
+	if writer.flushes != 3 {
+		t.Errorf("Expected one flush per write, got %d flushes", writer.flushes)
+	}
+	if got := strings.Count(writer.Body.String(), "data: event"); got != 3 {
+		t.Errorf("Expected 3 events, got %d", got)
+	}
+}
+
+func TestStampProcessedHeader(t *testing.T) {
+	testCases := []struct {
+		name       string
+		stamp      bool
+		headerName string
+		header     string
+		expected   []string
+	}{
+		{"Disabled", false, "", "X-Processed-By", nil},
+		{"Default header", true, "", "X-Processed-By", []string{"security"}},
+		{"Custom header", true, "x-middleware", "X-Middleware", []string{"security"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.StampProcessedHeader = tc.stamp
+			cfg.ProcessedHeaderName = tc.headerName
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			handler, err := add_missing_headers.New(context.Background(), next, cfg, "security")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			handler.ServeHTTP(recorder, req)
+
+			if got := recorder.Header().Values(tc.header); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %s %q, got %q", tc.header, tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestStampProcessedHeader_Chained(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.StampProcessedHeader = true
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	inner, err := add_missing_headers.New(context.Background(), next, cfg, "tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer, err := add_missing_headers.New(context.Background(), inner, cfg, "security")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+	outer.ServeHTTP(recorder, req)
+
+	expected := []string{"tracing", "security"}
+	if got := recorder.Header().Values("X-Processed-By"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected X-Processed-By %q, got %q", expected, got)
+	}
+}
+
+func TestHeadRequest(t *testing.T) {
+	testCases := []struct {
+		name  string
+		write func(rw http.ResponseWriter)
+	}{
+		{"Write", func(rw http.ResponseWriter) {
+			_, _ = rw.Write([]byte("data: test\n\n"))
+		}},
+		{"ReadFrom", func(rw http.ResponseWriter) {
+			_, _ = io.Copy(rw, struct{ io.Reader }{strings.NewReader("data: test\n\n")})
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.ResponseHeaders["X-Test"] = "test"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				// Streaming responses would otherwise be flushed after every write
+				rw.Header().Set("Content-Type", "text/event-stream")
+				tc.write(rw)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodHead, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			if recorder.Code != http.StatusOK {
+				t.Errorf("Expected status 200, got %d", recorder.Code)
+			}
+			assertResponseHeader(t, recorder, "X-Test", "test")
+			if recorder.Flushed {
+				t.Error("Expected no flush for a HEAD request")
+			}
+		})
+	}
+}
+
+func TestHeadContentLength(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["X-Test"] = "test"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("hello world"))
+	})
+	server := httptest.NewServer(newTestHandler(t, cfg, next))
+	defer server.Close()
+
+	contentLength := func(method string) string {
+		req, err := http.NewRequest(method, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		if got := res.Header.Get("X-Test"); got != "test" {
+			t.Errorf("Expected X-Test %q on %s, got %q", "test", method, got)
+		}
+		return res.Header.Get("Content-Length")
+	}
+
+	get := contentLength(http.MethodGet)
+	head := contentLength(http.MethodHead)
+	if get != "11" {
+		t.Errorf("Expected Content-Length %q on GET, got %q", "11", get)
+	}
+	if head != get {
+		t.Errorf("Expected Content-Length %q on HEAD, got %q", get, head)
+	}
+}
+
+func TestUnflushableWriter(t *testing.T) {
+	for _, warn := range []bool{false, true} {
+		t.Run(fmt.Sprintf("warn=%t", warn), func(t *testing.T) {
+			var logs bytes.Buffer
+			defer add_missing_headers.SetLogOutput(&logs)()
+
+			cfg := add_missing_headers.CreateConfig()
+			cfg.ResponseHeaders["X-Test"] = "test"
+			cfg.WarnUnflushableWriter = warn
+
+			var canFlush []bool
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				canFlush = append(canFlush, rw.(interface{ CanFlush() bool }).CanFlush())
+				_, _ = rw.Write([]byte("event"))
+				rw.(http.Flusher).Flush()
+				rw.(http.Flusher).Flush()
+			})
+			handler := newTestHandler(t, cfg, next)
+
+			// countingResponseWriter hides the recorder's Flush
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "http://localhost/events", nil)
+				handler.ServeHTTP(&countingResponseWriter{ResponseWriter: httptest.NewRecorder()}, req)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/events", nil))
+
+			if !reflect.DeepEqual(canFlush, []bool{false, false, true}) {
+				t.Errorf("Expected CanFlush to report [false false true], got %v", canFlush)
+			}
+
+			expected := 0
+			if warn {
+				expected = 1
+			}
+			if got := strings.Count(logs.String(), "doesn't support flushing"); got != expected {
+				t.Errorf("Expected %d warnings, got %d: %q", expected, got, logs.String())
+			}
+		})
+	}
+}
+
+func TestEmitFlushMode(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		disableExplicitFlush bool
+		contentType          string
+		expectedMode         string
+	}{
+		{"Streaming response", false, "text/event-stream", "explicit"},
+		{"Bulk response", false, "text/plain", "disabled"},
+		{"Flushing disabled", true, "text/event-stream", "disabled"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.DisableExplicitFlush = tc.disableExplicitFlush
+			cfg.EmitFlushMode = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Type", tc.contentType)
+				_, _ = rw.Write([]byte("test"))
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "X-Flush", tc.expectedMode)
+			if recorder.Flushed != (tc.expectedMode == "explicit") {
+				t.Errorf("Reported mode %q but flushed=%v", tc.expectedMode, recorder.Flushed)
+			}
+		})
+	}
+}
+
+func TestPush(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["X-Test"] = "test"
+
+	var pushErr error
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		pusher, ok := rw.(http.Pusher)
+		if !ok {
+			t.Fatal("Expected the response writer to implement http.Pusher")
+		}
+		pushErr = pusher.Push("/app.css", nil)
+		rw.WriteHeader(http.StatusOK)
+	})
+	handler := newTestHandler(t, cfg, next)
+
+	t.Run("Supported", func(t *testing.T) {
+		writer := &pushingResponseWriter{ResponseWriter: httptest.NewRecorder()}
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+		handler.ServeHTTP(writer, req)
+
+		if pushErr != nil {
+			t.Errorf("Unexpected push error: %v", pushErr)
+		}
+		if len(writer.pushed) != 1 || writer.pushed[0] != "/app.css" {
+			t.Errorf("Expected /app.css to be pushed, got %v", writer.pushed)
+		}
+	})
+
+	t.Run("Not supported", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if !errors.Is(pushErr, http.ErrNotSupported) {
+			t.Errorf("Expected http.ErrNotSupported, got %v", pushErr)
+		}
+	})
+}
+
+func TestResponseController(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["X-Test"] = "test"
+
+	var deadlineErr, flushErr error
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rc := http.NewResponseController(rw)
+		deadlineErr = rc.SetWriteDeadline(time.Unix(1700000000, 0))
+		_, _ = rw.Write([]byte("hello"))
+		flushErr = rc.Flush()
+	})
+	handler := newTestHandler(t, cfg, next)
+
+	writer := &deadlineResponseWriter{flushCountingResponseWriter: &flushCountingResponseWriter{ResponseRecorder: httptest.NewRecorder()}}
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+	handler.ServeHTTP(writer, req)
+
+	if deadlineErr != nil {
+		t.Errorf("Unexpected deadline error: %v", deadlineErr)
+	}
+	if !writer.writeDeadline.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Expected the write deadline to reach the underlying writer, got %v", writer.writeDeadline)
+	}
+	if flushErr != nil {
+		t.Errorf("Unexpected flush error: %v", flushErr)
+	}
+	if writer.flushes != 1 {
+		t.Errorf("Expected 1 flush, got %d", writer.flushes)
+	}
+	if got := writer.Header().Get("X-Test"); got != "test" {
+		t.Errorf("Expected X-Test to be added, got %q", got)
+	}
+}
+
+func TestDeadlines(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["X-Test"] = "test"
+
+	deadline := time.Unix(1700000000, 0)
+	var readErr, writeErr error
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		readErr = rw.(interface{ SetReadDeadline(time.Time) error }).SetReadDeadline(deadline)
+		writeErr = rw.(interface{ SetWriteDeadline(time.Time) error }).SetWriteDeadline(deadline)
+		rw.WriteHeader(http.StatusOK)
+	})
+	handler := newTestHandler(t, cfg, next)
+
+	t.Run("Supported", func(t *testing.T) {
+		writer := &deadlineResponseWriter{flushCountingResponseWriter: &flushCountingResponseWriter{ResponseRecorder: httptest.NewRecorder()}}
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+		handler.ServeHTTP(writer, req)
+
+		if readErr != nil || writeErr != nil {
+			t.Errorf("Unexpected deadline errors: %v, %v", readErr, writeErr)
+		}
+		if !writer.readDeadline.Equal(deadline) || !writer.writeDeadline.Equal(deadline) {
+			t.Errorf("Expected both deadlines to be %v, got %v and %v", deadline, writer.readDeadline, writer.writeDeadline)
+		}
+	})
+
+	t.Run("Not supported", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if !errors.Is(readErr, http.ErrNotSupported) || !errors.Is(writeErr, http.ErrNotSupported) {
+			t.Errorf("Expected http.ErrNotSupported, got %v and %v", readErr, writeErr)
+		}
+	})
+}
+
+func TestEarlyHints(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["X-Test"] = "test"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		rw.WriteHeader(http.StatusEarlyHints)
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("hello"))
+	})
+	server := httptest.NewServer(newTestHandler(t, cfg, next))
+	defer server.Close()
+
+	var hints []textproto.MIMEHeader
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				hints = append(hints, header)
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if len(hints) != 1 {
+		t.Fatalf("Expected 1 early hints response, got %d", len(hints))
+	}
+	if hints[0].Get("Link") == "" {
+		t.Error("Expected the early hints to carry the Link header")
+	}
+	if got := hints[0].Get("X-Test"); got != "" {
+		t.Errorf("Expected X-Test to be absent from the early hints, got %q", got)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if got := res.Header.Get("X-Test"); got != "test" {
+		t.Errorf("Expected X-Test %q on the final response, got %q", "test", got)
+	}
+}
+
+func TestReadFrom(t *testing.T) {
+	testCases := []struct {
+		name          string
+		enableGzip    bool
+		expectedCalls int
+	}{
+		{"Delegated", false, 1},
+		{"Compressed body is copied", true, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.ResponseHeaders["X-Test"] = "test"
+			cfg.EnableGzip = tc.enableGzip
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Content-Type", "text/plain")
+				if _, err := io.Copy(rw, struct{ io.Reader }{strings.NewReader("hello world")}); err != nil {
+					t.Errorf("Unexpected copy error: %v", err)
+				}
+			})
+
+			writer := &readerFromResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			newTestHandler(t, cfg, next).ServeHTTP(writer, req)
+
+			if writer.readFromCalls != tc.expectedCalls {
+				t.Errorf("Expected %d ReadFrom calls, got %d", tc.expectedCalls, writer.readFromCalls)
+			}
+			assertResponseHeader(t, writer.ResponseRecorder, "X-Test", "test")
+			if !tc.enableGzip && writer.Body.String() != "hello world" {
+				t.Errorf("Unexpected body %q", writer.Body.String())
+			}
+		})
+	}
+}
+
+func TestDisablePhases(t *testing.T) {
+	testCases := []struct {
+		name             string
+		disableRequest   bool
+		disableResponse  bool
+		expectedRequest  string
+		expectedResponse string
+	}{
+		{"Both phases", false, false, "request", "response"},
+		{"Request phase disabled", true, false, "", "response"},
+		{"Response phase disabled", false, true, "request", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeaders["X-Test"] = "request"
+			cfg.ResponseHeaders["X-Test"] = "response"
+			cfg.CIDRLabelHeader = "X-Net"
+			cfg.CIDRLabels = []add_missing_headers.CIDRLabel{{CIDR: "192.0.2.0/24", Label: "test"}}
+			cfg.EmitRequestCount = true
+			cfg.DisableRequestHeaders = tc.disableRequest
+			cfg.DisableResponseHeaders = tc.disableResponse
+
+			var requestHeader http.Header
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				requestHeader = req.Header.Clone()
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.Header.Set("X-Net", "spoofed")
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			if got := requestHeader.Get("X-Test"); got != tc.expectedRequest {
+				t.Errorf("Request header X-Test: expected %q, got %q", tc.expectedRequest, got)
+			}
+			if tc.disableRequest && requestHeader.Get("X-Net") != "spoofed" {
+				t.Errorf("Expected the request to be untouched, got X-Net %q", requestHeader.Get("X-Net"))
+			}
+			assertResponseHeader(t, recorder, "X-Test", tc.expectedResponse)
+			if tc.disableResponse && recorder.Header().Get("X-Request-Count") != "" {
+				t.Error("Expected no X-Request-Count with response headers disabled")
+			}
+		})
+	}
+}
+
+func TestWebSocketUpgrade(t *testing.T) {
+	testCases := []struct {
+		name             string
+		wrap             bool
+		connection       string
+		expectedResponse string
+		expectWrapped    bool
+	}{
+		{"Upgrade passes through", false, "keep-alive, Upgrade", "", false},
+		{"Wrapping opted in", true, "Upgrade", "response", true},
+		{"Not an upgrade", false, "keep-alive", "response", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeaders["X-Test"] = "request"
+			cfg.ResponseHeaders["X-Test"] = "response"
+			cfg.WrapWebSocketUpgrades = tc.wrap
+
+			recorder := httptest.NewRecorder()
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				// Request headers are still added to upgrade requests
+				assertHeader(t, req, "X-Test", "request")
+				if wrapped := rw != http.ResponseWriter(recorder); wrapped != tc.expectWrapped {
+					t.Errorf("Expected wrapped=%v, got %v", tc.expectWrapped, wrapped)
+				}
+				rw.WriteHeader(http.StatusSwitchingProtocols)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/ws", nil)
+			req.Header.Set("Connection", tc.connection)
+			req.Header.Set("Upgrade", "WebSocket")
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "X-Test", tc.expectedResponse)
+		})
+	}
+}
+
+func TestTrailersPassThrough(t *testing.T) {
+	for _, disableExplicitFlush := range []bool{false, true} {
+		cfg := add_missing_headers.CreateConfig()
+		cfg.DisableExplicitFlush = disableExplicitFlush
+		cfg.ResponseHeaders["X-Test"] = "test"
+
+		next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("Trailer", "X-Foo")
+			rw.WriteHeader(http.StatusOK)
+			_, _ = rw.Write([]byte("first chunk"))
+			_, _ = rw.Write([]byte("second chunk"))
+			rw.Header().Set("X-Foo", "bar")
+		})
+
+		server := httptest.NewServer(newTestHandler(t, cfg, next))
+
+		resp, err := server.Client().Get(server.URL)
+		if err != nil {
+			server.Close()
+			t.Fatal(err)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(body) != "first chunksecond chunk" {
+			t.Errorf("Unexpected body %q", body)
+		}
+		if resp.Header.Get("X-Test") != "test" {
+			t.Errorf("Expected response header X-Test to be added (disableExplicitFlush=%v)", disableExplicitFlush)
+		}
+		if resp.Trailer.Get("X-Foo") != "bar" {
+			t.Errorf("Expected trailer X-Foo=bar, got %q (disableExplicitFlush=%v)", resp.Trailer.Get("X-Foo"), disableExplicitFlush)
+		}
+	}
+}
+
+func TestDefaultStatusCodeBehavior(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["X-Custom-Header"] = "custom-value"
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// Only call Write() without explicit WriteHeader() - should default to 200 OK
+		_, _ = rw.Write([]byte("response without explicit status"))
+	})
+
+	handler, err := add_missing_headers.New(ctx, next, cfg, "test-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler.ServeHTTP(recorder, req)
+
+	// Should default to 200 OK even when WriteHeader is not called explicitly
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected default status 200 OK when WriteHeader not called, got %d", recorder.Code)
+	}
+
+	// Headers should still be added
+	assertResponseHeader(t, recorder, "X-Custom-Header", "custom-value")
+
+	// Response body should be preserved
+	expectedBody := "response without explicit status"
+	if recorder.Body.String() != expectedBody {
+		t.Errorf("Expected body %q, got %q", expectedBody, recorder.Body.String())
+	}
+}
+
+func TestResponseHeaders_HandlerWritesNothing(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["X-Custom-Header"] = "custom-value"
+
+	// The handler returns without calling WriteHeader or Write
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", recorder.Code)
+	}
+	assertResponseHeader(t, recorder, "X-Custom-Header", "custom-value")
+}
+
+func TestResponseHeaders_NoDoubleWriteHeader(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["X-Custom-Header"] = "custom-value"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	writer := &countingResponseWriter{ResponseWriter: httptest.NewRecorder()}
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+	newTestHandler(t, cfg, next).ServeHTTP(writer, req)
+
+	if writer.writeHeaderCalls != 1 {
+		t.Errorf("Expected WriteHeader to be called once, got %d", writer.writeHeaderCalls)
+	}
+}
+
+func TestExplicitStatusCodePreservation(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["X-Status-Test"] = "status-test"
+
+	testCases := []struct {
+		name         string
+		statusCode   int
+		expectedCode int
+	}{
+		{"Created", http.StatusCreated, http.StatusCreated},
+		{"Not Found", http.StatusNotFound, http.StatusNotFound},
+		{"Internal Server Error", http.StatusInternalServerError, http.StatusInternalServerError},
+		{"Accepted", http.StatusAccepted, http.StatusAccepted},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(tc.statusCode)
+				_, _ = rw.Write([]byte("test response"))
+			})
+
+			handler, err := add_missing_headers.New(ctx, next, cfg, "test-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			handler.ServeHTTP(recorder, req)
+
+			// Explicit status codes should be preserved
+			if recorder.Code != tc.expectedCode {
+				t.Errorf("Expected status %d, got %d", tc.expectedCode, recorder.Code)
+			}
+
+			// Headers should still be added regardless of status code
+			assertResponseHeader(t, recorder, "X-Status-Test", "status-test")
+		})
+	}
+}
+
+func TestDisableHeader(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Test-Header"] = "test-value"
+	cfg.ResponseHeaders["X-Response-Header"] = "response-value"
+	cfg.DisableHeader = add_missing_headers.DisableHeader{Name: "X-Disable-Plugin", Secret: "s3cret"}
+
+	testCases := []struct {
+		name         string
+		value        string
+		shouldBypass bool
+	}{
+		{"Valid signature", "nonce-1:" + sign("s3cret", "nonce-1"), true},
+		{"Invalid signature", "nonce-1:" + sign("wrong", "nonce-1"), false},
+		{"Malformed signature", "nonce-1:not-hex", false},
+		{"Absent header", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tc.shouldBypass {
+					if req.Header.Get("X-Test-Header") != "" {
+						t.Error("Request headers should not be modified when disabled")
+					}
+				} else {
+					assertHeader(t, req, "X-Test-Header", "test-value")
+				}
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			handler, err := add_missing_headers.New(ctx, next, cfg, "test-plugin")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.value != "" {
+				req.Header.Set("X-Disable-Plugin", tc.value)
+			}
+
+			handler.ServeHTTP(recorder, req)
+
+			if tc.shouldBypass {
+				assertResponseHeader(t, recorder, "X-Response-Header", "")
+			} else {
+				assertResponseHeader(t, recorder, "X-Response-Header", "response-value")
+			}
+		})
+	}
+}
+
+func TestDisableHeader_MissingSecret(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.DisableHeader = add_missing_headers.DisableHeader{Name: "X-Disable-Plugin"}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+		t.Error("Expected an error when disableHeader has no secret")
+	}
+}
+
+func TestEmitRequestCount_Concurrent(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.EmitRequestCount = true
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	handler, err := add_missing_headers.New(ctx, next, cfg, "test-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const requests = 100
+
+	var wg sync.WaitGroup
+	counts := make([]int, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			handler.ServeHTTP(recorder, req)
+
+			count, err := strconv.Atoi(recorder.Header().Get("X-Request-Count"))
+			if err != nil {
+				t.Errorf("Invalid X-Request-Count: %v", err)
+				return
+			}
+			counts[i] = count
+		}(i)
+	}
+	wg.Wait()
+
+	// Every request must observe a distinct value from a gapless sequence
+	sort.Ints(counts)
+	for i, count := range counts {
+		if count != i+1 {
+			t.Fatalf("Expected counts 1..%d, got %v", requests, counts)
+		}
+	}
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	writeHeaderCalls int
+}
+
+func (w *countingResponseWriter) WriteHeader(code int) {
+	w.writeHeaderCalls++
+	w.ResponseWriter.WriteHeader(code)
+}
+
+type flushCountingResponseWriter struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (w *flushCountingResponseWriter) Flush() {
+	w.flushes++
+	w.ResponseRecorder.Flush()
+}
+
+type deadlineResponseWriter struct {
+	*flushCountingResponseWriter
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func (w *deadlineResponseWriter) SetReadDeadline(deadline time.Time) error {
+	w.readDeadline = deadline
+	return nil
+}
+
+func (w *deadlineResponseWriter) SetWriteDeadline(deadline time.Time) error {
+	w.writeDeadline = deadline
+	return nil
+}
+
+type pushingResponseWriter struct {
+	http.ResponseWriter
+	pushed []string
+}
+
+func (w *pushingResponseWriter) Push(target string, opts *http.PushOptions) error {
+	w.pushed = append(w.pushed, target)
+	return nil
+}
+
+type readerFromResponseWriter struct {
+	*httptest.ResponseRecorder
+	readFromCalls int
+}
+
+func (w *readerFromResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.readFromCalls++
+	return io.Copy(w.ResponseRecorder, src)
+}
+
+func newTestHandler(t *testing.T, cfg *add_missing_headers.Config, next http.Handler) http.Handler {
+	t.Helper()
+	handler, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func sign(secret, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// discardResponseWriter is a ResponseWriter discarding everything, its header is cleared between requests.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardResponseWriter) WriteHeader(code int)        {}
+
+func (w *discardResponseWriter) reset() {
+	for key := range w.header {
+		delete(w.header, key)
+	}
+}
+
+func benchmarkServeHTTP(b *testing.B, cfg *add_missing_headers.Config, requestHeader http.Header) {
+	b.Helper()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write([]byte("ok"))
+	})
+
+	handler, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	writer := &discardResponseWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		req.Header = requestHeader.Clone()
+		writer.reset()
+		handler.ServeHTTP(writer, req)
+	}
+}
+
+func BenchmarkServeHTTP_RequestOnly(b *testing.B) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Request-One"] = "one"
+	cfg.RequestHeaders["X-Request-Two"] = "two"
+
+	benchmarkServeHTTP(b, cfg, http.Header{})
+}
+
+func BenchmarkServeHTTP_ResponseHeaders(b *testing.B) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Request-One"] = "one"
+	cfg.ResponseHeaders["X-Response-One"] = "one"
+	cfg.ResponseHeaders["X-Response-Two"] = "two"
+
+	benchmarkServeHTTP(b, cfg, http.Header{})
+}
+
+func BenchmarkServeHTTP_Bypass(b *testing.B) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Request-One"] = "one"
+	cfg.ResponseHeaders["X-Response-One"] = "one"
+	cfg.BypassHeaders["X-Skip"] = ""
+
+	benchmarkServeHTTP(b, cfg, http.Header{"X-Skip": {"1"}})
+}
+
+func BenchmarkLargeResponse(b *testing.B) {
+	const (
+		bodySize  = 10 << 20
+		chunkSize = 32 << 10
+	)
+	chunk := make([]byte, chunkSize)
+
+	for _, bc := range []struct {
+		name                 string
+		disableExplicitFlush bool
+	}{
+		{"ExplicitFlush", false},
+		{"NoExplicitFlush", true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.DisableExplicitFlush = bc.disableExplicitFlush
+			cfg.ResponseHeaders["X-Test"] = "test"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				for written := 0; written < bodySize; written += chunkSize {
+					_, _ = rw.Write(chunk)
+				}
+			})
+
+			handler, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin")
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			b.SetBytes(bodySize)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				resp, err := server.Client().Get(server.URL)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+			}
+		})
+	}
+}
+
+func BenchmarkLargeFile(b *testing.B) {
+	const fileSize = 10 << 20
+
+	path := filepath.Join(b.TempDir(), "large.bin")
+	if err := os.WriteFile(path, make([]byte, fileSize), 0o600); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bc := range []struct {
+		name string
+		copy func(rw http.ResponseWriter, f *os.File) error
+	}{
+		// Hiding ReadFrom reproduces the buffered copy used before the modifier implemented it
+		{"BufferedCopy", func(rw http.ResponseWriter, f *os.File) error {
+			_, err := io.Copy(struct{ io.Writer }{rw}, f)
+			return err
+		}},
+		{"ReadFrom", func(rw http.ResponseWriter, f *os.File) error {
+			_, err := io.Copy(rw, f)
+			return err
+		}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.ResponseHeaders["X-Test"] = "test"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				f, err := os.Open(path)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				defer f.Close()
+
+				rw.Header().Set("Content-Length", strconv.Itoa(fileSize))
+				if err := bc.copy(rw, f); err != nil {
+					b.Error(err)
+				}
+			})
+
+			handler, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin")
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			b.SetBytes(fileSize)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				resp, err := server.Client().Get(server.URL)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+			}
+		})
+	}
+}
+
+func TestRequireResponseHeaders(t *testing.T) {
+	testCases := []struct {
+		name            string
+		responseHeaders map[string]string
+		expectedLog     string
+	}{
+		{"Added by the middleware", map[string]string{"Strict-Transport-Security": "max-age=63072000"}, ""},
+		{"Missing", map[string]string{"X-Other": "1"}, "missing required headers: Strict-Transport-Security"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs strings.Builder
+			defer add_missing_headers.SetLogOutput(&logs)()
+
+			cfg := add_missing_headers.CreateConfig()
+			cfg.ResponseHeaders = tc.responseHeaders
+			cfg.RequireResponseHeaders = []string{"strict-transport-security"}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				_, _ = rw.Write([]byte("test"))
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/path", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			if tc.expectedLog == "" {
+				if logs.Len() > 0 {
+					t.Errorf("Expected no log, got %q", logs.String())
+				}
+				return
+			}
+			if !strings.Contains(logs.String(), tc.expectedLog) || !strings.Contains(logs.String(), "GET /path") {
+				t.Errorf("Expected log containing %q, got %q", tc.expectedLog, logs.String())
+			}
+		})
+	}
+}
+
+func TestStripServerHeader(t *testing.T) {
+	testCases := []struct {
+		name            string
+		responseHeaders map[string]string
+		status          int
+		expected        string
+	}{
+		{"Stripped", map[string]string{}, http.StatusOK, ""},
+		{"Stripped on excluded status", map[string]string{}, http.StatusNotFound, ""},
+		{"Rewritten", map[string]string{"Server": "web"}, http.StatusOK, "web"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.StripServerHeader = true
+			cfg.ResponseHeaders = tc.responseHeaders
+			cfg.ExcludeStatuses = []int{http.StatusNotFound}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Server", "nginx/1.25.3")
+				rw.WriteHeader(tc.status)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "Server", tc.expected)
+		})
+	}
+}
+
+func TestDropContentLength(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.DropContentLength = true
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Length", "5")
+		_, _ = rw.Write([]byte("hello"))
+		// Flushing keeps the server from computing the length of the short body itself
+		rw.(http.Flusher).Flush()
+	})
+
+	server := httptest.NewServer(newTestHandler(t, cfg, next))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.ContentLength != -1 {
+		t.Errorf("Expected no Content-Length, got %d", resp.ContentLength)
+	}
+	if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+		t.Errorf("Expected a chunked response, got %v", resp.TransferEncoding)
+	}
+	if string(body) != "hello" {
+		t.Errorf("Expected body %q, got %q", "hello", body)
+	}
+}
+
+func TestSkipIfResponseHeaderPresent(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["X-Frame-Options"] = "DENY"
+	cfg.StripServerHeader = true
+	cfg.SkipIfResponseHeaderPresent = map[string]string{"X-Error-Page": ""}
+
+	testCases := []struct {
+		name           string
+		marked         bool
+		expectedFrame  string
+		expectedServer string
+	}{
+		{"Marked error page", true, "", "traefik"},
+		{"Regular response", false, "DENY", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Server", "traefik")
+				if tc.marked {
+					rw.Header().Set("X-Error-Page", "true")
+				}
+				rw.WriteHeader(http.StatusBadGateway)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "X-Frame-Options", tc.expectedFrame)
+			assertResponseHeader(t, recorder, "Server", tc.expectedServer)
+			if recorder.Code != http.StatusBadGateway {
+				t.Errorf("Expected status %d, got %d", http.StatusBadGateway, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestResponseHeaderDependencies(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["Cache-Control"] = "no-store"
+	cfg.ResponseHeaders["X-Frame-Options"] = "DENY"
+	cfg.ResponseHeaderDependencies = map[string]string{"cache-control": "surrogate-control"}
+
+	testCases := []struct {
+		name             string
+		surrogateControl string
+		expectedCache    string
+	}{
+		{"Dependency present", "max-age=600", ""},
+		{"Dependency absent", "", "no-store"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tc.surrogateControl != "" {
+					rw.Header().Set("Surrogate-Control", tc.surrogateControl)
+				}
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "Cache-Control", tc.expectedCache)
+			assertResponseHeader(t, recorder, "X-Frame-Options", "DENY")
+		})
+	}
+}
+
+func TestResponseHeaderRequestConditions(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["Vary"] = "Accept-Encoding"
+	cfg.ResponseHeaders["X-Api-Version"] = "2"
+	cfg.ResponseHeaders["X-Frame-Options"] = "DENY"
+	cfg.ResponseHeaderRequestConditions = map[string]map[string]string{
+		"vary":          {"accept-encoding": ""},
+		"X-Api-Version": {"Accept": "glob:application/vnd.api+json*"},
+	}
+
+	testCases := []struct {
+		name               string
+		requestHeaders     map[string]string
+		expectedVary       string
+		expectedAPIVersion string
+	}{
+		{"No conditions met", nil, "", ""},
+		{"Presence condition met", map[string]string{"Accept-Encoding": "gzip"}, "Accept-Encoding", ""},
+		{"Value condition met", map[string]string{"Accept": "application/vnd.api+json; version=2"}, "", "2"},
+		{"Value condition not met", map[string]string{"Accept": "text/html"}, "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			for key, value := range tc.requestHeaders {
+				req.Header.Set(key, value)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "Vary", tc.expectedVary)
+			assertResponseHeader(t, recorder, "X-Api-Version", tc.expectedAPIVersion)
+			assertResponseHeader(t, recorder, "X-Frame-Options", "DENY")
+		})
+	}
+}
+
+func TestRemoveResponseHeaderPrefixes(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RemoveResponseHeaderPrefixes = []string{"x-debug-"}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Debug-Query-Time", "12ms")
+		rw.Header().Set("X-Debug-Backend", "db-1")
+		rw.Header()["x-debug-raw"] = []string{"non-canonical"}
+		rw.Header().Set("X-Debugger", "kept")
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	for _, name := range []string{"X-Debug-Query-Time", "X-Debug-Backend"} {
+		assertResponseHeader(t, recorder, name, "")
+	}
+	if _, ok := recorder.Header()["x-debug-raw"]; ok {
+		t.Error("Expected non-canonical x-debug-raw to be removed")
+	}
+	assertResponseHeader(t, recorder, "X-Debugger", "kept")
+	assertResponseHeader(t, recorder, "Content-Type", "text/plain")
+}
+
+func TestSkipWhenEncoded(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["Content-Length"] = "5"
+	cfg.ResponseHeaders["Cache-Control"] = "public, max-age=60"
+	cfg.ResponseHeaders["X-Frame-Options"] = "DENY"
+	cfg.SkipWhenEncoded = []string{"content-length", "Cache-Control"}
+
+	testCases := []struct {
+		name          string
+		encoding      string
+		expectedSkips bool
+	}{
+		{"Gzip", "gzip", true},
+		{"Identity", "identity", false},
+		{"Plain", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tc.encoding != "" {
+					rw.Header().Set("Content-Encoding", tc.encoding)
+				}
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			contentLength, cacheControl := "5", "public, max-age=60"
+			if tc.expectedSkips {
+				contentLength, cacheControl = "", ""
+			}
+			assertResponseHeader(t, recorder, "Content-Length", contentLength)
+			assertResponseHeader(t, recorder, "Cache-Control", cacheControl)
+			assertResponseHeader(t, recorder, "X-Frame-Options", "DENY")
+		})
+	}
+}
+
+func TestRemoveResponseHeaderPatterns(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RemoveResponseHeaderPatterns = []string{"X-*-Internal", "x-trace-?"}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Foo-Internal", "secret")
+		rw.Header()["x-bar-internal"] = []string{"non-canonical"}
+		rw.Header().Set("X-Foo-Public", "kept")
+		rw.Header().Set("X-Trace-A", "removed")
+		rw.Header().Set("X-Trace-Ab", "kept")
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	assertResponseHeader(t, recorder, "X-Foo-Internal", "")
+	if _, ok := recorder.Header()["x-bar-internal"]; ok {
+		t.Error("Expected non-canonical x-bar-internal to be removed")
+	}
+	assertResponseHeader(t, recorder, "X-Foo-Public", "kept")
+	assertResponseHeader(t, recorder, "X-Trace-A", "")
+	assertResponseHeader(t, recorder, "X-Trace-Ab", "kept")
+}
+
+func TestRemoveResponseHeaderPatterns_Invalid(t *testing.T) {
+	for _, pattern := range []string{"", "X-[a-"} {
+		cfg := add_missing_headers.CreateConfig()
+		cfg.RemoveResponseHeaderPatterns = []string{pattern}
+
+		next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+		if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+			t.Errorf("Expected an error for pattern %q", pattern)
+		}
+	}
+}
+
+func TestEvaluationOrder(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.BypassHeaders["X-Skip"] = ""
+	cfg.RequestHeaders["X-Request"] = "configured"
+	cfg.ResponseHeaders["X-Debug-Id"] = "configured"
+	cfg.ResponseHeaders["X-Frame-Options"] = "DENY"
+	cfg.RemoveResponseHeaderPrefixes = []string{"x-debug-"}
+	cfg.QueryConditions = []add_missing_headers.QueryCondition{
+		{Param: "embed", RequestHeaders: map[string]string{"X-Request": "embed"}, ResponseHeaders: map[string]string{"X-Frame-Options": "SAMEORIGIN"}},
+	}
+
+	testCases := []struct {
+		name            string
+		url             string
+		bypass          bool
+		expectedRequest string
+		expectedTrace   string
+		expectedDebugID string
+		expectedFrame   string
+	}{
+		// Bypassing skips both phases, upstream headers are not even removed
+		{"Bypassed", "http://localhost", true, "", "upstream", "upstream", ""},
+		// Removals run before additions, so a configured header with a removed prefix is still added
+		{"Processed", "http://localhost", false, "configured", "", "configured", "DENY"},
+		// The query condition is more specific and wins in both phases
+		{"Query condition", "http://localhost?embed", false, "embed", "", "configured", "SAMEORIGIN"},
+		{"Bypass wins over query condition", "http://localhost?embed", true, "", "upstream", "upstream", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				assertHeader(t, req, "X-Request", tc.expectedRequest)
+				rw.Header().Set("X-Debug-Trace", "upstream")
+				rw.Header().Set("X-Debug-Id", "upstream")
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.bypass {
+				req.Header.Set("X-Skip", "1")
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "X-Debug-Trace", tc.expectedTrace)
+			assertResponseHeader(t, recorder, "X-Debug-Id", tc.expectedDebugID)
+			assertResponseHeader(t, recorder, "X-Frame-Options", tc.expectedFrame)
+		})
+	}
+}
+
+func TestMaxRequestHeaders(t *testing.T) {
+	testCases := []struct {
+		name           string
+		headerCount    int
+		bypass         bool
+		dryRun         bool
+		expectedStatus int
+	}{
+		{"Under the limit", 2, false, false, http.StatusOK},
+		{"At the limit", 3, false, false, http.StatusOK},
+		{"Over the limit", 4, false, false, http.StatusRequestHeaderFieldsTooLarge},
+		{"Over the limit with bypass header", 3, true, false, http.StatusRequestHeaderFieldsTooLarge},
+		{"Over the limit in dry-run mode", 4, false, true, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeaders["X-Request"] = "configured"
+			cfg.BypassHeaders["X-Skip"] = ""
+			cfg.MaxRequestHeaders = 3
+			cfg.DryRun = tc.dryRun
+
+			called := false
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				called = true
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			for i := 0; i < tc.headerCount; i++ {
+				req.Header.Set("X-Header-"+strconv.Itoa(i), "value")
+			}
+			if tc.bypass {
+				req.Header.Set("X-Skip", "1")
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			if recorder.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, recorder.Code)
+			}
+			if called != (tc.expectedStatus == http.StatusOK) {
+				t.Errorf("Expected next to be called: %t, got %t", tc.expectedStatus == http.StatusOK, called)
+			}
+		})
+	}
+}
+
+func TestExcludeStatuses(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["X-Frame-Options"] = "DENY"
+	cfg.ExcludeStatuses = []int{http.StatusSwitchingProtocols}
+
+	testCases := []struct {
+		name       string
+		statusCode int
+		expected   string
+	}{
+		{"Switching Protocols is skipped", http.StatusSwitchingProtocols, ""},
+		{"OK is applied", http.StatusOK, "DENY"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(tc.statusCode)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			if recorder.Code != tc.statusCode {
+				t.Errorf("Expected status %d, got %d", tc.statusCode, recorder.Code)
+			}
+			assertResponseHeader(t, recorder, "X-Frame-Options", tc.expected)
+		})
+	}
+}
+
+func TestSuccessfulOnly(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["Cache-Control"] = "public, max-age=600"
+	cfg.SuccessfulOnly = true
+
+	testCases := []struct {
+		name       string
+		statusCode int
+		expected   string
+	}{
+		{"OK is applied", http.StatusOK, "public, max-age=600"},
+		{"No Content is applied", http.StatusNoContent, "public, max-age=600"},
+		{"Redirect is skipped", http.StatusFound, ""},
+		{"Not Found is skipped", http.StatusNotFound, ""},
+		{"Server error is skipped", http.StatusInternalServerError, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(tc.statusCode)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "Cache-Control", tc.expected)
+		})
+	}
+}
+
+func TestExcludeStatuses_InvalidCode(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ExcludeStatuses = []int{42}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+		t.Error("Expected an error for an invalid status code")
+	}
+}
+
+func TestDuplicateCanonicalHeaders(t *testing.T) {
+	testCases := []struct {
+		name      string
+		configure func(cfg *add_missing_headers.Config)
+	}{
+		{"Request headers", func(cfg *add_missing_headers.Config) {
+			cfg.RequestHeaders["x-custom-header"] = "first"
+			cfg.RequestHeaders["X-CUSTOM-HEADER"] = "second"
+		}},
+		{"Response headers", func(cfg *add_missing_headers.Config) {
+			cfg.ResponseHeaders["content-security-policy"] = "default-src 'self'"
+			cfg.ResponseHeaders["Content-Security-Policy"] = "default-src 'none'"
+		}},
+		{"Bypass headers", func(cfg *add_missing_headers.Config) {
+			cfg.BypassHeaders["x-skip"] = "true"
+			cfg.BypassHeaders["X-Skip"] = ""
+		}},
+		{"Require headers", func(cfg *add_missing_headers.Config) {
+			cfg.RequireHeaders["x-enable-headers"] = "1"
+			cfg.RequireHeaders["X-Enable-headers"] = "1"
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			tc.configure(cfg)
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+				t.Error("Expected an error for headers with the same canonical name")
+			}
+		})
+	}
+}
+
+func TestMixedCaseHeaderKeys(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["x-custom-header"] = "custom-value"
+	cfg.ResponseHeaders["x-frame-options"] = "DENY"
+	cfg.BypassHeaders["x-skip-processing"] = "true"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assertHeader(t, req, "X-Custom-Header", "custom-value")
+		rw.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	// The upstream value must be detected despite the lowercase config key
+	if values := recorder.Header().Values("X-Frame-Options"); len(values) != 1 || values[0] != "SAMEORIGIN" {
+		t.Errorf("Expected a single X-Frame-Options: SAMEORIGIN, got %q", values)
+	}
+}
+
 func assertHeader(t *testing.T, req *http.Request, key, expected string) {
 	t.Helper()
 	actual := req.Header.Get(key)
@@ -0,0 +1,57 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import "fmt"
+
+// presets are named sets of response headers, following the OWASP Secure Headers Project.
+var presets = map[string]map[string]string{
+	// owasp-basic is safe for most sites, it does not restrict which resources can be loaded.
+	"owasp-basic": {
+		"X-Content-Type-Options":            "nosniff",
+		"X-Frame-Options":                   "DENY",
+		"Referrer-Policy":                   "no-referrer",
+		"X-Permitted-Cross-Domain-Policies": "none",
+		"Cross-Origin-Opener-Policy":        "same-origin",
+	},
+	// owasp-strict extends owasp-basic with transport security and a restrictive content policy.
+	"owasp-strict": {
+		"X-Content-Type-Options":            "nosniff",
+		"X-Frame-Options":                   "DENY",
+		"Referrer-Policy":                   "no-referrer",
+		"X-Permitted-Cross-Domain-Policies": "none",
+		"Cross-Origin-Opener-Policy":        "same-origin",
+		"Cross-Origin-Embedder-Policy":      "require-corp",
+		"Cross-Origin-Resource-Policy":      "same-origin",
+		"Strict-Transport-Security":         "max-age=31536000; includeSubDomains",
+		"Content-Security-Policy":           "default-src 'self'; form-action 'self'; object-src 'none'; frame-ancestors 'none'; upgrade-insecure-requests; block-all-mixed-content",
+		"Permissions-Policy":                "accelerometer=(), camera=(), geolocation=(), gyroscope=(), magnetometer=(), microphone=(), payment=(), usb=()",
+	},
+}
+
+// expandPresets merges the response headers of the named presets, later presets winning.
+func expandPresets(names []string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, name := range names {
+		preset, ok := presets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown preset %q", name)
+		}
+		for key, value := range preset {
+			headers[key] = value
+		}
+	}
+	return headers, nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestPresets(t *testing.T) {
+	testCases := []struct {
+		name     string
+		presets  []string
+		expected map[string]string
+	}{
+		{
+			name:    "Basic",
+			presets: []string{"owasp-basic"},
+			expected: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "SAMEORIGIN", // User value wins
+				"Referrer-Policy":           "no-referrer",
+				"Strict-Transport-Security": "",
+			},
+		},
+		{
+			name:    "Strict",
+			presets: []string{"owasp-strict"},
+			expected: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "SAMEORIGIN",
+				"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.Presets = tc.presets
+			cfg.ResponseHeaders["x-frame-options"] = "SAMEORIGIN"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			for name, value := range tc.expected {
+				assertResponseHeader(t, recorder, name, value)
+			}
+		})
+	}
+}
+
+func TestPresets_Unknown(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.Presets = []string{"owasp-basic", "paranoid"}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+		t.Error("expected an error for an unknown preset")
+	}
+}
+
+func TestPresets_HostHeaders(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.Presets = []string{"owasp-basic"}
+	cfg.HostHeaders = map[string]map[string]string{
+		"shop.example.com": {"X-Frame-Options": "SAMEORIGIN"},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://shop.example.com", nil)
+
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	assertResponseHeader(t, recorder, "X-Frame-Options", "SAMEORIGIN")
+	assertResponseHeader(t, recorder, "X-Content-Type-Options", "nosniff")
+}
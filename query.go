@@ -0,0 +1,100 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// QueryCondition adds headers when a query string parameter is present or matches a value.
+// An empty Value only checks for the presence of the parameter.
+type QueryCondition struct {
+	Param           string            `json:"param,omitempty" yaml:"param,omitempty"`
+	Value           string            `json:"value,omitempty" yaml:"value,omitempty"`
+	RequestHeaders  map[string]string `json:"requestHeaders,omitempty" yaml:"requestHeaders,omitempty"`
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty" yaml:"responseHeaders,omitempty"`
+}
+
+// queryCondition is a compiled QueryCondition.
+type queryCondition struct {
+	param           string
+	value           string
+	requestHeaders  []headerEntry
+	responseHeaders []headerEntry
+}
+
+// compileQueryConditions compiles query conditions, keeping the configured order.
+func compileQueryConditions(conditions []QueryCondition) ([]queryCondition, error) {
+	compiled := make([]queryCondition, 0, len(conditions))
+	for i, c := range conditions {
+		if c.Param == "" {
+			return nil, fmt.Errorf("condition %d: missing param", i)
+		}
+
+		requestHeaders, err := compileHeaders(c.RequestHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("condition %d: requestHeaders: %w", i, err)
+		}
+
+		responseHeaders, err := compileHeaders(c.ResponseHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("condition %d: responseHeaders: %w", i, err)
+		}
+
+		compiled = append(compiled, queryCondition{
+			param:           c.Param,
+			value:           c.Value,
+			requestHeaders:  requestHeaders,
+			responseHeaders: responseHeaders,
+		})
+	}
+	return compiled, nil
+}
+
+// matchQueryConditions returns the query conditions matched by the request.
+// The query string is only parsed when conditions are configured.
+func (p *Plugin) matchQueryConditions(req *http.Request) []*queryCondition {
+	if len(p.queryConditions) == 0 {
+		return nil
+	}
+
+	query := req.URL.Query()
+
+	var matched []*queryCondition
+	for i := range p.queryConditions {
+		c := &p.queryConditions[i]
+		values, ok := query[c.param]
+		if !ok {
+			continue
+		}
+
+		// Repeated parameters match if any of their values does
+		if c.value == "" || containsValue(values, c.value) {
+			matched = append(matched, c)
+		}
+	}
+	return matched
+}
+
+// containsValue reports whether values contains value.
+func containsValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
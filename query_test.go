@@ -0,0 +1,85 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestQueryConditions(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.QueryConditions = []add_missing_headers.QueryCondition{
+		{
+			Param:           "debug",
+			Value:           "1",
+			RequestHeaders:  map[string]string{"X-Debug-Mode": "on"},
+			ResponseHeaders: map[string]string{"X-Debug-Response": "on"},
+		},
+		{
+			Param:          "trace",
+			RequestHeaders: map[string]string{"X-Trace": "1"},
+		},
+	}
+
+	testCases := []struct {
+		name             string
+		query            string
+		expectedDebug    string
+		expectedResponse string
+		expectedTrace    string
+	}{
+		{"Matching value", "?debug=1", "on", "on", ""},
+		{"Repeated key with matching value", "?debug=0&debug=1", "on", "on", ""},
+		{"Wrong value", "?debug=0", "", "", ""},
+		{"Presence only", "?trace", "", "", "1"},
+		{"Both conditions", "?trace=yes&debug=1", "on", "on", "1"},
+		{"No query", "", "", "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				assertHeader(t, req, "X-Debug-Mode", tc.expectedDebug)
+				assertHeader(t, req, "X-Trace", tc.expectedTrace)
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/"+tc.query, nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "X-Debug-Response", tc.expectedResponse)
+		})
+	}
+}
+
+func TestQueryConditions_MissingParam(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.QueryConditions = []add_missing_headers.QueryCondition{
+		{Value: "1", RequestHeaders: map[string]string{"X-Debug-Mode": "on"}},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+		t.Error("Expected an error for a condition without param")
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import "net/http"
+
+// serveNext calls the next handler with the wrapped response, recovering its panics when enabled.
+func (p *Plugin) serveNext(rm *responseModifier, req *http.Request) {
+	if p.recoverPanics {
+		defer p.recoverPanic(rm, req)
+	}
+	p.next.ServeHTTP(rm, req)
+}
+
+// recoverPanic recovers a panic of the next handler, answering with a 500 carrying the
+// configured headers when nothing was written yet.
+func (p *Plugin) recoverPanic(rm *responseModifier, req *http.Request) {
+	v := recover()
+	if v == nil {
+		return
+	}
+
+	// Aborting is how handlers deliberately drop a response, let the server handle it
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+
+	p.logf("recovered panic serving %s %s: %v", req.Method, req.URL.Path, v)
+
+	if !rm.headersSent && !rm.hijacked {
+		rm.WriteHeader(http.StatusInternalServerError)
+	}
+
+	if p.repanicAfterRecover {
+		rm.finish()
+		panic(v)
+	}
+}
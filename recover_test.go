@@ -0,0 +1,100 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	testCases := []struct {
+		name           string
+		recoverPanics  bool
+		repanic        bool
+		writeFirst     bool
+		expectPanic    bool
+		expectedStatus int
+		expectedHeader string
+	}{
+		{"Disabled", false, false, false, true, http.StatusOK, ""},
+		{"Recovered before WriteHeader", true, false, false, false, http.StatusInternalServerError, "DENY"},
+		{"Recovered after WriteHeader", true, false, true, false, http.StatusAccepted, "DENY"},
+		{"Recovered and repanicked", true, true, false, true, http.StatusInternalServerError, "DENY"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			defer add_missing_headers.SetLogOutput(&logs)()
+
+			cfg := add_missing_headers.CreateConfig()
+			cfg.ResponseHeaders["X-Frame-Options"] = "DENY"
+			cfg.RecoverPanics = tc.recoverPanics
+			cfg.RepanicAfterRecover = tc.repanic
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tc.writeFirst {
+					rw.WriteHeader(http.StatusAccepted)
+				}
+				panic("upstream failure")
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost/path", nil)
+
+			panicked := func() (panicked bool) {
+				defer func() { panicked = recover() != nil }()
+				newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+				return false
+			}()
+
+			if panicked != tc.expectPanic {
+				t.Errorf("Expected panic: %t, got %t", tc.expectPanic, panicked)
+			}
+			if recorder.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, recorder.Code)
+			}
+			assertResponseHeader(t, recorder, "X-Frame-Options", tc.expectedHeader)
+			if logged := strings.Contains(logs.String(), "recovered panic serving GET /path: upstream failure"); logged != tc.recoverPanics {
+				t.Errorf("Expected logged panic: %t, got logs %q", tc.recoverPanics, logs.String())
+			}
+		})
+	}
+}
+
+func TestRecoverPanics_AbortHandler(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RecoverPanics = true
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("Expected http.ErrAbortHandler to propagate, got %v", v)
+		}
+	}()
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+}
@@ -0,0 +1,76 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+)
+
+// defaultRequestIDHeader is the request header carrying generated request IDs.
+const defaultRequestIDHeader = "X-Request-Id"
+
+// randReader is the source of request IDs, it is a variable so tests can replace it.
+var randReader io.Reader = rand.Reader
+
+// newRequestID returns a random UUID version 4, as described in RFC 9562.
+func newRequestID() (string, error) {
+	var uuid [16]byte
+	if _, err := io.ReadFull(randReader, uuid[:]); err != nil {
+		return "", err
+	}
+	uuid[6] = uuid[6]&0x0f | 0x40 // Version 4
+	uuid[8] = uuid[8]&0x3f | 0x80 // RFC 9562 variant
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], uuid[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], uuid[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], uuid[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], uuid[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], uuid[10:])
+	return string(buf[:]), nil
+}
+
+// addRequestID sets a generated ID on requests missing one.
+// When no random bytes can be read, the request is forwarded without an ID.
+func (p *Plugin) addRequestID(header http.Header) {
+	if !p.shouldAddHeader(header, p.requestIDHeader) {
+		return
+	}
+
+	id, err := newRequestID()
+	if err != nil {
+		p.logf("failed to generate a request ID: %v", err)
+		return
+	}
+	header.Set(p.requestIDHeader, id)
+}
+
+// addEchoedRequestID copies the ID forwarded with the request to the response, when missing.
+func (p *Plugin) addEchoedRequestID(header http.Header, req *http.Request) {
+	id := req.Header.Get(p.requestIDHeader)
+	if id == "" || !p.shouldAddHeader(header, p.requestIDHeader) {
+		return
+	}
+	if id, ok := p.checkValue(p.requestIDHeader, id); ok {
+		header.Set(p.requestIDHeader, id)
+	}
+}
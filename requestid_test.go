@@ -0,0 +1,113 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+var uuidV4 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// serveRequestID serves a request carrying the given ID, and returns the ID forwarded upstream.
+func serveRequestID(t *testing.T, cfg *add_missing_headers.Config, header, id string) (string, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	var forwarded string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded = req.Header.Get(header)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	if id != "" {
+		req.Header.Set(header, id)
+	}
+
+	recorder := httptest.NewRecorder()
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+	return forwarded, recorder
+}
+
+func TestGenerateRequestID(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.GenerateRequestID = true
+	cfg.EchoRequestID = true
+
+	t.Run("Absent", func(t *testing.T) {
+		first, recorder := serveRequestID(t, cfg, "X-Request-Id", "")
+		if !uuidV4.MatchString(first) {
+			t.Errorf("Expected a UUIDv4, got %q", first)
+		}
+		assertResponseHeader(t, recorder, "X-Request-Id", first)
+
+		if second, _ := serveRequestID(t, cfg, "X-Request-Id", ""); second == first {
+			t.Errorf("Expected a new ID for every request, got %q twice", first)
+		}
+	})
+
+	t.Run("Present", func(t *testing.T) {
+		forwarded, recorder := serveRequestID(t, cfg, "X-Request-Id", "client-id")
+		if forwarded != "client-id" {
+			t.Errorf("Expected the client ID to be kept, got %q", forwarded)
+		}
+		assertResponseHeader(t, recorder, "X-Request-Id", "client-id")
+	})
+
+	t.Run("Not echoed", func(t *testing.T) {
+		cfg := add_missing_headers.CreateConfig()
+		cfg.GenerateRequestID = true
+
+		forwarded, recorder := serveRequestID(t, cfg, "X-Request-Id", "")
+		if !uuidV4.MatchString(forwarded) {
+			t.Errorf("Expected a UUIDv4, got %q", forwarded)
+		}
+		assertResponseHeader(t, recorder, "X-Request-Id", "")
+	})
+
+	t.Run("Custom header", func(t *testing.T) {
+		cfg := add_missing_headers.CreateConfig()
+		cfg.GenerateRequestID = true
+		cfg.RequestIDHeader = "x-correlation-id"
+
+		forwarded, _ := serveRequestID(t, cfg, "X-Correlation-Id", "")
+		if !uuidV4.MatchString(forwarded) {
+			t.Errorf("Expected a UUIDv4, got %q", forwarded)
+		}
+	})
+}
+
+func TestGenerateRequestID_RandomFailure(t *testing.T) {
+	var logs bytes.Buffer
+	defer add_missing_headers.SetLogOutput(&logs)()
+	defer add_missing_headers.SetRandReader(iotest.ErrReader(errors.New("no entropy")))()
+
+	cfg := add_missing_headers.CreateConfig()
+	cfg.GenerateRequestID = true
+
+	if forwarded, _ := serveRequestID(t, cfg, "X-Request-Id", ""); forwarded != "" {
+		t.Errorf("Expected no request ID, got %q", forwarded)
+	}
+	if !strings.Contains(logs.String(), "no entropy") {
+		t.Errorf("Expected the failure to be logged, got %q", logs.String())
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// sizeRule adds request headers to requests whose body is at least minBytes long.
+type sizeRule struct {
+	minBytes       int64
+	requestHeaders []headerEntry
+}
+
+// compileSizeRules compiles request size headers keyed by a threshold in bytes,
+// sorted from the largest threshold down so that the most specific rule wins.
+func compileSizeRules(config map[string]map[string]string) ([]sizeRule, error) {
+	rules := make([]sizeRule, 0, len(config))
+	for key, headers := range config {
+		minBytes, err := strconv.ParseInt(key, 10, 64)
+		if err != nil || minBytes < 0 {
+			return nil, fmt.Errorf("invalid size %q", key)
+		}
+
+		requestHeaders, err := compileHeaders(headers)
+		if err != nil {
+			return nil, fmt.Errorf("size %d: %w", minBytes, err)
+		}
+
+		rules = append(rules, sizeRule{minBytes: minBytes, requestHeaders: requestHeaders})
+	}
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].minBytes > rules[j].minBytes })
+
+	return rules, nil
+}
+
+// addSizeHeaders adds the missing headers of every rule whose threshold the request's
+// Content-Length reaches. Requests of unknown length match no rule.
+func (p *Plugin) addSizeHeaders(req *http.Request, data *templateData) {
+	if req.ContentLength < 0 {
+		return
+	}
+	for _, r := range p.sizeRules {
+		if req.ContentLength >= r.minBytes {
+			p.addMissingHeaders(req.Header, r.requestHeaders, data)
+		}
+	}
+}
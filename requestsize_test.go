@@ -0,0 +1,85 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestRequestSizeHeaders(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestSizeHeaders = map[string]map[string]string{
+		"1048576":  {"X-Large-Upload": "true", "X-Upload-Class": "large"},
+		"10485760": {"X-Upload-Class": "huge"},
+	}
+
+	testCases := []struct {
+		name          string
+		contentLength int64
+		largeUpload   string
+		uploadClass   string
+	}{
+		{"Small", 100, "", ""},
+		{"Threshold", 1048576, "true", "large"},
+		{"Large", 5 << 20, "true", "large"},
+		{"Larger threshold wins", 20 << 20, "true", "huge"},
+		{"Unknown length", -1, "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var req *http.Request
+			next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				req = r
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "http://localhost", nil)
+			r.ContentLength = tc.contentLength
+
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), r)
+
+			assertHeader(t, req, "X-Large-Upload", tc.largeUpload)
+			assertHeader(t, req, "X-Upload-Class", tc.uploadClass)
+		})
+	}
+}
+
+func TestRequestSizeHeaders_Invalid(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config map[string]map[string]string
+	}{
+		{"Not a number", map[string]map[string]string{"1MB": {"X-Large-Upload": "true"}}},
+		{"Negative", map[string]map[string]string{"-1": {"X-Large-Upload": "true"}}},
+		{"Invalid header", map[string]map[string]string{"1024": {"X Large": "true"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestSizeHeaders = tc.config
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,628 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// responseModifier wraps http.ResponseWriter to add missing response headers.
+type responseModifier struct {
+	rw              http.ResponseWriter
+	flusher         http.Flusher
+	plugin          *Plugin
+	req             *http.Request
+	responseHeaders []headerEntry
+	dryRunHeaders   []string
+	captures        []string
+	gzipWriter      *gzip.Writer
+	flushWrites     bool
+	headersSent     bool
+	hijacked        bool
+	code            int
+	// injected lists the request headers added by the request phase, for the audit header.
+	injected []string
+	// varyOnly is set for requests passed through untouched, only Vary is added to their response.
+	varyOnly bool
+}
+
+// responseModifierPool recycles response modifiers across requests.
+var responseModifierPool = sync.Pool{
+	New: func() interface{} { return new(responseModifier) },
+}
+
+// newResponseModifier creates a new response modifier for the given request.
+// The modifier comes from a pool and must be released once the response is finished.
+func newResponseModifier(p *Plugin, req *http.Request, responseHeaders []headerEntry, w http.ResponseWriter) *responseModifier {
+	rm := responseModifierPool.Get().(*responseModifier)
+	// Overwrite every field so nothing leaks from the previous request
+	*rm = responseModifier{
+		rw:              w,
+		code:            http.StatusOK,
+		plugin:          p,
+		req:             req,
+		responseHeaders: responseHeaders,
+	}
+
+	// Check if the underlying ResponseWriter supports flushing
+	if f, ok := w.(http.Flusher); ok {
+		rm.flusher = f
+	}
+
+	return rm
+}
+
+// release returns the modifier to the pool, it must not be used afterwards.
+// Fields are cleared so the pool doesn't keep requests and writers alive.
+func (r *responseModifier) release() {
+	*r = responseModifier{}
+	responseModifierPool.Put(r)
+}
+
+// Header returns the header map that will be sent by WriteHeader.
+// The underlying map is returned as-is so that trailers declared via the "Trailer"
+// header and set after the body has been written still reach the client.
+func (r *responseModifier) Header() http.Header {
+	return r.rw.Header()
+}
+
+// WriteHeader sends an HTTP response header with the provided status code.
+// The status is overridden first, then upstream headers are rewritten and removed
+// before missing headers are added, see modifyHeaders.
+func (r *responseModifier) WriteHeader(code int) {
+	if r.headersSent {
+		return
+	}
+
+	// Informational responses are forwarded untouched, headers are added to the final one
+	if informational(code) {
+		r.rw.WriteHeader(code)
+		return
+	}
+
+	if r.varyOnly {
+		addVary(r.rw.Header(), r.plugin.varyHeaders...)
+		r.rw.WriteHeader(code)
+		r.code = code
+		r.headersSent = true
+		return
+	}
+
+	// The upstream's content type is known from here on. Responses to HEAD requests are
+	// never flushed, so the server can still derive Content-Length from the discarded body.
+	r.flushWrites = !r.plugin.disableExplicitFlush && r.req.Method != http.MethodHead && isStreaming(r.rw.Header())
+
+	if r.plugin.dryRun {
+		r.recordDryRun(code)
+	} else {
+		code = r.overrideStatus(code)
+		r.modifyHeaders(r.rw.Header(), code)
+		r.startGzip(code)
+		if r.plugin.responseHook != nil {
+			r.plugin.responseHook(code, r.rw.Header())
+		}
+	}
+	r.rw.WriteHeader(code)
+
+	r.code = code
+	r.headersSent = true
+}
+
+// informational reports whether code is a 1xx status preceding the final response.
+// 101 Switching Protocols is final.
+func informational(code int) bool {
+	return code >= 100 && code < 200 && code != http.StatusSwitchingProtocols
+}
+
+// overrideStatus returns the configured replacement for an upstream status code,
+// recording the original code when a header is configured for it.
+func (r *responseModifier) overrideStatus(code int) int {
+	override, ok := r.plugin.overrideStatusCodes[code]
+	if !ok || override == code {
+		return code
+	}
+
+	if r.plugin.originalStatusHeader != "" {
+		r.rw.Header().Set(r.plugin.originalStatusHeader, strconv.Itoa(code))
+	}
+	return override
+}
+
+// modifyHeaders applies all response header modifications to header,
+// unless the upstream marked the response to be left untouched.
+func (r *responseModifier) modifyHeaders(header http.Header, code int) {
+	if r.plugin.skipsResponse(header) {
+		// The response still depends on the request headers consulted by conditions
+		addVary(header, r.plugin.varyHeaders...)
+		return
+	}
+
+	// Rewrites only apply to the upstream's headers
+	r.plugin.rewriteHeaders(header)
+	r.addMissingResponseHeaders(header, code)
+	addVary(header, r.plugin.varyHeaders...)
+
+	if r.plugin.emitFlushMode {
+		header.Set(flushModeHeader, r.flushMode())
+	}
+
+	// Chained instances each add their own name
+	if r.plugin.processedHeader != "" {
+		header.Add(r.plugin.processedHeader, r.plugin.name)
+	}
+
+	if r.plugin.routerHeader != "" {
+		r.plugin.addRouterHeader(header, r.req)
+	}
+
+	if r.plugin.echoRequestID {
+		r.plugin.addEchoedRequestID(header, r.req)
+	}
+
+	if r.plugin.auditHeader != "" {
+		r.plugin.addAuditHeader(header, r.injected)
+	}
+}
+
+// skipsResponse reports whether the response headers match any skipIfResponseHeaderPresent condition.
+func (p *Plugin) skipsResponse(header http.Header) bool {
+	for _, m := range p.skipResponseMatchers {
+		if m.matches(header) {
+			return true
+		}
+	}
+	return false
+}
+
+// flushMode returns the effective flush mode for this response, "explicit" or "disabled".
+func (r *responseModifier) flushMode() string {
+	if r.explicitFlush() {
+		return "explicit"
+	}
+	return "disabled"
+}
+
+// explicitFlush reports whether every write is followed by a flush.
+func (r *responseModifier) explicitFlush() bool {
+	return r.flushWrites
+}
+
+// nestedFlushes reports whether the underlying writer is the response modifier of another
+// instance of this middleware that already flushes after every write. Only the modifier
+// closest to the connection flushes then, so chained instances don't flush twice.
+func (r *responseModifier) nestedFlushes() bool {
+	inner, ok := r.rw.(*responseModifier)
+	return ok && inner.explicitFlush()
+}
+
+// isStreaming reports whether the response headers describe a streaming response,
+// Server-Sent Events or an explicitly chunked body, whose writes must reach the client right away.
+func isStreaming(header http.Header) bool {
+	mediaType, _, _ := strings.Cut(header.Get("Content-Type"), ";")
+	if strings.EqualFold(strings.TrimSpace(mediaType), "text/event-stream") {
+		return true
+	}
+	return headerHasToken(header, "Transfer-Encoding", "chunked")
+}
+
+// recordDryRun lists the request and response headers that would have changed,
+// without modifying the response.
+func (r *responseModifier) recordDryRun(code int) {
+	header := r.rw.Header()
+	shadow := header.Clone()
+	r.modifyHeaders(shadow, code)
+
+	names := append(append([]string(nil), r.dryRunHeaders...), changedHeaders(header, shadow)...)
+	if len(names) == 0 {
+		return
+	}
+
+	// Deduplicate headers changed in both phases
+	sort.Strings(names)
+	unique := names[:1]
+	for _, name := range names[1:] {
+		if name != unique[len(unique)-1] {
+			unique = append(unique, name)
+		}
+	}
+
+	header.Set(dryRunHeader, strings.Join(unique, ","))
+}
+
+// addMissingResponseHeaders adds missing headers to the response, unless the status code is excluded,
+// not successful when only successful responses get headers, or the request scheme isn't the required one.
+// A stripped Server header counts as missing, so a configured value replaces it.
+func (r *responseModifier) addMissingResponseHeaders(header http.Header, code int) {
+	// Only headers set before WriteHeader can be removed, layers in front of
+	// this middleware may still add their own Server header afterwards
+	if r.plugin.stripServerHeader {
+		header.Del("Server")
+	}
+	// Without a length, the response is sent chunked over HTTP/1.1
+	if r.plugin.dropContentLength {
+		header.Del("Content-Length")
+	}
+	r.plugin.removePrefixedHeaders(header)
+	r.plugin.removeMatchingHeaders(header)
+
+	// Removals run before additions, so a rule can replace an upstream value
+	rules := r.plugin.matchStatusRules(code)
+	removeStatusHeaders(header, rules)
+
+	if r.plugin.excludeStatuses[code] || (r.plugin.successfulOnly && (code < 200 || code > 299)) || !r.plugin.schemeMatches(r.req) {
+		return
+	}
+
+	// Derived headers and templates only see the headers set by the upstream
+	data := r.plugin.newTemplateData(r.req, header)
+	data.Status = code
+	data.captures = r.captures
+	r.plugin.addDerivedHeaders(header, data)
+
+	// Status rules are more specific than the headers selected for the request
+	for _, rule := range rules {
+		r.plugin.addMissingHeaders(header, r.plugin.withoutEncodingConflicts(header, rule.responseHeaders), data)
+	}
+
+	headers := r.plugin.withoutUnmetConditions(r.req, r.responseHeaders)
+	headers = r.plugin.withoutEncodingConflicts(header, headers)
+	r.plugin.addMissingHeaders(header, r.plugin.withoutDependents(header, headers), data)
+}
+
+// removePrefixedHeaders deletes the headers whose name starts with a configured prefix.
+func (p *Plugin) removePrefixedHeaders(header http.Header) {
+	if len(p.removePrefixes) == 0 {
+		return
+	}
+
+	for key := range header {
+		name := strings.ToLower(key)
+		for _, prefix := range p.removePrefixes {
+			if strings.HasPrefix(name, prefix) {
+				delete(header, key)
+				break
+			}
+		}
+	}
+}
+
+// removeMatchingHeaders deletes the headers whose name matches a configured glob pattern.
+func (p *Plugin) removeMatchingHeaders(header http.Header) {
+	if len(p.removePatterns) == 0 {
+		return
+	}
+
+	for key := range header {
+		name := strings.ToLower(key)
+		for _, pattern := range p.removePatterns {
+			// Patterns were validated in New
+			if matched, _ := path.Match(pattern, name); matched {
+				delete(header, key)
+				break
+			}
+		}
+	}
+}
+
+// withoutDependents returns the headers, leaving out those whose dependency header is
+// already set on the response.
+func (p *Plugin) withoutDependents(header http.Header, headers []headerEntry) []headerEntry {
+	if len(p.headerDependencies) == 0 {
+		return headers
+	}
+
+	return filterHeaders(headers, func(entry headerEntry) bool {
+		dependency, ok := p.headerDependencies[entry.key]
+		return !ok || header.Values(dependency) == nil
+	})
+}
+
+// withoutEncodingConflicts returns the headers, leaving out those listed in skipWhenEncoded
+// when the upstream encoded the response.
+func (p *Plugin) withoutEncodingConflicts(header http.Header, headers []headerEntry) []headerEntry {
+	if len(p.skipWhenEncoded) == 0 || !isEncoded(header) {
+		return headers
+	}
+
+	return filterHeaders(headers, func(entry headerEntry) bool {
+		return !p.skipWhenEncoded[entry.key]
+	})
+}
+
+// isEncoded reports whether the response has a Content-Encoding other than identity.
+func isEncoded(header http.Header) bool {
+	encoding := strings.TrimSpace(header.Get("Content-Encoding"))
+	return encoding != "" && !strings.EqualFold(encoding, "identity")
+}
+
+// withoutUnmetConditions returns the headers, leaving out those whose request conditions
+// don't match the request.
+func (p *Plugin) withoutUnmetConditions(req *http.Request, headers []headerEntry) []headerEntry {
+	if len(p.requestConditions) == 0 {
+		return headers
+	}
+
+	return filterHeaders(headers, func(entry headerEntry) bool {
+		for _, m := range p.requestConditions[entry.key] {
+			if !m.matches(req.Header) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// requestConditionHeaders returns the request headers consulted by responseHeaderRequestConditions, sorted.
+func requestConditionHeaders(conditions map[string][]headerMatcher) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, matchers := range conditions {
+		for _, m := range matchers {
+			if !seen[m.name] {
+				seen[m.name] = true
+				names = append(names, m.name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// filterHeaders returns the headers for which keep returns true.
+func filterHeaders(headers []headerEntry, keep func(headerEntry) bool) []headerEntry {
+	var filtered []headerEntry
+	for i, entry := range headers {
+		if !keep(entry) {
+			// Copy on the first skipped header, the configured slice is shared
+			if filtered == nil {
+				filtered = append(make([]headerEntry, 0, len(headers)), headers[:i]...)
+			}
+			continue
+		}
+		if filtered != nil {
+			filtered = append(filtered, entry)
+		}
+	}
+
+	if filtered == nil {
+		return headers
+	}
+	return filtered
+}
+
+// addVary adds header names to Vary, skipping names that are already listed.
+func addVary(header http.Header, names ...string) {
+	if len(names) == 0 {
+		return
+	}
+
+	// Collect the header names already listed in Vary
+	listed := make(map[string]bool)
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				// Already varies on everything
+				return
+			}
+			listed[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+
+	var missing []string
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		if !listed[name] {
+			listed[name] = true
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		header.Add("Vary", strings.Join(missing, ", "))
+	}
+}
+
+// startGzip switches the response to gzip encoding when enabled and applicable.
+func (r *responseModifier) startGzip(code int) {
+	if !r.plugin.enableGzip || !bodyAllowed(r.req, code) || code == http.StatusPartialContent {
+		return
+	}
+
+	// Byte ranges refer to the identity body, and no-transform forbids changing the encoding
+	header := r.rw.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" || forbidsTransform(header) {
+		return
+	}
+	if !r.plugin.isCompressible(header.Get("Content-Type")) {
+		return
+	}
+
+	// The representation depends on Accept-Encoding from here on
+	addVary(header, "Accept-Encoding")
+	if !acceptsGzip(r.req) {
+		return
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	r.gzipWriter = gzip.NewWriter(r.rw)
+}
+
+// Write writes the data to the connection as part of an HTTP reply.
+func (r *responseModifier) Write(b []byte) (int, error) {
+	r.WriteHeader(r.code)
+
+	var n int
+	var err error
+	if r.gzipWriter != nil {
+		n, err = r.gzipWriter.Write(b)
+	} else {
+		n, err = r.rw.Write(b)
+	}
+
+	// Explicitly flush after write if enabled and supported.
+	// Flushing switches to chunked encoding, which is also what trailers require.
+	if r.explicitFlush() && r.flusher != nil && !r.nestedFlushes() {
+		r.Flush()
+	}
+
+	return n, err
+}
+
+// ReadFrom copies src to the response, letting the underlying ResponseWriter use
+// sendfile when it implements io.ReaderFrom and the body is not compressed.
+func (r *responseModifier) ReadFrom(src io.Reader) (int64, error) {
+	r.WriteHeader(r.code)
+
+	var n int64
+	var err error
+	if readerFrom, ok := r.rw.(io.ReaderFrom); ok && r.gzipWriter == nil {
+		n, err = readerFrom.ReadFrom(src)
+	} else {
+		// Hide ReadFrom so io.Copy doesn't call back into this method
+		n, err = io.Copy(writerOnly{r}, src)
+	}
+
+	if r.explicitFlush() && r.flusher != nil && !r.nestedFlushes() {
+		r.Flush()
+	}
+
+	return n, err
+}
+
+// writerOnly hides every method of a writer except Write.
+type writerOnly struct {
+	io.Writer
+}
+
+// Hijack hijacks the connection if the underlying ResponseWriter supports hijacking.
+func (r *responseModifier) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.rw.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("responseWriter does not support hijacking: %T", r.rw)
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		r.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Push initiates an HTTP/2 server push if the underlying ResponseWriter supports it.
+func (r *responseModifier) Push(target string, opts *http.PushOptions) error {
+	pusher, ok := r.rw.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return pusher.Push(target, opts)
+}
+
+// Flush sends any buffered data to the client if flushing is supported.
+func (r *responseModifier) Flush() {
+	if r.gzipWriter != nil {
+		// Push compressed data to the underlying writer before flushing it
+		_ = r.gzipWriter.Flush()
+	}
+
+	if r.flusher != nil {
+		r.flusher.Flush()
+		return
+	}
+
+	// Only warn once per instance, streaming handlers flush on every event
+	if r.plugin.warnUnflushable && atomic.CompareAndSwapUint32(&r.plugin.unflushableWarned, 0, 1) {
+		r.plugin.logf("flush requested for %s %s, but %T doesn't support flushing", r.req.Method, r.req.URL.Path, r.rw)
+	}
+}
+
+// CanFlush reports whether the underlying ResponseWriter supports flushing.
+// When it doesn't, Flush does nothing and streamed responses are buffered.
+func (r *responseModifier) CanFlush() bool {
+	return r.flusher != nil
+}
+
+// SetReadDeadline sets the read deadline of the underlying ResponseWriter, returning
+// http.ErrNotSupported when it has none.
+func (r *responseModifier) SetReadDeadline(deadline time.Time) error {
+	return http.NewResponseController(r.rw).SetReadDeadline(deadline)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying ResponseWriter, returning
+// http.ErrNotSupported when it has none.
+func (r *responseModifier) SetWriteDeadline(deadline time.Time) error {
+	return http.NewResponseController(r.rw).SetWriteDeadline(deadline)
+}
+
+// Unwrap returns the underlying ResponseWriter, letting http.ResponseController reach
+// features the modifier doesn't implement, such as deadlines. Writes made directly to the
+// underlying writer bypass the modifier, and get no added headers.
+func (r *responseModifier) Unwrap() http.ResponseWriter {
+	return r.rw
+}
+
+// finish completes the response once the next handler has returned.
+func (r *responseModifier) finish() {
+	// The connection belongs to the handler after a successful hijack
+	if r.hijacked {
+		return
+	}
+
+	// Handlers that return without writing anything still get the configured headers
+	if !r.headersSent {
+		r.WriteHeader(r.code)
+	}
+
+	r.checkRequiredHeaders()
+
+	if r.gzipWriter != nil {
+		// Closing writes the gzip footer, it must happen after the last Write
+		_ = r.gzipWriter.Close()
+	}
+}
+
+// checkRequiredHeaders logs the required response headers missing from the written response.
+func (r *responseModifier) checkRequiredHeaders() {
+	var missing []string
+	for _, name := range r.plugin.requireResponseHeaders {
+		if r.rw.Header().Values(name) == nil {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		r.plugin.logf("response to %s %s is missing required headers: %s", r.req.Method, r.req.URL.Path, strings.Join(missing, ", "))
+	}
+}
+
+// bodyAllowed reports whether a response with the given status may carry a body.
+func bodyAllowed(req *http.Request, code int) bool {
+	if req.Method == http.MethodHead {
+		return false
+	}
+	return code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified
+}
@@ -0,0 +1,78 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+)
+
+// HeaderRewrite replaces the matches of a regular expression in a header value.
+// Replacement can reference capture groups with $1 or ${name}.
+type HeaderRewrite struct {
+	Pattern     string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Replacement string `json:"replacement,omitempty" yaml:"replacement,omitempty"`
+}
+
+// headerRewrite is a compiled HeaderRewrite.
+type headerRewrite struct {
+	key         string
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// compileHeaderRewrites compiles rewrites, sorted by header name.
+func compileHeaderRewrites(config map[string]HeaderRewrite) ([]headerRewrite, error) {
+	seen := make(map[string]string, len(config))
+	rewrites := make([]headerRewrite, 0, len(config))
+	for key, rw := range config {
+		canonicalKey, err := canonicalHeaderName(seen, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if rw.Pattern == "" {
+			return nil, fmt.Errorf("header %q: missing pattern", key)
+		}
+		pattern, err := regexp.Compile(rw.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("header %q: %w: %w", key, ErrInvalidRegex, err)
+		}
+
+		rewrites = append(rewrites, headerRewrite{key: canonicalKey, pattern: pattern, replacement: rw.Replacement})
+	}
+
+	sort.Slice(rewrites, func(i, j int) bool { return rewrites[i].key < rewrites[j].key })
+
+	return rewrites, nil
+}
+
+// rewriteHeaders rewrites the values of existing headers, values without a match are kept.
+// A rewritten value the sanitization skips also keeps the original value.
+func (p *Plugin) rewriteHeaders(header http.Header) {
+	for _, rw := range p.headerRewrites {
+		values := header.Values(rw.key)
+		for i, value := range values {
+			if !rw.pattern.MatchString(value) {
+				continue
+			}
+			if rewritten, ok := p.sanitizeValue(rw.key, rw.pattern.ReplaceAllString(value, rw.replacement)); ok {
+				values[i] = rewritten
+			}
+		}
+	}
+}
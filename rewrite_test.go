@@ -0,0 +1,85 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestResponseHeaderRewrites(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaderRewrites = map[string]add_missing_headers.HeaderRewrite{
+		"location": {
+			Pattern:     `^https?://internal\.local(:\d+)?(/.*)?$`,
+			Replacement: "https://www.example.com$2",
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		location string
+		expected string
+	}{
+		{"Internal host", "http://internal.local:8080/login?next=/", "https://www.example.com/login?next=/"},
+		{"Internal host without path", "http://internal.local", "https://www.example.com"},
+		{"No match is untouched", "https://other.example.org/login", "https://other.example.org/login"},
+		{"Absent header", "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tc.location != "" {
+					rw.Header().Set("Location", tc.location)
+				}
+				rw.WriteHeader(http.StatusFound)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "Location", tc.expected)
+		})
+	}
+}
+
+func TestResponseHeaderRewrites_Invalid(t *testing.T) {
+	testCases := []struct {
+		name     string
+		rewrites map[string]add_missing_headers.HeaderRewrite
+	}{
+		{"Missing pattern", map[string]add_missing_headers.HeaderRewrite{"Location": {Replacement: "x"}}},
+		{"Invalid pattern", map[string]add_missing_headers.HeaderRewrite{"Location": {Pattern: "(", Replacement: "x"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.ResponseHeaderRewrites = tc.rewrites
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
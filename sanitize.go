@@ -0,0 +1,49 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import "strings"
+
+const (
+	// unsafeValueSkip skips headers whose value contains a line break, and logs them.
+	unsafeValueSkip = "skip"
+	// unsafeValueStrip removes line breaks from values.
+	unsafeValueStrip = "strip"
+)
+
+// lineBreakRemover removes the characters that could split a header.
+var lineBreakRemover = strings.NewReplacer("\r", "", "\n", "")
+
+// checkValue prepares a value computed for a request before it is set.
+// The boolean is false when the header should be skipped.
+func (p *Plugin) checkValue(key, value string) (string, bool) {
+	value, ok := p.sanitizeValue(key, value)
+	if !ok {
+		return "", false
+	}
+	return p.limitValue(value)
+}
+
+// sanitizeValue guards against header injection by skipping or stripping values containing CR or LF.
+func (p *Plugin) sanitizeValue(key, value string) (string, bool) {
+	if !strings.ContainsAny(value, "\r\n") {
+		return value, true
+	}
+	if p.unsafeValueAction == unsafeValueStrip {
+		return lineBreakRemover.Replace(value), true
+	}
+	p.logf("skipping header %q: value contains a line break", key)
+	return "", false
+}
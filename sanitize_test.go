@@ -0,0 +1,82 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestUnsafeValueAction(t *testing.T) {
+	const injected = "safe\r\nSet-Cookie: evil=1"
+
+	testCases := []struct {
+		name          string
+		action        string
+		expected      string
+		expectLogLine bool
+	}{
+		{"Skipped by default", "", "", true},
+		{"Skip", "skip", "", true},
+		{"Strip", "strip", "safeSet-Cookie: evil=1", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			defer add_missing_headers.SetLogOutput(&logs)()
+
+			cfg := add_missing_headers.CreateConfig()
+			cfg.UnsafeValueAction = tc.action
+			cfg.ResponseHeaders["X-Injected"] = injected
+			cfg.ContextHeaders = map[string]string{"X-Context": "tenant"}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				assertHeader(t, req, "X-Context", tc.expected)
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req = req.WithContext(context.WithValue(req.Context(), add_missing_headers.ContextKey("tenant"), injected))
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "X-Injected", tc.expected)
+			if got := recorder.Header().Values("Set-Cookie"); got != nil {
+				t.Errorf("Expected no Set-Cookie header, got %q", got)
+			}
+			if logged := strings.Contains(logs.String(), "line break"); logged != tc.expectLogLine {
+				t.Errorf("Expected logged skip to be %t, got logs %q", tc.expectLogLine, logs.String())
+			}
+		})
+	}
+}
+
+func TestUnsafeValueAction_Unknown(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.UnsafeValueAction = "escape"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+		t.Error("expected an error")
+	}
+}
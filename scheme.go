@@ -0,0 +1,40 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// schemeMatches reports whether the request scheme is the required one, if any.
+func (p *Plugin) schemeMatches(req *http.Request) bool {
+	return p.requireScheme == "" || p.requestScheme(req) == p.requireScheme
+}
+
+// requestScheme returns the request scheme, taken from the first X-Forwarded-Proto
+// entry when trustForwardedProto is set and from the connection otherwise.
+func (p *Plugin) requestScheme(req *http.Request) string {
+	if p.trustForwardedProto {
+		if value := req.Header.Get("X-Forwarded-Proto"); value != "" {
+			proto, _, _ := strings.Cut(value, ",")
+			return strings.ToLower(strings.TrimSpace(proto))
+		}
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
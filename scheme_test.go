@@ -0,0 +1,84 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestRequireScheme(t *testing.T) {
+	const hsts = "max-age=31536000"
+
+	testCases := []struct {
+		name           string
+		scheme         string
+		trustProto     bool
+		url            string
+		forwardedProto string
+		expected       string
+	}{
+		{"HTTPS on TLS", "https", false, "https://localhost", "", hsts},
+		{"HTTPS on plaintext", "https", false, "http://localhost", "", ""},
+		{"HTTP on plaintext", "http", false, "http://localhost", "", hsts},
+		{"HTTP on TLS", "http", false, "https://localhost", "", ""},
+		{"Untrusted forwarded proto", "https", false, "http://localhost", "https", ""},
+		{"Trusted forwarded proto", "https", true, "http://localhost", "https", hsts},
+		{"Trusted forwarded proto list", "https", true, "http://localhost", "HTTPS, http", hsts},
+		{"Trusted forwarded plaintext", "https", true, "https://localhost", "http", ""},
+		{"Trusted without header", "https", true, "https://localhost", "", hsts},
+		{"Upper case scheme", "HTTPS", false, "https://localhost", "", hsts},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeaders["X-Request"] = "configured"
+			cfg.ResponseHeaders["Strict-Transport-Security"] = hsts
+			cfg.RequireScheme = tc.scheme
+			cfg.TrustForwardedProto = tc.trustProto
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				// Request headers are not gated by the scheme
+				assertHeader(t, req, "X-Request", "configured")
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			if tc.forwardedProto != "" {
+				req.Header.Set("X-Forwarded-Proto", tc.forwardedProto)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "Strict-Transport-Security", tc.expected)
+		})
+	}
+}
+
+func TestRequireScheme_Unknown(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequireScheme = "ftp"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// StatusRule adds and removes response headers for responses whose status matches Statuses,
+// a comma-separated list of codes ("404"), classes ("5xx") or inclusive ranges ("500-503").
+type StatusRule struct {
+	Statuses        string            `json:"statuses,omitempty" yaml:"statuses,omitempty"`
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty" yaml:"responseHeaders,omitempty"`
+	RemoveHeaders   []string          `json:"removeHeaders,omitempty" yaml:"removeHeaders,omitempty"`
+}
+
+// statusRule is a compiled StatusRule.
+type statusRule struct {
+	ranges          []statusRange
+	responseHeaders []headerEntry
+	removeHeaders   []string
+}
+
+// statusRange is an inclusive range of status codes.
+type statusRange struct {
+	from, to int
+}
+
+// validStatus reports whether code is a three-digit HTTP status code.
+func validStatus(code int) bool {
+	return code >= 100 && code <= 999
+}
+
+// compileStatusOverrides parses the upstream status codes used as keys of the override map.
+func compileStatusOverrides(overrides map[string]int) (map[int]int, error) {
+	compiled := make(map[int]int, len(overrides))
+	for key, to := range overrides {
+		from, err := strconv.Atoi(key)
+		if err != nil || !validStatus(from) {
+			return nil, fmt.Errorf("invalid status code %q", key)
+		}
+		if !validStatus(to) {
+			return nil, fmt.Errorf("status code %d: invalid replacement %d", from, to)
+		}
+		compiled[from] = to
+	}
+	return compiled, nil
+}
+
+// compileStatusRules compiles status rules, keeping the configured order.
+func compileStatusRules(rules []StatusRule) ([]statusRule, error) {
+	compiled := make([]statusRule, 0, len(rules))
+	for i, r := range rules {
+		ranges, err := parseStatusRanges(r.Statuses)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+
+		responseHeaders, err := compileHeaders(r.ResponseHeaders)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: responseHeaders: %w", i, err)
+		}
+
+		removeHeaders := make([]string, 0, len(r.RemoveHeaders))
+		for _, name := range r.RemoveHeaders {
+			if !validHeaderName(name) {
+				return nil, fmt.Errorf("rule %d: removeHeaders: %w %q", i, ErrInvalidHeaderName, name)
+			}
+			removeHeaders = append(removeHeaders, textproto.CanonicalMIMEHeaderKey(name))
+		}
+
+		compiled = append(compiled, statusRule{
+			ranges:          ranges,
+			responseHeaders: responseHeaders,
+			removeHeaders:   removeHeaders,
+		})
+	}
+	return compiled, nil
+}
+
+// retryAfterRule returns the status rule adding Retry-After to gateway errors, 502, 503 and 504.
+func retryAfterRule(seconds int) statusRule {
+	return statusRule{
+		ranges:          []statusRange{{http.StatusBadGateway, http.StatusGatewayTimeout}},
+		responseHeaders: []headerEntry{{key: "Retry-After", name: "Retry-After", value: strconv.Itoa(seconds)}},
+	}
+}
+
+// parseStatusRanges parses a comma-separated list of status codes, classes and ranges.
+func parseStatusRanges(statuses string) ([]statusRange, error) {
+	var ranges []statusRange
+	for _, field := range strings.Split(statuses, ",") {
+		field = strings.TrimSpace(field)
+
+		// A class such as "5xx" covers its hundred codes
+		if len(field) == 3 && strings.HasSuffix(strings.ToLower(field), "xx") && field[0] >= '1' && field[0] <= '9' {
+			from := int(field[0]-'0') * 100
+			ranges = append(ranges, statusRange{from, from + 99})
+			continue
+		}
+
+		fromText, toText, isRange := strings.Cut(field, "-")
+		from, err := strconv.Atoi(strings.TrimSpace(fromText))
+		if err != nil || !validStatus(from) {
+			return nil, fmt.Errorf("invalid status %q", field)
+		}
+		to := from
+		if isRange {
+			to, err = strconv.Atoi(strings.TrimSpace(toText))
+			if err != nil || !validStatus(to) || to < from {
+				return nil, fmt.Errorf("invalid status range %q", field)
+			}
+		}
+		ranges = append(ranges, statusRange{from, to})
+	}
+	return ranges, nil
+}
+
+// matches reports whether the rule applies to the status code.
+func (r *statusRule) matches(code int) bool {
+	for _, sr := range r.ranges {
+		if code >= sr.from && code <= sr.to {
+			return true
+		}
+	}
+	return false
+}
+
+// matchStatusRules returns the status rules applying to the status code.
+func (p *Plugin) matchStatusRules(code int) []*statusRule {
+	var matched []*statusRule
+	for i := range p.statusRules {
+		if p.statusRules[i].matches(code) {
+			matched = append(matched, &p.statusRules[i])
+		}
+	}
+	return matched
+}
+
+// removeStatusHeaders deletes the headers removed by the matched status rules.
+func removeStatusHeaders(header http.Header, rules []*statusRule) {
+	for _, r := range rules {
+		for _, name := range r.removeHeaders {
+			header.Del(name)
+		}
+	}
+}
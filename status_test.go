@@ -0,0 +1,242 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestOverrideStatusCode(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.OverrideStatusCode = map[string]int{"200": http.StatusBadGateway}
+	cfg.OriginalStatusHeader = "x-original-status"
+	cfg.ResponseHeaders["X-Test"] = "test"
+
+	testCases := []struct {
+		name             string
+		status           int
+		writeHeaderTwice bool
+		expectedStatus   int
+		expectedOriginal string
+	}{
+		{"Mapped status", http.StatusOK, false, http.StatusBadGateway, "200"},
+		{"Implicit status", 0, false, http.StatusBadGateway, "200"},
+		{"Overridden once", http.StatusOK, true, http.StatusBadGateway, "200"},
+		{"Unmapped status", http.StatusNotFound, false, http.StatusNotFound, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tc.status != 0 {
+					rw.WriteHeader(tc.status)
+				}
+				if tc.writeHeaderTwice {
+					rw.WriteHeader(tc.status)
+				}
+				_, _ = rw.Write([]byte("error"))
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			if recorder.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, recorder.Code)
+			}
+			assertResponseHeader(t, recorder, "X-Original-Status", tc.expectedOriginal)
+			assertResponseHeader(t, recorder, "X-Test", "test")
+		})
+	}
+}
+
+func TestOverrideStatusCode_WithoutOriginalHeader(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.OverrideStatusCode = map[string]int{"200": http.StatusBadGateway}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, recorder.Code)
+	}
+	assertResponseHeader(t, recorder, "X-Original-Status", "")
+}
+
+func TestOverrideStatusCode_Invalid(t *testing.T) {
+	testCases := []struct {
+		name      string
+		overrides map[string]int
+	}{
+		{"Non-numeric key", map[string]int{"ok": 502}},
+		{"Out of range key", map[string]int{"42": 502}},
+		{"Out of range replacement", map[string]int{"200": 42}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.OverrideStatusCode = tc.overrides
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestStatusRules(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["Cache-Control"] = "public, max-age=600"
+	cfg.StatusRules = []add_missing_headers.StatusRule{
+		{
+			Statuses:        "5xx",
+			ResponseHeaders: map[string]string{"Cache-Control": "no-store"},
+			RemoveHeaders:   []string{"cache-control", "expires"},
+		},
+		{
+			Statuses:      "404, 410-451",
+			RemoveHeaders: []string{"Expires"},
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		statusCode      int
+		expectedCache   string
+		expectedExpires string
+	}{
+		// The upstream value is removed, then the rule's value wins over responseHeaders
+		{"Server error", http.StatusBadGateway, "no-store", ""},
+		{"Not found", http.StatusNotFound, "private", ""},
+		{"In range", http.StatusTooManyRequests, "private", ""},
+		{"No rule", http.StatusOK, "private", "0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("Cache-Control", "private")
+				rw.Header().Set("Expires", "0")
+				rw.WriteHeader(tc.statusCode)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "Cache-Control", tc.expectedCache)
+			assertResponseHeader(t, recorder, "Expires", tc.expectedExpires)
+		})
+	}
+}
+
+func TestStatusRules_RemovedThenConfigured(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.ResponseHeaders["Cache-Control"] = "no-cache"
+	cfg.StatusRules = []add_missing_headers.StatusRule{
+		{Statuses: "500-599", RemoveHeaders: []string{"Cache-Control"}},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "max-age=3600")
+		rw.WriteHeader(http.StatusInternalServerError)
+	})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	// Removing the upstream value lets the configured one be added
+	assertResponseHeader(t, recorder, "Cache-Control", "no-cache")
+}
+
+func TestStatusRules_Invalid(t *testing.T) {
+	testCases := []string{"", "5x", "abc", "600-500", "99", "500-", "0xx"}
+
+	for _, statuses := range testCases {
+		t.Run(statuses, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.StatusRules = []add_missing_headers.StatusRule{{Statuses: statuses}}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+				t.Errorf("Expected an error for statuses %q", statuses)
+			}
+		})
+	}
+}
+
+func TestRetryAfterOnGatewayErrors(t *testing.T) {
+	testCases := []struct {
+		code     int
+		existing string
+		expected string
+	}{
+		{http.StatusBadGateway, "", "30"},
+		{http.StatusServiceUnavailable, "", "30"},
+		{http.StatusGatewayTimeout, "", "30"},
+		{http.StatusGatewayTimeout, "120", "120"},
+		{http.StatusInternalServerError, "", ""},
+		{http.StatusOK, "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%d/%s", tc.code, tc.existing), func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RetryAfterOnGatewayErrors = 30
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if tc.existing != "" {
+					rw.Header().Set("Retry-After", tc.existing)
+				}
+				rw.WriteHeader(tc.code)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "Retry-After", tc.expected)
+		})
+	}
+}
+
+func TestRetryAfterOnGatewayErrors_Negative(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RetryAfterOnGatewayErrors = -1
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+		t.Error("Expected an error for a negative delay")
+	}
+}
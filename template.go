@@ -0,0 +1,404 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// timeNow returns the current time, it is a variable so tests can replace it.
+var timeNow = time.Now
+
+// timeLayouts maps layout names usable with the "now" template function to Go layouts.
+var timeLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"UnixDate":    time.UnixDate,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen":     time.Kitchen,
+	"HTTP":        http.TimeFormat,
+}
+
+// templateFuncs are the functions available to header value templates.
+// Functions reading the request are placeholders, bound to the request when rendering.
+var templateFuncs = template.FuncMap{
+	"now":    formatNow,
+	"cookie": func(string) string { return "" },
+	"header": func(string) string { return "" },
+}
+
+// requestFuncs lists the template functions that read the request.
+var requestFuncs = map[string]bool{
+	"cookie": true,
+	"header": true,
+}
+
+// templateData is the data available to header value templates.
+type templateData struct {
+	// Value is the value of the source header, for derived headers.
+	Value string
+	// Status is the response status code, 0 when rendering request headers.
+	Status int
+
+	// req is the request being processed, read by request functions.
+	req *http.Request
+	// header is a snapshot of the headers of the current phase before any was added,
+	// read by the "header" function. It is nil when no template reads headers.
+	header http.Header
+	// captures are the submatches of the regular expression in requireHeaders.
+	captures []string
+	// cache holds rendered outputs for requests carrying cacheKey, nil when disabled.
+	cache    *templateCache
+	cacheKey string
+}
+
+// Match returns a submatch of the regular expression in requireHeaders, 0 being the whole match.
+// It is empty when the group didn't participate or no regular expression matched.
+func (d *templateData) Match(group int) string {
+	if group < 0 || group >= len(d.captures) {
+		return ""
+	}
+	return d.captures[group]
+}
+
+// StatusClass returns the class of the response status code, such as "5xx".
+// It is empty when rendering request headers.
+func (d *templateData) StatusClass() string {
+	if d.Status < 100 || d.Status > 999 {
+		return ""
+	}
+	return strconv.Itoa(d.Status/100) + "xx"
+}
+
+// valueTemplate is a compiled header value template.
+type valueTemplate struct {
+	tmpl *template.Template
+	// usesRequest is set when the template calls request functions,
+	// rendering then executes a clone with the functions bound to the request.
+	usesRequest bool
+	// readsHeaders is set when the template calls the "header" function.
+	readsHeaders bool
+	// readsCookies is set when the template calls the "cookie" function.
+	readsCookies bool
+	// cacheable is set when everything the template reads can be part of a cache key:
+	// it doesn't call "now", and only passes literal names to "header".
+	cacheable bool
+	// headerNames are the headers read by the template, part of its cache key.
+	headerNames []string
+}
+
+// isTemplate reports whether a configured value should be parsed as a template.
+func isTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+// compileTemplate parses a header value template.
+func compileTemplate(name, text string) (*valueTemplate, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	headerNames, literal := literalArgs(tmpl.Tree.Root, "header")
+	return &valueTemplate{
+		tmpl:         tmpl,
+		usesRequest:  callsAny(tmpl.Tree.Root, requestFuncs),
+		readsHeaders: callsAny(tmpl.Tree.Root, map[string]bool{"header": true}),
+		readsCookies: callsAny(tmpl.Tree.Root, map[string]bool{"cookie": true}),
+		cacheable:    literal && !callsAny(tmpl.Tree.Root, map[string]bool{"now": true}),
+		headerNames:  headerNames,
+	}, nil
+}
+
+// compileValueTemplate parses a header value template and validates it by executing it once,
+// so that invalid arguments such as unknown time layouts are reported early.
+func compileValueTemplate(name, text string) (*valueTemplate, error) {
+	tmpl, err := compileTemplate(name, text)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tmpl.render(&templateData{}); err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// render executes the template, or returns its cached output for the request's cache key
+// when everything else the template reads is the same too.
+func (t *valueTemplate) render(data *templateData) (string, error) {
+	if data.cache == nil || data.cacheKey == "" || !t.cacheable {
+		return t.execute(data)
+	}
+
+	key := templateCacheKey{tmpl: t, key: data.cacheKey, status: data.Status, value: data.Value, inputs: t.cacheInputs(data)}
+	if value, ok := data.cache.get(key); ok {
+		return value, nil
+	}
+	value, err := t.execute(data)
+	if err != nil {
+		return "", err
+	}
+	data.cache.add(key, value)
+	return value, nil
+}
+
+// cacheInputs encodes the captures, headers and cookies the template may read, for its cache key.
+func (t *valueTemplate) cacheInputs(data *templateData) string {
+	var inputs strings.Builder
+	writeInputs(&inputs, data.captures)
+
+	headers := make([]string, len(t.headerNames))
+	for i, name := range t.headerNames {
+		headers[i] = data.header.Get(name)
+	}
+	writeInputs(&inputs, headers)
+
+	if t.readsCookies && data.req != nil {
+		writeInputs(&inputs, data.req.Header.Values("Cookie"))
+	}
+	return inputs.String()
+}
+
+// writeInputs writes a length-prefixed list of values, so that different lists never encode the same.
+func writeInputs(inputs *strings.Builder, values []string) {
+	inputs.WriteString(strconv.Itoa(len(values)))
+	for _, value := range values {
+		inputs.WriteByte(' ')
+		inputs.WriteString(strconv.Itoa(len(value)))
+		inputs.WriteByte(':')
+		inputs.WriteString(value)
+	}
+	inputs.WriteByte(';')
+}
+
+// execute renders the template.
+func (t *valueTemplate) execute(data *templateData) (string, error) {
+	tmpl := t.tmpl
+	if t.usesRequest {
+		clone, err := tmpl.Clone()
+		if err != nil {
+			return "", err
+		}
+		tmpl = clone.Funcs(boundRequestFuncs(data))
+	}
+
+	var value strings.Builder
+	if err := tmpl.Execute(&value, data); err != nil {
+		return "", err
+	}
+	return value.String(), nil
+}
+
+// boundRequestFuncs returns the request functions reading from data, whose request may be nil.
+func boundRequestFuncs(data *templateData) template.FuncMap {
+	req := data.req
+	return template.FuncMap{
+		"header": func(name string) string {
+			// Reading the snapshot keeps values rendered by this phase out of reach
+			return data.header.Get(name)
+		},
+		"cookie": func(name string) string {
+			if req == nil {
+				return ""
+			}
+			// Cookies are only parsed when a template asks for one
+			cookie, err := req.Cookie(name)
+			if err != nil {
+				return ""
+			}
+			return cookie.Value
+		},
+	}
+}
+
+// callsAny reports whether the parse tree calls any of the named functions.
+func callsAny(node parse.Node, names map[string]bool) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if callsAny(child, names) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return callsAny(n.Pipe, names)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if callsAny(cmd, names) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if callsAny(arg, names) {
+				return true
+			}
+		}
+	case *parse.IdentifierNode:
+		return names[n.Ident]
+	case *parse.IfNode:
+		return callsAny(n.Pipe, names) || callsAny(n.List, names) || callsAny(n.ElseList, names)
+	case *parse.RangeNode:
+		return callsAny(n.Pipe, names) || callsAny(n.List, names) || callsAny(n.ElseList, names)
+	case *parse.WithNode:
+		return callsAny(n.Pipe, names) || callsAny(n.List, names) || callsAny(n.ElseList, names)
+	}
+	return false
+}
+
+// literalArgs returns the arguments of every call to the named function. It reports false
+// when the function is called with anything but a single string literal, or used as a value.
+func literalArgs(node parse.Node, name string) ([]string, bool) {
+	var args []string
+	literal := true
+
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for i, cmd := range n.Cmds {
+				// Later commands of a pipeline also receive the previous result
+				if ident, ok := cmd.Args[0].(*parse.IdentifierNode); ok && ident.Ident == name && i == 0 && len(cmd.Args) == 2 {
+					if arg, ok := cmd.Args[1].(*parse.StringNode); ok {
+						args = append(args, arg.Text)
+						continue
+					}
+				}
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		case *parse.IdentifierNode:
+			if n.Ident == name {
+				literal = false
+			}
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		}
+	}
+	walk(node)
+
+	return args, literal
+}
+
+// formatNow formats the current UTC time with a named or custom Go layout.
+// "Unix" and "UnixMilli" produce epoch timestamps.
+func formatNow(layout string) (string, error) {
+	now := timeNow().UTC()
+
+	switch layout {
+	case "Unix":
+		return strconv.FormatInt(now.Unix(), 10), nil
+	case "UnixMilli":
+		return strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10), nil
+	}
+
+	if named, ok := timeLayouts[layout]; ok {
+		return now.Format(named), nil
+	}
+
+	// A custom layout must contain at least one layout element,
+	// otherwise formatting any other time returns the layout unchanged
+	probe := time.Date(2001, time.November, 12, 21, 31, 41, 0, time.UTC)
+	if layout == "" || probe.Format(layout) == layout {
+		return "", fmt.Errorf("unknown time layout %q", layout)
+	}
+
+	return now.Format(layout), nil
+}
+
+// newTemplateData returns the data for rendering the templates of a phase. The header map is
+// snapshotted only when a template reads headers, so values added by the phase are never visible.
+func (p *Plugin) newTemplateData(req *http.Request, header http.Header) *templateData {
+	data := &templateData{req: req}
+	if p.currentFileHeaders().readsHeaders {
+		data.header = header.Clone()
+	}
+	if p.templateCache != nil {
+		data.cache = p.templateCache
+		data.cacheKey = req.Header.Get(p.templateCacheKey)
+	}
+	return data
+}
+
+// templatesReadHeaders reports whether any configured template calls the "header" function.
+func (p *Plugin) templatesReadHeaders() bool {
+	if setsReadHeaders(p.headerSets()) {
+		return true
+	}
+	for _, d := range p.derivedHeaders {
+		if d.tmpl != nil && d.tmpl.readsHeaders {
+			return true
+		}
+	}
+	return false
+}
+
+// setsReadHeaders reports whether any template of sets calls the "header" function.
+func setsReadHeaders(sets []headerSet) bool {
+	for _, set := range sets {
+		for _, entry := range set.entries {
+			if entry.tmpl != nil && entry.tmpl.readsHeaders {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestNowTemplate(t *testing.T) {
+	now := time.Date(2025, time.March, 4, 5, 6, 7, 0, time.FixedZone("CET", 3600))
+	defer add_missing_headers.SetTimeNow(func() time.Time { return now })()
+
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Request-Start"] = `{{ now "RFC3339" }}`
+	cfg.ResponseHeaders["X-Generated-At"] = `{{ now "HTTP" }}`
+	cfg.ResponseHeaders["X-Epoch"] = `t={{ now "Unix" }}`
+	cfg.ResponseHeaders["X-Date"] = `{{ now "2006-01-02" }}`
+
+	var requestStart string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requestStart = req.Header.Get("X-Request-Start")
+	})
+
+	handler := newTestHandler(t, cfg, next)
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	handler.ServeHTTP(recorder, req)
+
+	if requestStart != "2025-03-04T04:06:07Z" {
+		t.Errorf("invalid X-Request-Start: %q", requestStart)
+	}
+	assertResponseHeader(t, recorder, "X-Generated-At", "Tue, 04 Mar 2025 04:06:07 GMT")
+	assertResponseHeader(t, recorder, "X-Epoch", "t=1741061167")
+	assertResponseHeader(t, recorder, "X-Date", "2025-03-04")
+
+	// The value is computed for every request
+	now = now.Add(time.Hour)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	assertResponseHeader(t, recorder, "X-Generated-At", "Tue, 04 Mar 2025 05:06:07 GMT")
+}
+
+func TestNowTemplate_Invalid(t *testing.T) {
+	testCases := []struct {
+		name  string
+		value string
+	}{
+		{"Unknown layout", `{{ now "yesterday" }}`},
+		{"Empty layout", `{{ now "" }}`},
+		{"Missing layout", `{{ now }}`},
+		{"Syntax error", `{{ now "RFC3339"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.ResponseHeaders["X-Generated-At"] = tc.value
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+				t.Errorf("expected an error for %q", tc.value)
+			}
+		})
+	}
+}
+
+func TestCookieTemplate(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Session-Tier"] = `{{ cookie "tier" }}`
+	cfg.ResponseHeaders["X-Tier"] = `tier={{ cookie "tier" }}`
+
+	testCases := []struct {
+		name             string
+		cookie           string
+		expectedRequest  string
+		expectedResponse string
+	}{
+		{"Cookie present", "tier=gold; session=abc", "gold", "tier=gold"},
+		{"Other cookies only", "session=abc", "", "tier="},
+		{"No cookies", "", "", "tier="},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var values []string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				values = req.Header.Values("X-Session-Tier")
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			if tc.cookie != "" {
+				req.Header.Set("Cookie", tc.cookie)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			// An empty result skips the header instead of adding it empty
+			if tc.expectedRequest == "" && values != nil {
+				t.Errorf("Expected X-Session-Tier to be absent, got %q", values)
+			}
+			if tc.expectedRequest != "" && (len(values) != 1 || values[0] != tc.expectedRequest) {
+				t.Errorf("Expected X-Session-Tier %q, got %q", tc.expectedRequest, values)
+			}
+			assertResponseHeader(t, recorder, "X-Tier", tc.expectedResponse)
+		})
+	}
+}
+
+func TestHeaderTemplate(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Forwarded-For"] = `{{ header "X-Real-IP" }}, proxy`
+	// Headers referencing each other only see the values from before the phase
+	cfg.RequestHeaders["X-A"] = `{{ header "X-B" }}a`
+	cfg.RequestHeaders["X-B"] = `{{ header "X-A" }}b`
+	cfg.ResponseHeaders["X-Content-Type"] = `{{ header "Content-Type" }}`
+
+	testCases := []struct {
+		name                string
+		realIP              string
+		contentType         string
+		expectedForwarded   string
+		expectedContentType string
+	}{
+		{"Referenced headers present", "203.0.113.7", "text/html", "203.0.113.7, proxy", "text/html"},
+		{"Referenced headers absent", "", "", ", proxy", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				assertHeader(t, req, "X-Forwarded-For", tc.expectedForwarded)
+				assertHeader(t, req, "X-A", "a")
+				assertHeader(t, req, "X-B", "b")
+				if tc.contentType != "" {
+					rw.Header().Set("Content-Type", tc.contentType)
+				}
+				rw.WriteHeader(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			if tc.realIP != "" {
+				req.Header.Set("X-Real-IP", tc.realIP)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "X-Content-Type", tc.expectedContentType)
+		})
+	}
+}
+
+func TestStatusTemplate(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Request-Status"] = "{{ .StatusClass }}"
+	cfg.ResponseHeaders["X-Response-Status-Class"] = "{{ .StatusClass }}"
+	cfg.ResponseHeaders["X-Response-Status"] = "status={{ .Status }}"
+	cfg.OverrideStatusCode = map[string]int{"502": http.StatusServiceUnavailable}
+
+	testCases := []struct {
+		status        int
+		expectedClass string
+		expected      string
+	}{
+		{http.StatusOK, "2xx", "status=200"},
+		{http.StatusNotFound, "4xx", "status=404"},
+		{http.StatusServiceUnavailable, "5xx", "status=503"},
+		// Overridden codes are rendered with the status sent to the client
+		{http.StatusBadGateway, "5xx", "status=503"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(strconv.Itoa(tc.status), func(t *testing.T) {
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				// The status isn't known in the request phase
+				if values := req.Header.Values("X-Request-Status"); values != nil {
+					t.Errorf("Expected X-Request-Status to be absent, got %q", values)
+				}
+				rw.WriteHeader(tc.status)
+			})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+
+			newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "X-Response-Status-Class", tc.expectedClass)
+			assertResponseHeader(t, recorder, "X-Response-Status", tc.expected)
+		})
+	}
+}
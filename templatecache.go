@@ -0,0 +1,89 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"container/list"
+	"sync"
+)
+
+// templateCacheKey identifies a rendered template output. Besides the caller-provided key,
+// it holds everything the template reads, so a cached output is always the one it would render.
+type templateCacheKey struct {
+	tmpl   *valueTemplate
+	key    string
+	status int
+	value  string
+	// inputs encodes the captures, headers and cookies the template reads.
+	inputs string
+}
+
+// templateCacheItem is a cached template output, stored in the recency list.
+type templateCacheItem struct {
+	key   templateCacheKey
+	value string
+}
+
+// templateCache is a fixed-size, least recently used cache of template outputs.
+type templateCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[templateCacheKey]*list.Element
+	// misses counts lookups without a cached output, that is template evaluations.
+	misses int
+}
+
+// newTemplateCache returns a cache holding at most size outputs.
+func newTemplateCache(size int) *templateCache {
+	return &templateCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[templateCacheKey]*list.Element, size),
+	}
+}
+
+// get returns the cached output for key, marking it as recently used.
+func (c *templateCache) get(key templateCacheKey) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*templateCacheItem).value, true
+}
+
+// add stores the output for key, evicting the least recently used one when full.
+func (c *templateCache) add(key templateCacheKey, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		element.Value.(*templateCacheItem).value = value
+		c.order.MoveToFront(element)
+		return
+	}
+
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*templateCacheItem).key)
+	}
+	c.items[key] = c.order.PushFront(&templateCacheItem{key: key, value: value})
+}
@@ -0,0 +1,220 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestTemplateCache(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Rendered"] = `{{ header "X-Client" }}`
+	cfg.TemplateCacheSize = 1
+	cfg.TemplateCacheKey = "x-connection-id"
+
+	var rendered string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rendered = req.Header.Get("X-Rendered")
+	})
+	handler := newTestHandler(t, cfg, next)
+
+	testCases := []struct {
+		connectionID string
+		client       string
+		evaluations  int
+	}{
+		{"a", "one", 1},
+		// Same key and inputs, the output is reused
+		{"a", "one", 1},
+		// Same key but another header value, rendered again
+		{"a", "two", 2},
+		{"b", "two", 3},
+		// The cache holds a single output, so "a" was evicted by "b"
+		{"a", "two", 4},
+		// Requests without a key are never cached
+		{"", "three", 4},
+	}
+
+	for i, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		if tc.connectionID != "" {
+			req.Header.Set("X-Connection-Id", tc.connectionID)
+		}
+		req.Header.Set("X-Client", tc.client)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if rendered != tc.client {
+			t.Errorf("Request %d: expected X-Rendered %q, got %q", i, tc.client, rendered)
+		}
+		if got := add_missing_headers.TemplateCacheMisses(handler); got != tc.evaluations {
+			t.Errorf("Request %d: expected %d cached evaluations, got %d", i, tc.evaluations, got)
+		}
+	}
+}
+
+func TestTemplateCache_Inputs(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequireHeaders = map[string]string{"X-Tenant": `regex:^(\w+)$`}
+	cfg.RequestHeaders["X-Rendered"] = `{{ .Match 1 }}/{{ cookie "session" }}`
+	cfg.TemplateCacheSize = 10
+	cfg.TemplateCacheKey = "X-Connection-Id"
+
+	var rendered string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rendered = req.Header.Get("X-Rendered")
+	})
+	handler := newTestHandler(t, cfg, next)
+
+	testCases := []struct {
+		tenant   string
+		session  string
+		expected string
+	}{
+		{"acme", "s1", "acme/s1"},
+		{"other", "s1", "other/s1"},
+		{"other", "s2", "other/s2"},
+		{"acme", "s1", "acme/s1"},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		req.Header.Set("X-Connection-Id", "a")
+		req.Header.Set("X-Tenant", tc.tenant)
+		req.AddCookie(&http.Cookie{Name: "session", Value: tc.session})
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if rendered != tc.expected {
+			t.Errorf("Expected X-Rendered %q, got %q", tc.expected, rendered)
+		}
+	}
+
+	// The last request repeats the first one
+	if got := add_missing_headers.TemplateCacheMisses(handler); got != 3 {
+		t.Errorf("Expected 3 evaluations, got %d", got)
+	}
+}
+
+func TestTemplateCache_Uncacheable(t *testing.T) {
+	testCases := []struct {
+		name     string
+		template string
+	}{
+		{"Time", `{{ now "Unix" }}`},
+		{"Computed header name", `{{ header (printf "X-%s" "Client") }}`},
+		{"Piped header name", `{{ "X-Client" | header }}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeaders["X-Rendered"] = tc.template
+			cfg.TemplateCacheSize = 10
+			cfg.TemplateCacheKey = "X-Connection-Id"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			handler := newTestHandler(t, cfg, next)
+
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+				req.Header.Set("X-Connection-Id", "a")
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			if got := add_missing_headers.TemplateCacheMisses(handler); got != 0 {
+				t.Errorf("Expected the template to bypass the cache, got %d lookups", got)
+			}
+		})
+	}
+}
+
+func TestTemplateCache_Invalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		size int
+		key  string
+	}{
+		{"Negative size", -1, "X-Connection-Id"},
+		{"Missing key", 10, ""},
+		{"Invalid key", 10, "X Connection"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.TemplateCacheSize = tc.size
+			cfg.TemplateCacheKey = tc.key
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func BenchmarkTemplateCache(b *testing.B) {
+	const connections = 10
+
+	for _, bc := range []struct {
+		name string
+		size int
+	}{
+		{"Uncached", 0},
+		{"Cached", connections},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeaders["X-Rendered"] = `{{ header "User-Agent" | printf "%.16s" }}-{{ cookie "session" }}`
+			cfg.TemplateCacheSize = bc.size
+			cfg.TemplateCacheKey = "X-Connection-Id"
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			handler, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin")
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			writer := &discardResponseWriter{header: make(http.Header)}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				id := strconv.Itoa(i % connections)
+				req.Header = http.Header{
+					"X-Connection-Id": {id},
+					"User-Agent":      {"client/" + id},
+					"Cookie":          {"session=" + id},
+				}
+				writer.reset()
+				handler.ServeHTTP(writer, req)
+			}
+
+			// Without a cache, every request evaluates the template
+			evaluations := b.N
+			if bc.size > 0 {
+				evaluations = add_missing_headers.TemplateCacheMisses(handler)
+			}
+			b.ReportMetric(float64(evaluations)/float64(b.N), "evaluations/op")
+		})
+	}
+}
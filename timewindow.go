@@ -0,0 +1,95 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// timeWindow is a daily UTC time range in minutes since midnight, the end being excluded.
+// A window whose end is before its start crosses midnight.
+type timeWindow struct {
+	start, end int
+}
+
+// parseTimeWindows parses "HH:MM-HH:MM" time windows.
+func parseTimeWindows(windows []string) ([]timeWindow, error) {
+	parsed := make([]timeWindow, 0, len(windows))
+	for _, window := range windows {
+		startText, endText, found := strings.Cut(window, "-")
+		if !found {
+			return nil, fmt.Errorf("invalid time window %q: expected HH:MM-HH:MM", window)
+		}
+
+		start, err := parseTimeOfDay(strings.TrimSpace(startText))
+		if err != nil {
+			return nil, fmt.Errorf("invalid time window %q: %w", window, err)
+		}
+		end, err := parseTimeOfDay(strings.TrimSpace(endText))
+		if err != nil {
+			return nil, fmt.Errorf("invalid time window %q: %w", window, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("invalid time window %q: empty window", window)
+		}
+
+		parsed = append(parsed, timeWindow{start: start, end: end})
+	}
+	return parsed, nil
+}
+
+// parseTimeOfDay parses "HH:MM" into minutes since midnight.
+func parseTimeOfDay(text string) (int, error) {
+	hoursText, minutesText, found := strings.Cut(text, ":")
+	if !found || len(hoursText) != 2 || len(minutesText) != 2 {
+		return 0, fmt.Errorf("invalid time %q", text)
+	}
+
+	hours, err := strconv.Atoi(hoursText)
+	if err != nil || hours < 0 || hours > 23 {
+		return 0, fmt.Errorf("invalid hour in %q", text)
+	}
+	minutes, err := strconv.Atoi(minutesText)
+	if err != nil || minutes < 0 || minutes > 59 {
+		return 0, fmt.Errorf("invalid minute in %q", text)
+	}
+	return hours*60 + minutes, nil
+}
+
+// contains reports whether the minute of the day falls within the window.
+func (w timeWindow) contains(minute int) bool {
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// bypassedByTime reports whether the current UTC time falls within a bypass time window.
+func (p *Plugin) bypassedByTime() bool {
+	if len(p.bypassTimeWindows) == 0 {
+		return false
+	}
+
+	now := timeNow().UTC()
+	minute := now.Hour()*60 + now.Minute()
+	for _, w := range p.bypassTimeWindows {
+		if w.contains(minute) {
+			return true
+		}
+	}
+	return false
+}
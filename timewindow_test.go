@@ -0,0 +1,78 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestBypassTimeWindows(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.RequestHeaders["X-Test-Header"] = "test-value"
+	cfg.BypassTimeWindows = []string{"02:00-03:00", "23:30-00:15"}
+
+	testCases := []struct {
+		name         string
+		now          time.Time
+		shouldBypass bool
+	}{
+		{"Start of window", time.Date(2025, 1, 1, 2, 0, 0, 0, time.UTC), true},
+		{"Inside window", time.Date(2025, 1, 1, 2, 59, 59, 0, time.UTC), true},
+		{"End is excluded", time.Date(2025, 1, 1, 3, 0, 0, 0, time.UTC), false},
+		{"Outside windows", time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC), false},
+		{"Before midnight", time.Date(2025, 1, 1, 23, 45, 0, 0, time.UTC), true},
+		{"After midnight", time.Date(2025, 1, 2, 0, 10, 0, 0, time.UTC), true},
+		{"After crossing window", time.Date(2025, 1, 2, 0, 15, 0, 0, time.UTC), false},
+		// 03:30 in UTC+2 is 01:30 UTC
+		{"Other time zone", time.Date(2025, 1, 1, 4, 30, 0, 0, time.FixedZone("UTC+2", 2*60*60)), true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer add_missing_headers.SetTimeNow(func() time.Time { return tc.now })()
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				expected := "test-value"
+				if tc.shouldBypass {
+					expected = ""
+				}
+				assertHeader(t, req, "X-Test-Header", expected)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+		})
+	}
+}
+
+func TestBypassTimeWindows_Invalid(t *testing.T) {
+	for _, window := range []string{"02:00", "2:00-03:00", "24:00-01:00", "02:60-03:00", "02:00-02:00", "aa:bb-cc:dd"} {
+		t.Run(window, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.BypassTimeWindows = []string{window}
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+				t.Errorf("Expected an error for time window %q", window)
+			}
+		})
+	}
+}
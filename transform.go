@@ -0,0 +1,66 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// transforms maps transform names usable in requestHeaderTransforms to their function.
+var transforms = map[string]func(string) string{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+}
+
+// headerTransform normalizes the values of an existing header.
+type headerTransform struct {
+	key       string
+	transform func(string) string
+}
+
+// compileHeaderTransforms compiles header transforms, sorted by header name.
+func compileHeaderTransforms(config map[string]string) ([]headerTransform, error) {
+	canonical, err := canonicalizeHeaders(config)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled := make([]headerTransform, 0, len(canonical))
+	for key, name := range canonical {
+		transform, ok := transforms[name]
+		if !ok {
+			return nil, fmt.Errorf("header %q: unknown transform %q", key, name)
+		}
+		compiled = append(compiled, headerTransform{key: key, transform: transform})
+	}
+
+	sort.Slice(compiled, func(i, j int) bool { return compiled[i].key < compiled[j].key })
+
+	return compiled, nil
+}
+
+// transformRequestHeaders transforms every value of the configured headers the request carries.
+func (p *Plugin) transformRequestHeaders(header http.Header) {
+	for _, t := range p.requestTransforms {
+		values := header.Values(t.key)
+		for i, value := range values {
+			values[i] = t.transform(value)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestRequestHeaderTransforms(t *testing.T) {
+	testCases := []struct {
+		name      string
+		transform string
+		values    []string
+		expected  []string
+	}{
+		{"Lower", "lower", []string{"ABC-123-Def"}, []string{"abc-123-def"}},
+		{"Upper", "upper", []string{"abc-123-Def"}, []string{"ABC-123-DEF"}},
+		{"Trim", "trim", []string{"  abc \t"}, []string{"abc"}},
+		{"Every value", "lower", []string{"ONE", "Two"}, []string{"one", "two"}},
+		{"Absent", "lower", nil, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeaderTransforms = map[string]string{"x-request-id": tc.transform}
+
+			var values []string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				values = req.Header.Values("X-Request-Id")
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			for _, value := range tc.values {
+				req.Header.Add("X-Request-Id", value)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+
+			if !reflect.DeepEqual(values, tc.expected) {
+				t.Errorf("Expected X-Request-Id %q, got %q", tc.expected, values)
+			}
+		})
+	}
+}
+
+func TestRequestHeaderTransforms_Invalid(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config map[string]string
+	}{
+		{"Unknown transform", map[string]string{"X-Request-Id": "title"}},
+		{"Invalid header", map[string]string{"X Request": "lower"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.RequestHeaderTransforms = tc.config
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test"); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import "strings"
+
+// asciiSpace lists the ASCII whitespace characters removed by trimValue.
+const asciiSpace = " \t\n\v\f\r"
+
+// trimValue removes leading and trailing ASCII whitespace, keeping interior whitespace.
+func trimValue(value string) string {
+	return strings.Trim(value, asciiSpace)
+}
+
+// trimValues returns a copy of headers with trimmed values.
+func trimValues(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	trimmed := make(map[string]string, len(headers))
+	for key, value := range headers {
+		trimmed[key] = trimValue(value)
+	}
+	return trimmed
+}
+
+// trimNamesAndValues returns a copy of headers with trimmed names and values. A name that
+// only collides with another once trimmed is kept as-is, to be reported as invalid.
+func trimNamesAndValues(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	trimmed := make(map[string]string, len(headers))
+	for key, value := range headers {
+		name := trimValue(key)
+		if _, ok := headers[name]; ok && name != key {
+			name = key
+		}
+		trimmed[name] = trimValue(value)
+	}
+	return trimmed
+}
+
+// trimMultiValues returns a copy of multi-value headers with trimmed values.
+func trimMultiValues(headers map[string][]string) map[string][]string {
+	if headers == nil {
+		return nil
+	}
+	trimmed := make(map[string][]string, len(headers))
+	for key, values := range headers {
+		trimmed[key] = make([]string, len(values))
+		for i, value := range values {
+			trimmed[key][i] = trimValue(value)
+		}
+	}
+	return trimmed
+}
+
+// trimNestedValues returns a copy of header maps keyed by host, threshold or header with trimmed values.
+func trimNestedValues(nested map[string]map[string]string) map[string]map[string]string {
+	if nested == nil {
+		return nil
+	}
+	trimmed := make(map[string]map[string]string, len(nested))
+	for key, headers := range nested {
+		trimmed[key] = trimValues(headers)
+	}
+	return trimmed
+}
+
+// trimConfigValues returns a copy of config with every configured header value trimmed.
+// The caller's config is left untouched.
+func trimConfigValues(config *Config) *Config {
+	trimmed := *config
+
+	trimmed.RequestHeaders = trimValues(config.RequestHeaders)
+	trimmed.ResponseHeaders = trimValues(config.ResponseHeaders)
+	trimmed.BypassHeaders = trimValues(config.BypassHeaders)
+	trimmed.RequireHeaders = trimValues(config.RequireHeaders)
+	trimmed.IdempotencyHeaders = trimValues(config.IdempotencyHeaders)
+	trimmed.ConditionalGetHeaders = trimValues(config.ConditionalGetHeaders)
+	trimmed.SkipIfResponseHeaderPresent = trimNamesAndValues(config.SkipIfResponseHeaderPresent)
+
+	trimmed.RequestHeadersMulti = trimMultiValues(config.RequestHeadersMulti)
+	trimmed.ResponseHeadersMulti = trimMultiValues(config.ResponseHeadersMulti)
+
+	trimmed.HostHeaders = trimNestedValues(config.HostHeaders)
+	trimmed.RequestSizeHeaders = trimNestedValues(config.RequestSizeHeaders)
+
+	if config.ResponseHeaderRequestConditions != nil {
+		trimmed.ResponseHeaderRequestConditions = make(map[string]map[string]string, len(config.ResponseHeaderRequestConditions))
+		for key, conditions := range config.ResponseHeaderRequestConditions {
+			trimmed.ResponseHeaderRequestConditions[key] = trimNamesAndValues(conditions)
+		}
+	}
+
+	if config.ResponseHeaderFromResponseHeader != nil {
+		trimmed.ResponseHeaderFromResponseHeader = make(map[string]DerivedHeader, len(config.ResponseHeaderFromResponseHeader))
+		for key, d := range config.ResponseHeaderFromResponseHeader {
+			d.Template = trimValue(d.Template)
+			trimmed.ResponseHeaderFromResponseHeader[key] = d
+		}
+	}
+
+	trimmed.QueryConditions = make([]QueryCondition, len(config.QueryConditions))
+	for i, c := range config.QueryConditions {
+		c.Value = trimValue(c.Value)
+		c.RequestHeaders = trimValues(c.RequestHeaders)
+		c.ResponseHeaders = trimValues(c.ResponseHeaders)
+		trimmed.QueryConditions[i] = c
+	}
+
+	trimmed.HashBuckets.Buckets = make([]HashBucket, len(config.HashBuckets.Buckets))
+	for i, b := range config.HashBuckets.Buckets {
+		b.RequestHeaders = trimValues(b.RequestHeaders)
+		b.ResponseHeaders = trimValues(b.ResponseHeaders)
+		trimmed.HashBuckets.Buckets[i] = b
+	}
+
+	trimmed.StatusRules = make([]StatusRule, len(config.StatusRules))
+	for i, r := range config.StatusRules {
+		r.ResponseHeaders = trimValues(r.ResponseHeaders)
+		trimmed.StatusRules[i] = r
+	}
+
+	if config.FallbackHeaders != nil {
+		trimmed.FallbackHeaders = make(map[string]FallbackHeader, len(config.FallbackHeaders))
+		for key, f := range config.FallbackHeaders {
+			f.Default = trimValue(f.Default)
+			trimmed.FallbackHeaders[key] = f
+		}
+	}
+
+	if config.WeightedHeaders != nil {
+		trimmed.WeightedHeaders = make(map[string][]WeightedValue, len(config.WeightedHeaders))
+		for key, choices := range config.WeightedHeaders {
+			trimmed.WeightedHeaders[key] = make([]WeightedValue, len(choices))
+			for i, choice := range choices {
+				choice.Value = trimValue(choice.Value)
+				trimmed.WeightedHeaders[key][i] = choice
+			}
+		}
+	}
+
+	return &trimmed
+}
@@ -0,0 +1,200 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestTrimValues(t *testing.T) {
+	testCases := []struct {
+		name           string
+		trimValues     bool
+		expectedCache  string
+		expectedBypass bool
+	}{
+		{"Trimmed", true, "max-age=3600, public", true},
+		{"Untrimmed by default", false, " max-age=3600, public\t", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.TrimValues = tc.trimValues
+			cfg.ResponseHeaders["Cache-Control"] = " max-age=3600, public\t"
+			cfg.BypassHeaders["X-Skip"] = "true "
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			})
+			handler := newTestHandler(t, cfg, next)
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			handler.ServeHTTP(recorder, req)
+
+			assertResponseHeader(t, recorder, "Cache-Control", tc.expectedCache)
+
+			// Bypass values are compared exactly, trailing whitespace prevents the match
+			recorder = httptest.NewRecorder()
+			req = httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.Header.Set("X-Skip", "true")
+			handler.ServeHTTP(recorder, req)
+
+			bypassed := recorder.Header().Get("Cache-Control") == ""
+			if bypassed != tc.expectedBypass {
+				t.Errorf("Expected bypassed=%v, got %v", tc.expectedBypass, bypassed)
+			}
+
+			// The caller's config is not modified
+			if cfg.ResponseHeaders["Cache-Control"] != " max-age=3600, public\t" {
+				t.Errorf("Config was modified: %q", cfg.ResponseHeaders["Cache-Control"])
+			}
+		})
+	}
+}
+
+func TestTrimValues_StatusRules(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.TrimValues = true
+	cfg.StatusRules = []add_missing_headers.StatusRule{
+		{Statuses: "5xx", ResponseHeaders: map[string]string{"Cache-Control": " no-store\t"}},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadGateway)
+	})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	assertResponseHeader(t, recorder, "Cache-Control", "no-store")
+
+	// The caller's config is not modified
+	if got := cfg.StatusRules[0].ResponseHeaders["Cache-Control"]; got != " no-store\t" {
+		t.Errorf("Config was modified: %q", got)
+	}
+}
+
+func TestTrimValues_RequestSizeHeaders(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.TrimValues = true
+	cfg.RequestSizeHeaders = map[string]map[string]string{
+		"4": {"X-Large-Upload": "\ttrue "},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assertHeader(t, req, "X-Large-Upload", "true")
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "http://localhost", strings.NewReader("payload"))
+	newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+
+	// The caller's config is not modified
+	if got := cfg.RequestSizeHeaders["4"]["X-Large-Upload"]; got != "\ttrue " {
+		t.Errorf("Config was modified: %q", got)
+	}
+}
+
+func TestTrimValues_WeightedHeaders(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.TrimValues = true
+	cfg.WeightedHeaders = map[string][]add_missing_headers.WeightedValue{
+		"X-Variant": {{Value: " A\t", Weight: 1}},
+	}
+
+	if variants := weightedVariants(t, cfg, 42, 1); variants[0] != "A" {
+		t.Errorf("Expected X-Variant %q, got %q", "A", variants[0])
+	}
+
+	// The caller's config is not modified
+	if got := cfg.WeightedHeaders["X-Variant"][0].Value; got != " A\t" {
+		t.Errorf("Config was modified: %q", got)
+	}
+}
+
+func TestTrimValues_FallbackHeaders(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.TrimValues = true
+	cfg.FallbackHeaders = map[string]add_missing_headers.FallbackHeader{
+		"X-User-Id": {Sources: []string{"X-Auth-User"}, Default: " anonymous\n"},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assertHeader(t, req, "X-User-Id", "anonymous")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+
+	// The caller's config is not modified
+	if got := cfg.FallbackHeaders["X-User-Id"].Default; got != " anonymous\n" {
+		t.Errorf("Config was modified: %q", got)
+	}
+}
+
+func TestTrimValues_SkipIfResponseHeaderPresent(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.TrimValues = true
+	cfg.ResponseHeaders["X-Frame-Options"] = "DENY"
+	cfg.SkipIfResponseHeaderPresent = map[string]string{" X-Error-Page\t": " traefik "}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Error-Page", "traefik")
+		rw.WriteHeader(http.StatusBadGateway)
+	})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	assertResponseHeader(t, recorder, "X-Frame-Options", "")
+
+	// The caller's config is not modified
+	if _, ok := cfg.SkipIfResponseHeaderPresent[" X-Error-Page\t"]; !ok {
+		t.Error("Config was modified")
+	}
+}
+
+func TestTrimValues_ResponseHeaderRequestConditions(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.TrimValues = true
+	cfg.ResponseHeaders["X-Compressed"] = "true"
+	cfg.ResponseHeaderRequestConditions = map[string]map[string]string{
+		"X-Compressed": {"Accept-Encoding ": "gzip\t"},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	newTestHandler(t, cfg, next).ServeHTTP(recorder, req)
+
+	assertResponseHeader(t, recorder, "X-Compressed", "true")
+
+	// The caller's config is not modified
+	if got := cfg.ResponseHeaderRequestConditions["X-Compressed"]["Accept-Encoding "]; got != "gzip\t" {
+		t.Errorf("Config was modified: %q", got)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WeightedValue is one of the values of a weighted header, picked with a probability
+// proportional to its weight.
+type WeightedValue struct {
+	Value  string `json:"value,omitempty" yaml:"value,omitempty"`
+	Weight int    `json:"weight,omitempty" yaml:"weight,omitempty"`
+}
+
+// weightedHeader is a compiled weighted request header.
+type weightedHeader struct {
+	key    string
+	values []string
+	// bounds are the cumulative weights, values[i] is picked for draws below bounds[i].
+	bounds []int
+	total  int
+}
+
+// lockedRand is a random number generator safe for concurrent use.
+type lockedRand struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// newLockedRand returns a generator reading from src.
+func newLockedRand(src rand.Source) *lockedRand {
+	return &lockedRand{rnd: rand.New(src)}
+}
+
+// Intn returns a random number in [0, n).
+func (r *lockedRand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rnd.Intn(n)
+}
+
+// compileWeightedHeaders compiles weighted headers, sorted by header name.
+func compileWeightedHeaders(config map[string][]WeightedValue) ([]weightedHeader, error) {
+	seen := make(map[string]string, len(config))
+	headers := make([]weightedHeader, 0, len(config))
+	for key, choices := range config {
+		canonicalKey, err := canonicalHeaderName(seen, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(choices) == 0 {
+			return nil, fmt.Errorf("header %q: no values", key)
+		}
+
+		h := weightedHeader{key: canonicalKey}
+		for i, choice := range choices {
+			if choice.Weight <= 0 {
+				return nil, fmt.Errorf("header %q: value %d: %w: must be positive, got %d", key, i, ErrInvalidWeight, choice.Weight)
+			}
+			if h.total > math.MaxInt-choice.Weight {
+				return nil, fmt.Errorf("header %q: value %d: %w: total weight overflows", key, i, ErrInvalidWeight)
+			}
+			h.total += choice.Weight
+			h.values = append(h.values, choice.Value)
+			h.bounds = append(h.bounds, h.total)
+		}
+
+		headers = append(headers, h)
+	}
+
+	sort.Slice(headers, func(i, j int) bool { return headers[i].key < headers[j].key })
+
+	return headers, nil
+}
+
+// pick returns a value drawn from r according to the weights.
+func (h *weightedHeader) pick(r *lockedRand) string {
+	draw := r.Intn(h.total)
+	return h.values[sort.SearchInts(h.bounds, draw+1)]
+}
+
+// addWeightedHeaders adds missing weighted request headers. A value is only drawn
+// for headers that are actually added.
+func (p *Plugin) addWeightedHeaders(header http.Header) {
+	for i := range p.weightedHeaders {
+		h := &p.weightedHeaders[i]
+		if !p.shouldAddHeader(header, h.key) {
+			continue
+		}
+		if value, ok := p.checkValue(h.key, h.pick(p.rand)); ok {
+			header.Set(h.key, value)
+		}
+	}
+}
+
+// defaultRandSource returns the source used when WithRandSource isn't given.
+func defaultRandSource() rand.Source {
+	return rand.NewSource(time.Now().UnixNano())
+}
@@ -0,0 +1,119 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+// weightedVariants sends requests through a handler picking X-Variant with a fixed seed,
+// and returns the variants in the order they were picked.
+func weightedVariants(t *testing.T, cfg *add_missing_headers.Config, seed int64, requests int) []string {
+	t.Helper()
+
+	var variants []string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		variants = append(variants, req.Header.Get("X-Variant"))
+	})
+
+	handler, err := add_missing_headers.NewWithOptions(context.Background(), next, cfg, "test-plugin",
+		add_missing_headers.WithRandSource(rand.NewSource(seed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < requests; i++ {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	return variants
+}
+
+func TestWeightedHeaders(t *testing.T) {
+	const requests = 10000
+
+	cfg := add_missing_headers.CreateConfig()
+	cfg.WeightedHeaders = map[string][]add_missing_headers.WeightedValue{
+		"x-variant": {{Value: "A", Weight: 1}, {Value: "B", Weight: 3}},
+	}
+
+	variants := weightedVariants(t, cfg, 42, requests)
+
+	counts := make(map[string]int)
+	for _, v := range variants {
+		counts[v]++
+	}
+	if len(counts) != 2 || counts["A"]+counts["B"] != requests {
+		t.Fatalf("Expected only A and B, got %v", counts)
+	}
+	// 2500 expected, the bounds are over 5 standard deviations away
+	if counts["A"] < 2300 || counts["A"] > 2700 {
+		t.Errorf("Expected about 25%% of A, got %d of %d", counts["A"], requests)
+	}
+
+	// The same seed picks the same sequence
+	if again := weightedVariants(t, cfg, 42, requests); !reflect.DeepEqual(variants, again) {
+		t.Error("Expected the same variants for the same seed")
+	}
+}
+
+func TestWeightedHeaders_Existing(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+	cfg.WeightedHeaders = map[string][]add_missing_headers.WeightedValue{
+		"X-Variant": {{Value: "A", Weight: 1}, {Value: "B", Weight: 1}},
+	}
+	cfg.RequestHeaders["X-Variant"] = "static"
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assertHeader(t, req, "X-Variant", "pinned")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("X-Variant", "pinned")
+
+	newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestWeightedHeaders_Invalid(t *testing.T) {
+	testCases := []struct {
+		name    string
+		headers map[string][]add_missing_headers.WeightedValue
+	}{
+		{"No values", map[string][]add_missing_headers.WeightedValue{"X-Variant": {}}},
+		{"Zero weight", map[string][]add_missing_headers.WeightedValue{"X-Variant": {{Value: "A"}}}},
+		{"Negative weight", map[string][]add_missing_headers.WeightedValue{"X-Variant": {{Value: "A", Weight: -1}}}},
+		{"Invalid name", map[string][]add_missing_headers.WeightedValue{"X Variant": {{Value: "A", Weight: 1}}}},
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.WeightedHeaders = tc.headers
+
+			if _, err := add_missing_headers.New(context.Background(), next, cfg, "test-plugin"); err == nil {
+				t.Error("Expected an error from New")
+			}
+		})
+	}
+}
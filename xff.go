@@ -0,0 +1,39 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// forwardedForHeader lists the client and proxy addresses a request went through.
+const forwardedForHeader = "X-Forwarded-For"
+
+// appendForwardedFor appends the remote address's IP to the X-Forwarded-For chain,
+// joining multiple header lines into one, or creates the header when absent.
+// Requests without a parsable remote address are left unchanged.
+func appendForwardedFor(req *http.Request) {
+	ip := remoteIP(req)
+	if ip == nil {
+		return
+	}
+
+	chain := ip.String()
+	if prior := req.Header.Values(forwardedForHeader); len(prior) > 0 {
+		chain = strings.Join(prior, ", ") + ", " + chain
+	}
+	req.Header.Set(forwardedForHeader, chain)
+}
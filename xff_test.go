@@ -0,0 +1,85 @@
+// Copyright 2025 Giacomo Ferretti
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package add_missing_headers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	add_missing_headers "github.com/giacomoferretti/add-missing-headers"
+)
+
+func TestManageXFF(t *testing.T) {
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		existing   []string
+		expected   []string
+	}{
+		{"Absent", "192.0.2.1:1234", nil, []string{"192.0.2.1"}},
+		{"Present", "192.0.2.1:1234", []string{"203.0.113.7"}, []string{"203.0.113.7, 192.0.2.1"}},
+		{"Multiple lines", "192.0.2.1:1234", []string{"203.0.113.7", "198.51.100.2"}, []string{"203.0.113.7, 198.51.100.2, 192.0.2.1"}},
+		{"IPv6 without port", "2001:db8::1", nil, []string{"2001:db8::1"}},
+		{"Unparsable remote address", "@", []string{"203.0.113.7"}, []string{"203.0.113.7"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := add_missing_headers.CreateConfig()
+			cfg.ManageXFF = true
+
+			var values []string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				values = req.Header.Values("X-Forwarded-For")
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, value := range tc.existing {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+
+			if len(values) != len(tc.expected) {
+				t.Fatalf("Expected X-Forwarded-For %q, got %q", tc.expected, values)
+			}
+			for i := range values {
+				if values[i] != tc.expected[i] {
+					t.Errorf("Expected X-Forwarded-For %q, got %q", tc.expected, values)
+				}
+			}
+		})
+	}
+}
+
+func TestManageXFF_Disabled(t *testing.T) {
+	cfg := add_missing_headers.CreateConfig()
+
+	var values []string
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		values = req.Header.Values("X-Forwarded-For")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+	newTestHandler(t, cfg, next).ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(values) != 1 || values[0] != "203.0.113.7" {
+		t.Errorf("Expected X-Forwarded-For to be unchanged, got %q", values)
+	}
+}